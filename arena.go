@@ -0,0 +1,379 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Storage modes for Memory, selected with the "storage" config key.
+const (
+	StorageHeap  = "heap"  // One *Entry per key in a Go map (default)
+	StorageArena = "arena" // []byte / string values serialized into pointer-free ring buffers
+)
+
+const defaultArenaBytes = 64 << 20 // Default total arena size when maxBytes is not set
+
+// maxArenaShard is the largest arena of one bucket: ring offsets and record
+// sizes are uint32.
+const maxArenaShard = math.MaxUint32
+
+// ErrArenaValue is returned when writing a value other than []byte or string
+// to a Memory using StorageArena. Use EncodeValuer to store other types.
+var ErrArenaValue = errors.New("cache: arena storage only holds []byte and string values")
+
+// ErrTooLarge is returned when an entry does not fit into an arena shard.
+var ErrTooLarge = errors.New("cache: entry too large")
+
+// Record layout: header, then key bytes, then value bytes.
+//
+//	[0:4]   record length, header included
+//	[4:12]  key hash
+//	[12:20] CreatedAt
+//	[20:28] ExpiredAt
+//	[28]    flags
+//	[29:33] key length
+//	[33:37] value length
+//	[37:45] Version
+const arenaHeaderSize = 45
+
+const (
+	arenaDeleted = 1 << iota // Record was deleted, replaced or evicted
+	arenaString              // Value was written as a string
+)
+
+// arenaRecord is a decoded record header.
+type arenaRecord struct {
+	size      uint32
+	hash      uint64
+	createdAt int64
+	expiredAt int64
+	flags     byte
+	keyLen    uint32
+	valLen    uint32
+	version   uint64
+}
+
+// arena stores the entries of one bucket as records appended to a ring buffer.
+// It holds no pointers besides the buffer itself, so the garbage collector does
+// not scan it entry by entry. When the ring is full, the oldest records are
+// overwritten; deleted records stay in place until then, as tombstones.
+// Callers must serialize access (the bucket lock).
+type arena struct {
+	buf   []byte
+	head  uint64            // Virtual offset of the next record
+	tail  uint64            // Virtual offset of the oldest record
+	index map[uint64]uint32 // Key hash -> ring offset of the live record, one per hash
+	live  int64             // Bytes taken by live records
+}
+
+func newArena(size int) *arena {
+	return &arena{buf: make([]byte, size), index: make(map[uint64]uint32)}
+}
+
+// fits reports whether a record for key and a value of n bytes can be stored.
+func (a *arena) fits(key string, n int) bool {
+	return uint64(arenaHeaderSize+len(key)+n) <= uint64(len(a.buf))
+}
+
+// read copies len(dst) bytes starting at ring offset off, wrapping around the end.
+func (a *arena) read(off uint32, dst []byte) {
+	n := copy(dst, a.buf[off:])
+	copy(dst[n:], a.buf)
+}
+
+// write copies src to ring offset off, wrapping around the end.
+func (a *arena) write(off uint32, src []byte) {
+	n := copy(a.buf[off:], src)
+	copy(a.buf, src[n:])
+}
+
+// advance returns ring offset off moved forward by n bytes.
+func (a *arena) advance(off uint32, n uint32) uint32 {
+	return uint32((uint64(off) + uint64(n)) % uint64(len(a.buf)))
+}
+
+func (a *arena) header(off uint32) arenaRecord {
+	var h [arenaHeaderSize]byte
+	a.read(off, h[:])
+	return arenaRecord{
+		size:      binary.LittleEndian.Uint32(h[0:4]),
+		hash:      binary.LittleEndian.Uint64(h[4:12]),
+		createdAt: int64(binary.LittleEndian.Uint64(h[12:20])),
+		expiredAt: int64(binary.LittleEndian.Uint64(h[20:28])),
+		flags:     h[28],
+		keyLen:    binary.LittleEndian.Uint32(h[29:33]),
+		valLen:    binary.LittleEndian.Uint32(h[33:37]),
+		version:   binary.LittleEndian.Uint64(h[37:45]),
+	}
+}
+
+// key returns a copy of the key of the record at off.
+func (a *arena) key(off uint32, r arenaRecord) string {
+	b := make([]byte, r.keyLen)
+	a.read(a.advance(off, arenaHeaderSize), b)
+	return BytesToStr(b)
+}
+
+// value returns a copy of the value of the record at off, as it was written.
+func (a *arena) value(off uint32, r arenaRecord) interface{} {
+	b := make([]byte, r.valLen)
+	a.read(a.advance(off, arenaHeaderSize+r.keyLen), b)
+	if r.flags&arenaString != 0 {
+		return BytesToStr(b)
+	}
+	return b
+}
+
+// keyEquals compares the key of the record at off with key without allocating.
+func (a *arena) keyEquals(off uint32, r arenaRecord, key string) bool {
+	if int(r.keyLen) != len(key) {
+		return false
+	}
+	start := a.advance(off, arenaHeaderSize)
+	n := len(a.buf) - int(start)
+	if n >= len(key) {
+		return string(a.buf[start:int(start)+len(key)]) == key
+	}
+	return string(a.buf[start:]) == key[:n] && string(a.buf[:len(key)-n]) == key[n:]
+}
+
+// lookup returns the live record stored for key, whose hash is hash.
+func (a *arena) lookup(hash uint64, key string) (uint32, arenaRecord, bool) {
+	off, ok := a.index[hash]
+	if !ok {
+		return 0, arenaRecord{}, false
+	}
+	r := a.header(off)
+	if !a.keyEquals(off, r, key) {
+		return 0, arenaRecord{}, false // Another key with the same hash
+	}
+	return off, r, true
+}
+
+// remove marks the record at off as deleted and drops it from the index.
+func (a *arena) remove(off uint32, r arenaRecord) {
+	a.buf[a.advance(off, 28)] |= arenaDeleted
+	delete(a.index, r.hash)
+	a.live -= int64(r.size)
+}
+
+// setExpiredAt updates the deadline of the record at off in place.
+func (a *arena) setExpiredAt(off uint32, expiredAt int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(expiredAt))
+	a.write(a.advance(off, 20), b[:])
+}
+
+// push appends a record, overwriting the oldest records if the ring is full.
+// evict is called for each live record before it is overwritten and removed.
+// The caller removes any previous record for hash first and checks fits.
+func (a *arena) push(hash uint64, key string, createdAt, expiredAt int64, version uint64, val string, flags byte, evict func(off uint32, r arenaRecord)) {
+	size := uint32(arenaHeaderSize + len(key) + len(val))
+	for a.head+uint64(size)-a.tail > uint64(len(a.buf)) {
+		off := uint32(a.tail % uint64(len(a.buf)))
+		r := a.header(off)
+		if r.flags&arenaDeleted == 0 {
+			evict(off, r)
+			a.remove(off, r)
+		}
+		a.tail += uint64(r.size)
+	}
+
+	var h [arenaHeaderSize]byte
+	binary.LittleEndian.PutUint32(h[0:4], size)
+	binary.LittleEndian.PutUint64(h[4:12], hash)
+	binary.LittleEndian.PutUint64(h[12:20], uint64(createdAt))
+	binary.LittleEndian.PutUint64(h[20:28], uint64(expiredAt))
+	h[28] = flags
+	binary.LittleEndian.PutUint32(h[29:33], uint32(len(key)))
+	binary.LittleEndian.PutUint32(h[33:37], uint32(len(val)))
+	binary.LittleEndian.PutUint64(h[37:45], version)
+
+	off := uint32(a.head % uint64(len(a.buf)))
+	a.write(off, h[:])
+	a.write(a.advance(off, arenaHeaderSize), StrToBytes(key))
+	a.write(a.advance(off, arenaHeaderSize+uint32(len(key))), StrToBytes(val))
+	a.head += uint64(size)
+	a.index[hash] = off
+	a.live += int64(size)
+}
+
+// reset drops all records, keeping the buffer.
+func (a *arena) reset() {
+	a.head, a.tail, a.live = 0, 0, 0
+	a.index = make(map[uint64]uint32)
+}
+
+// arenaValue returns v as a string for storage and its arena flags,
+// or ok == false if v is neither []byte nor string.
+func arenaValue(v interface{}) (s string, flags byte, ok bool) {
+	switch d := v.(type) {
+	case []byte:
+		return BytesToStr(d), 0, true
+	case string:
+		return d, arenaString, true
+	}
+	return "", 0, false
+}
+
+// ==================== Bucket glue ====================
+
+// initArenas creates n arena buckets sharing the byte budget evenly (rounded up).
+// Shares above maxArenaShard are clamped, and the clamp is logged.
+func (m *Memory) initArenas(n int) {
+	size, ok := arenaShardSize(m.arenaBytes(), n)
+	if !ok {
+		m.log("cache: arena of", m.arenaBytes(), "bytes over", n, "shards exceeds", int64(maxArenaShard),
+			"bytes per shard; clamped, use more shards for a larger arena")
+		m.maxBytes = size * int64(n)
+	}
+	for i := range m.buckets {
+		m.buckets[i] = &bucket{m: m, arena: newArena(int(size))}
+	}
+}
+
+// arenaShardSize returns the arena size of each of n buckets sharing total
+// bytes, or maxArenaShard and false if the share is larger.
+func arenaShardSize(total int64, n int) (int64, bool) {
+	size := (total + int64(n) - 1) / int64(n)
+	if size > maxArenaShard {
+		return maxArenaShard, false
+	}
+	return size, true
+}
+
+// arenaBytes returns the total arena size: maxBytes, or 64MB if unset.
+func (m *Memory) arenaBytes() int64 {
+	if m.maxBytes > 0 {
+		return m.maxBytes
+	}
+	return defaultArenaBytes
+}
+
+// arenaEntry decodes the record at off into a detached Entry with a copy of
+// its value. Changing it does not affect the cache.
+func (b *bucket) arenaEntry(off uint32, r arenaRecord, key string) *Entry {
+	return &Entry{
+		CreatedAt: r.createdAt,
+		ExpiredAt: r.expiredAt,
+		Value:     b.arena.value(off, r),
+		Version:   r.version,
+		clock:     b.m.clock,
+		key:       key,
+		size:      int64(r.size),
+	}
+}
+
+// arenaLoad returns a detached copy of the entry stored under key.
+// Must be called with bucket lock (read or write) held.
+func (b *bucket) arenaLoad(key string) (*Entry, uint32, bool) {
+	off, r, ok := b.arena.lookup(b.m.hasher(key), key)
+	if !ok {
+		return nil, 0, false
+	}
+	return b.arenaEntry(off, r, key), off, true
+}
+
+// arenaDrop removes the record at off, reporting it to the expire handlers for reason.
+// Must be called with bucket lock held.
+func (b *bucket) arenaDrop(off uint32, r arenaRecord, reason ExpireReason) {
+	if b.m.hasHandler(reason) {
+		b.emit(nil, b.arenaEntry(off, r, b.arena.key(off, r)), reason)
+	}
+	b.arena.remove(off, r)
+}
+
+// arenaSet stores e under key. The index keeps one record per hash, so a
+// different key with the same hash is compared out and evicted, with its
+// event and eviction count, instead of being overwritten silently.
+// The value must have passed checkArenaValue.
+// Must be called with bucket lock held.
+func (b *bucket) arenaSet(key string, e *Entry) {
+	a := b.arena
+	hash := b.m.hasher(key)
+	if off, ok := a.index[hash]; ok {
+		r := a.header(off)
+		reason := ReasonEvicted // A different key with the same hash gives way
+		if a.keyEquals(off, r, key) {
+			reason = ReasonReplaced
+			if arenaExpired(r, b.m.now()) {
+				reason = ReasonExpired // Expired but not yet swept
+			}
+		} else {
+			b.evictions++
+		}
+		b.arenaDrop(off, r, reason)
+	}
+	b.arenaPush(hash, key, e)
+}
+
+// arenaUpdate writes back an entry changed in Tx, without reporting the old version.
+// Must be called with bucket lock held.
+func (b *bucket) arenaUpdate(e *Entry) error {
+	if err := b.checkArenaValue(e.key, e.Value); err != nil {
+		return err
+	}
+	hash := b.m.hasher(e.key)
+	if off, r, ok := b.arena.lookup(hash, e.key); ok {
+		b.arena.remove(off, r)
+	}
+	b.arenaPush(hash, e.key, e)
+	return nil
+}
+
+// arenaPush appends e to the ring; live records it overwrites are reported as
+// evicted, or expired if their deadline has passed.
+// Must be called with bucket lock held.
+func (b *bucket) arenaPush(hash uint64, key string, e *Entry) {
+	a := b.arena
+	val, flags, _ := arenaValue(e.Value)
+	now := b.m.now()
+	a.push(hash, key, e.CreatedAt, e.ExpiredAt, e.Version, val, flags, func(off uint32, r arenaRecord) {
+		reason := ReasonExpired
+		if !arenaExpired(r, now) {
+			reason = ReasonEvicted
+			b.evictions++
+		}
+		if b.m.hasHandler(reason) {
+			b.emit(nil, b.arenaEntry(off, r, a.key(off, r)), reason)
+		}
+	})
+}
+
+func arenaExpired(r arenaRecord, now int64) bool {
+	return r.expiredAt >= 0 && r.expiredAt <= now
+}
+
+// arenaCleanup removes all expired records.
+// Must be called with bucket lock held.
+func (b *bucket) arenaCleanup() {
+	now := b.m.now()
+	for _, off := range b.arena.index {
+		r := b.arena.header(off)
+		if arenaExpired(r, now) {
+			b.arenaDrop(off, r, ReasonExpired)
+		}
+	}
+}
+
+// arenaEach calls fn with a detached copy of every live entry.
+// Must be called with bucket lock (read or write) held.
+func (b *bucket) arenaEach(fn func(e *Entry)) {
+	for _, off := range b.arena.index {
+		r := b.arena.header(off)
+		fn(b.arenaEntry(off, r, b.arena.key(off, r)))
+	}
+}
+
+// checkArenaValue validates a value before it is written to an arena bucket.
+func (b *bucket) checkArenaValue(key string, v interface{}) error {
+	s, _, ok := arenaValue(v)
+	if !ok {
+		return ErrArenaValue
+	}
+	if !b.arena.fits(key, len(s)) {
+		return ErrTooLarge
+	}
+	return nil
+}
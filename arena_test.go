@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArenaWrapAround(t *testing.T) {
+	a := newArena(256)
+	var evicted []string
+	evict := func(off uint32, r arenaRecord) { evicted = append(evicted, a.key(off, r)) }
+
+	// Records of 45+2+12 bytes: the fifth one wraps around the end of the ring.
+	for i := 0; i < 10; i++ {
+		key := "k" + strconv.Itoa(i)
+		a.push(uint64(i), key, 1, -1, 1, strings.Repeat(strconv.Itoa(i), 12), arenaString, evict)
+		off, r, ok := a.lookup(uint64(i), key)
+		if !ok {
+			t.Fatalf("%s: not found right after push", key)
+		}
+		if v := a.value(off, r); v != strings.Repeat(strconv.Itoa(i), 12) {
+			t.Fatalf("%s: unexpected value %q", key, v)
+		}
+	}
+	if len(a.index) != 4 || a.live != 4*59 {
+		t.Fatalf("expected 4 live records, got %d (%d bytes)", len(a.index), a.live)
+	}
+	if strings.Join(evicted, ",") != "k0,k1,k2,k3,k4,k5" {
+		t.Fatalf("expected oldest records evicted first, got %v", evicted)
+	}
+	if _, _, ok := a.lookup(9, "other"); ok {
+		t.Fatal("expected a miss for a different key with the same hash")
+	}
+
+	off, r, _ := a.lookup(9, "k9")
+	a.remove(off, r)
+	a.push(10, "k10", 1, -1, 1, strings.Repeat("x", 92), 0, evict)
+	if len(a.index) != 1 || a.live != 45+3+92 {
+		t.Fatalf("expected only k10 to be live, got %d records", len(a.index))
+	}
+	if strings.Join(evicted, ",") != "k0,k1,k2,k3,k4,k5,k6,k7,k8" { // k9 was a tombstone
+		t.Fatalf("unexpected evictions %v", evicted)
+	}
+}
+
+func TestArenaShardSize(t *testing.T) {
+	if size, ok := arenaShardSize(1<<30, 16); !ok || size != 1<<26 {
+		t.Fatalf("expected 64MB per shard, got %d, %v", size, ok)
+	}
+	if size, ok := arenaShardSize(maxArenaShard*2, 2); !ok || size != maxArenaShard {
+		t.Fatalf("expected the largest share to fit, got %d, %v", size, ok)
+	}
+	if size, ok := arenaShardSize(1<<40, 16); ok || size != maxArenaShard {
+		t.Fatalf("expected a 64GB share to be clamped, got %d, %v", size, ok)
+	}
+	if size, ok := arenaShardSize(1<<32, 1); ok || size != maxArenaShard {
+		t.Fatalf("expected a 4GiB shard to be clamped, got %d, %v", size, ok)
+	}
+}
+
+func TestArenaMemory(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c := NewMemory(`{"storage": "arena"}`, WithClock(clk))
+
+	if err := c.Put(ctx, "b", []byte("bytes")); err != nil {
+		t.Fatal(err)
+	}
+	c.Put(ctx, 1, "string")
+	if v, _ := c.Get(ctx, "b"); !bytes.Equal(v.([]byte), []byte("bytes")) {
+		t.Fatalf("expected bytes, got %#v", v)
+	}
+	if v, _ := c.Get(ctx, 1); v != "string" {
+		t.Fatalf("expected string, got %#v", v)
+	}
+	if err := c.Put(ctx, "n", 42); err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+	if err := c.Put(ctx, "v", EncodeValuer(map[string]int{"a": 1})); err != nil {
+		t.Fatalf("expected EncodeValuer to be accepted, got %v", err)
+	}
+
+	// Returned values are copies.
+	v, _ := c.Get(ctx, "b")
+	v.([]byte)[0] = 'X'
+	if v, _ := c.Get(ctx, "b"); string(v.([]byte)) != "bytes" {
+		t.Fatalf("expected the stored value to be unaffected, got %s", v)
+	}
+
+	c.PutEx(ctx, "ttl", "x", 10)
+	if ttl, _ := c.TTL(ctx, "ttl"); ttl != 10 {
+		t.Fatalf("expected ttl 10, got %d", ttl)
+	}
+	c.Expire(ctx, "ttl", 1)
+	clk.Advance(2 * time.Second)
+	if _, err := c.Get(ctx, "ttl"); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey after expiry, got %v", err)
+	}
+
+	err := c.Tx(ctx, "b", func(e *Entry) error {
+		e.Value = append(e.Value.([]byte), '!')
+		return nil
+	})
+	if v, _ := c.Get(ctx, "b"); err != nil || string(v.([]byte)) != "bytes!" {
+		t.Fatalf("expected Tx to write back, got %s, %v", v, err)
+	}
+	err = c.Tx(ctx, "b", func(e *Entry) error {
+		e.Value = 1
+		return nil
+	})
+	if v, _ := c.Get(ctx, "b"); err != ErrArenaValue || string(v.([]byte)) != "bytes!" {
+		t.Fatalf("expected ErrArenaValue and no change, got %s, %v", v, err)
+	}
+
+	if err := c.Del(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del(ctx, 1); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	n := 0
+	c.Range(ctx, func(k, v interface{}) error {
+		n++
+		return nil
+	})
+	if n != 2 { // "b" and "v"
+		t.Fatalf("expected 2 entries in Range, got %d", n)
+	}
+	c.Clear(ctx)
+	if st := c.(*Memory).Stats(); st.Entries != 0 || st.Bytes != 0 || st.MaxBytes != defaultArenaBytes {
+		t.Fatalf("unexpected stats after Clear: %+v", st)
+	}
+}
+
+func TestArenaMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(WithArena(4096), WithShards(1)).(*Memory)
+	events := collectEvents(t, c)
+
+	if err := c.Put(ctx, "big", make([]byte, 4096)); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		c.Put(ctx, i, strings.Repeat("v", 100))
+	}
+	st := c.Stats()
+	if st.Entries == 0 || st.Entries >= 100 || st.Bytes > 4096 || st.Evictions != uint64(100-st.Entries) {
+		t.Fatalf("expected the ring to keep the newest entries, got %+v", st)
+	}
+	if _, err := c.Get(ctx, 0); err != ErrNoKey {
+		t.Fatal("expected the oldest entry to be evicted")
+	}
+	if _, err := c.Get(ctx, 99); err != nil {
+		t.Fatal("expected the newest entry to be kept")
+	}
+	evs := events(100 - st.Entries)
+	if ev := evs[int64(0)]; ev.Reason != ReasonEvicted || ev.Value != strings.Repeat("v", 100) {
+		t.Fatalf("expected an eviction event for key 0, got %+v", ev)
+	}
+}
+
+func TestArenaMemoryCleanup(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c := NewMemory(`{"storage": "arena", "shards": 1}`, WithClock(clk)).(*Memory)
+	for i := 0; i < 10; i++ {
+		c.PutEx(ctx, i, "v", int64(i%2)+1)
+	}
+	clk.Advance(1500 * time.Millisecond)
+	c.buckets[0].cleanup()
+	if st := c.Stats(); st.Entries != 5 {
+		t.Fatalf("expected 5 entries after cleanup, got %d", st.Entries)
+	}
+}
+
+func TestArenaMemoryHashCollision(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(WithArena(0), WithShards(1), WithHasher(func(string) uint64 { return 1 })).(*Memory)
+	defer c.Close(ctx)
+	next := collectEvents(t, c)
+
+	c.Put(ctx, "a", "1")
+	c.Put(ctx, "b", "2")
+	if _, err := c.Get(ctx, "a"); err != ErrNoKey {
+		t.Fatalf("expected the colliding key to be evicted, got %v", err)
+	}
+	if v, _ := c.Get(ctx, "b"); v != "2" {
+		t.Fatalf("expected 2, got %v", v)
+	}
+	if ev := next(1)["a"]; ev.Reason != ReasonEvicted || ev.Value != "1" {
+		t.Fatalf("expected a to be reported as evicted, got %+v", ev)
+	}
+	if st := c.Stats(); st.Entries != 1 || st.Evictions != 1 || st.Bytes != 45+1+1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	c.Put(ctx, "b", "3") // Same key: a plain replace
+	if ev := next(1)["b"]; ev.Reason != ReasonReplaced || ev.Value != "2" {
+		t.Fatalf("expected b to be reported as replaced, got %+v", ev)
+	}
+	if st := c.Stats(); st.Evictions != 1 {
+		t.Fatalf("expected a replace not to count as an eviction, got %+v", st)
+	}
+}
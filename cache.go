@@ -4,11 +4,20 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"time"
 )
 
 var ErrNoKey = errors.New("cache: no key")
 
+// ErrClosed is returned by write operations on a cache that has been closed.
+var ErrClosed = errors.New("cache: closed")
+
+// NoExpiration is the TTL of entries that never expire.
+// Pass it (or any negative duration) to PutTTL / ExpireIn; TTLDuration returns it for such entries.
+const NoExpiration time.Duration = -1
+
 type Valuer = driver.Valuer
 
 type Scanner = sql.Scanner
@@ -16,7 +25,9 @@ type Scanner = sql.Scanner
 // Cache defines the interface for a thread-safe cache storage.
 // Implementations may use in-memory maps, sharded locks, or other backends.
 // Keys can be of type string, []byte, int, int64, uint64, or types with a String() method.
-// Values can be any type, and optional expiration (TTL) is supported via PutEx.
+// Values can be any type, and optional expiration (TTL) is supported via PutEx / PutTTL.
+// Second-based methods (PutEx, Expire, TTL, GetAndTTL) round remaining time up to whole seconds;
+// the Duration-based variants keep nanosecond resolution.
 type Cache interface {
 	// Get retrieves the value for a given key.
 	// Returns ErrNoKey if the key does not exist or has expired.
@@ -44,6 +55,9 @@ type Cache interface {
 	// PutEx stores a value for a key with a TTL (in seconds). If sec is negative, the entry never expires.
 	PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error
 
+	// PutTTL stores a value for a key with a TTL. If ttl is negative, the entry never expires.
+	PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error
+
 	// Del removes the key-value pair from the cache.
 	// If an ExpireHandler is set, it will be called asynchronously with the key and value.
 	Del(ctx context.Context, k interface{}) error
@@ -54,10 +68,20 @@ type Cache interface {
 	//   - Key does not exist or has expired: returns (0, ErrNoKey).
 	TTL(ctx context.Context, k interface{}) (int64, error)
 
+	// TTLDuration returns the remaining time-to-live for the key.
+	// Behavior:
+	//   - Key exists and not expired: returns (ttl, nil), where ttl = NoExpiration or >0.
+	//   - Key does not exist or has expired: returns (0, ErrNoKey).
+	TTLDuration(ctx context.Context, k interface{}) (time.Duration, error)
+
 	// Expire updates the expiration time for an existing key.
 	// If sec is negative, the key becomes non-expiring. Returns ErrNoKey if key does not exist.
 	Expire(ctx context.Context, k interface{}, sec int64) error
 
+	// ExpireIn sets an existing key to expire ttl from now.
+	// If ttl is negative, the key becomes non-expiring. Returns ErrNoKey if key does not exist.
+	ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error
+
 	// Tx executes the given function under a write lock for the specified key.
 	// The function receives the current Entry, allowing atomic read-modify-write operations.
 	Tx(ctx context.Context, k interface{}, fn func(*Entry) error) error
@@ -66,6 +90,11 @@ type Cache interface {
 	// The callback runs asynchronously and should not block.
 	ExpireHandler(h func(k interface{}, v interface{}))
 
+	// ExpireEventHandler sets a callback that receives every entry leaving the cache,
+	// with the reason (expired, deleted, evicted, replaced or cleared) and its timestamps.
+	// The callback runs asynchronously and should not block.
+	ExpireEventHandler(h func(ev ExpireEvent))
+
 	// Range iterates over all non-expired entries in the cache.
 	// The function fn is called for each entry; if fn returns an error, iteration stops.
 	// The iteration does not hold any locks, so the cache can be mutated during iteration.
@@ -73,12 +102,105 @@ type Cache interface {
 
 	// Clear removes all entries from the cache.
 	Clear(ctx context.Context) error
+
+	// Close stops background work such as expiration sweeps and waits until pending
+	// expire callbacks have returned or ctx is done. After Close, writes return ErrClosed.
+	// Closing an already closed cache is a no-op.
+	Close(ctx context.Context) error
+}
+
+// ValuePreserver is implemented by backends that can report whether they return
+// stored values unchanged (same dynamic type), rather than an encoded form such as []byte.
+// NewTypedCache uses it to choose a codec.
+type ValuePreserver interface {
+	PreservesValues() bool
+}
+
+// KeyNormalizer is implemented by backends whose storage makes keys of different
+// types address the same entry, such as mysql.MysqlCache, which stores 1 and "1"
+// in the same row. NormalizeKey returns k in the backend's key form; two keys
+// address the same entry if their normalized forms are the same key to Memory.
+// Wrappers that keep state per key, such as WriteBehind, use it to key that
+// state the way the backend does.
+type KeyNormalizer interface {
+	NormalizeKey(k interface{}) interface{}
+}
+
+// normalizeKey returns k in the key form of c, see KeyNormalizer.
+func normalizeKey(c Cache, k interface{}) interface{} {
+	if n, ok := c.(KeyNormalizer); ok {
+		return n.NormalizeKey(k)
+	}
+	return k
+}
+
+// DurationGetter is implemented by backends that can return a value together
+// with its exact remaining TTL in one read, where GetAndTTL rounds it up to
+// whole seconds. See GetAndTTLDuration.
+type DurationGetter interface {
+	GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error)
+}
+
+// GetAndTTLDuration returns the value of k and its remaining TTL, NoExpiration
+// if it never expires. It uses c's DurationGetter if it has one, or else Get
+// followed by TTLDuration.
+func GetAndTTLDuration(ctx context.Context, c Cache, k interface{}) (interface{}, time.Duration, error) {
+	if g, ok := c.(DurationGetter); ok {
+		return g.GetAndTTLDuration(ctx, k)
+	}
+	v, err := c.Get(ctx, k)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl, err := c.TTLDuration(ctx, k)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, ttl, nil
+}
+
+// storedBytes returns a resolved value the way byte-oriented backends return it:
+// []byte and string as []byte, anything else JSON-encoded. Wrappers that serve
+// values before they reach such a backend use it to return the same type.
+func storedBytes(v interface{}) (interface{}, error) {
+	switch d := v.(type) {
+	case nil, []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	case json.RawMessage:
+		return []byte(d), nil
+	default:
+		return json.Marshal(d)
+	}
 }
 
 type Entry struct {
-	CreatedAt int64       // Creation timestamp (Unix seconds)
-	ExpiredAt int64       // Expiration timestamp (Unix seconds), -1 = never expire
+	CreatedAt int64       // Creation timestamp (Unix nanoseconds)
+	ExpiredAt int64       // Expiration timestamp (Unix nanoseconds), -1 = never expire
 	Value     interface{} // Stored value
+	Version   uint64      // Changes on every write of the value, see ConditionalCache
+
+	key        string      // Bucket map key, set when stored in Memory
+	size       int64       // Approximate key + value size in bytes, set by Memory
+	prev, next *Entry      // Eviction list links, owned by the bucket policy
+	seg        uint8       // Eviction list segment (W-TinyLFU)
+	clock      Clock       // Time source of the owning cache, nil = SystemClock
+	timer      *wheelTimer // Expiry timer when Memory uses a timing wheel
+}
+
+// NewEntry returns an Entry whose expiration methods use clock (nil = SystemClock).
+// Backends use it to hand entries to Tx callbacks under their own clock.
+func NewEntry(clock Clock, v interface{}, createdAt, expiredAt int64) *Entry {
+	return &Entry{CreatedAt: createdAt, ExpiredAt: expiredAt, Value: v, clock: clock}
+}
+
+// nowNano returns the current Unix time in nanoseconds according to the entry's clock.
+func (e *Entry) nowNano() int64 {
+	if e.clock != nil {
+		return e.clock.Now().UnixNano()
+	}
+	return now()
 }
 
 func (e *Entry) Expired() bool {
@@ -88,33 +210,67 @@ func (e *Entry) Expired() bool {
 	if e.ExpiredAt < 0 {
 		return false // Never expires
 	}
-	return e.ExpiredAt <= now()
+	return e.ExpiredAt <= e.nowNano()
 }
 
-// TTL returns the remaining TTL in seconds for the entry.
+// TTL returns the remaining TTL in seconds for the entry, rounded up.
 // Returns -1 if the entry never expires. Returns 0 if the entry is nil or has expired.
 // This method does not trigger any deletion; it just computes the value.
 func (e *Entry) TTL() int64 {
+	return TTLSeconds(e.TTLDuration())
+}
+
+// TTLDuration returns the remaining TTL for the entry.
+// Returns NoExpiration if the entry never expires. Returns 0 if the entry is nil or has expired.
+func (e *Entry) TTLDuration() time.Duration {
 	if e == nil {
 		return 0
 	}
 	if e.ExpiredAt < 0 {
-		return -1
+		return NoExpiration
 	}
-	ttl := e.ExpiredAt - now()
+	ttl := e.ExpiredAt - e.nowNano()
 	if ttl < 0 {
 		ttl = 0
 	}
-	return ttl
+	return time.Duration(ttl)
 }
 
+// Expire sets the entry to expire sec seconds from now. sec < 0 means never expire.
 func (e *Entry) Expire(sec int64) {
+	e.ExpireIn(SecondsToTTL(sec))
+}
+
+// ExpireIn sets the entry to expire ttl from now. ttl < 0 means never expire.
+func (e *Entry) ExpireIn(ttl time.Duration) {
 	if e == nil {
 		return
 	}
+	e.ExpiredAt = ExpiredAt(e.nowNano(), ttl)
+}
+
+// ExpiredAt returns the expiration timestamp (Unix nanoseconds) for an entry
+// created at nowNano with the given ttl, or -1 if ttl is negative.
+func ExpiredAt(nowNano int64, ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return nowNano + int64(ttl)
+}
+
+// SecondsToTTL converts a second-based TTL to a Duration, preserving "negative = never expire".
+func SecondsToTTL(sec int64) time.Duration {
 	if sec < 0 {
-		e.ExpiredAt = -1
-	} else {
-		e.ExpiredAt = now() + sec
+		return NoExpiration
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// TTLSeconds converts a remaining TTL to whole seconds, rounding up so that
+// a live entry never reports 0. Negative durations map to -1 (never expires).
+func TTLSeconds(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
 	}
+	return int64((ttl + time.Second - 1) / time.Second)
 }
@@ -2,6 +2,9 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
 	"sync"
 	"testing"
 )
@@ -57,16 +60,16 @@ func (c *SyncMapCache) Get(key string) (interface{}, bool) {
 	return c.m.Load(key)
 }
 
-// ================== Backend 3: Memory (256-way sharded RWMutex) ==================
+// ================== Backend 3: Memory (sharded RWMutex, 256 buckets by default) ==================
 
 type MemoryCache struct {
 	c   Cache
 	ctx context.Context
 }
 
-func NewMemoryCache() *MemoryCache {
+func NewMemoryCache(args ...interface{}) *MemoryCache {
 	return &MemoryCache{
-		c:   NewMemory(),
+		c:   NewMemory(args...),
 		ctx: context.Background(),
 	}
 }
@@ -123,6 +126,9 @@ func benchmarkReadHeavy(b *testing.B, backend CacheBackend) {
 func BenchmarkSingleMutex_ReadHeavy(b *testing.B) { benchmarkReadHeavy(b, NewSingleMutexCache()) }
 func BenchmarkSyncMap_ReadHeavy(b *testing.B)     { benchmarkReadHeavy(b, NewSyncMapCache()) }
 func BenchmarkMemory_ReadHeavy(b *testing.B)      { benchmarkReadHeavy(b, NewMemoryCache()) }
+func BenchmarkMemoryLockFree_ReadHeavy(b *testing.B) {
+	benchmarkReadHeavy(b, NewMemoryCache(WithLockFreeReads()))
+}
 
 // ================== 50% Read / 50% Write ==================
 
@@ -170,7 +176,10 @@ func benchmarkWriteHeavy(b *testing.B, backend CacheBackend) {
 
 func BenchmarkSingleMutex_WriteHeavy(b *testing.B) { benchmarkWriteHeavy(b, NewSingleMutexCache()) }
 func BenchmarkSyncMap_WriteHeavy(b *testing.B)     { benchmarkWriteHeavy(b, NewSyncMapCache()) }
-func BenchmarkMemory_WriteHeavy(b *testing.B)     { benchmarkWriteHeavy(b, NewMemoryCache()) }
+func BenchmarkMemory_WriteHeavy(b *testing.B)      { benchmarkWriteHeavy(b, NewMemoryCache()) }
+func BenchmarkMemory4096_WriteHeavy(b *testing.B) {
+	benchmarkWriteHeavy(b, NewMemoryCache(WithShards(4096)))
+}
 
 // ================== 100% Read ==================
 
@@ -189,7 +198,10 @@ func benchmarkReadOnly(b *testing.B, backend CacheBackend) {
 
 func BenchmarkSingleMutex_ReadOnly(b *testing.B) { benchmarkReadOnly(b, NewSingleMutexCache()) }
 func BenchmarkSyncMap_ReadOnly(b *testing.B)     { benchmarkReadOnly(b, NewSyncMapCache()) }
-func BenchmarkMemory_ReadOnly(b *testing.B)       { benchmarkReadOnly(b, NewMemoryCache()) }
+func BenchmarkMemory_ReadOnly(b *testing.B)      { benchmarkReadOnly(b, NewMemoryCache()) }
+func BenchmarkMemoryLockFree_ReadOnly(b *testing.B) {
+	benchmarkReadOnly(b, NewMemoryCache(WithLockFreeReads()))
+}
 
 // ================== 100% Write ==================
 
@@ -209,3 +221,91 @@ func benchmarkWriteOnly(b *testing.B, backend CacheBackend) {
 func BenchmarkSingleMutex_WriteOnly(b *testing.B) { benchmarkWriteOnly(b, NewSingleMutexCache()) }
 func BenchmarkSyncMap_WriteOnly(b *testing.B)     { benchmarkWriteOnly(b, NewSyncMapCache()) }
 func BenchmarkMemory_WriteOnly(b *testing.B)      { benchmarkWriteOnly(b, NewMemoryCache()) }
+
+// ================== Hit Ratio: LRU vs W-TinyLFU ==================
+
+const (
+	traceKeys    = 100000 // key space of the synthetic traces
+	traceLen     = 500000 // accesses per trace
+	traceCacheSz = 5000   // cache size (5% of the key space)
+)
+
+// zipfTrace returns n accesses drawn from a Zipfian distribution over keySpace keys.
+func zipfTrace(seed int64, s float64, keySpace uint64, n int) []uint64 {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, keySpace-1)
+	trace := make([]uint64, n)
+	for i := range trace {
+		trace[i] = z.Uint64()
+	}
+	return trace
+}
+
+// zipfScanTrace interleaves a Zipfian trace with sequential scans over keys
+// outside the hot range, mimicking a batch job sweeping the keyspace.
+func zipfScanTrace(seed int64, s float64, keySpace uint64, n int) []uint64 {
+	trace := zipfTrace(seed, s, keySpace, n)
+	scanKey := keySpace
+	for i := 0; i < len(trace); i += 10000 {
+		for j := i; j < i+3000 && j < len(trace); j++ {
+			trace[j] = scanKey
+			scanKey++
+		}
+	}
+	return trace
+}
+
+// hitRatio replays trace against c as a cache-aside workload: Get, then Put on miss.
+func hitRatio(c Cache, trace []uint64) float64 {
+	ctx := context.Background()
+	hits := 0
+	for _, k := range trace {
+		if _, err := c.Get(ctx, k); err == nil {
+			hits++
+			continue
+		}
+		c.Put(ctx, k, k)
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func benchmarkHitRatio(b *testing.B, policy string, gen func(int64, float64, uint64, int) []uint64) {
+	trace := gen(1, 1.01, traceKeys, traceLen)
+	b.ResetTimer()
+
+	var ratio float64
+	for i := 0; i < b.N; i++ {
+		c := NewMemory(fmt.Sprintf(`{"maxEntries": %d, "policy": %q}`, traceCacheSz, policy))
+		ratio = hitRatio(c, trace)
+	}
+	b.ReportMetric(ratio*100, "hit%")
+}
+
+func BenchmarkHitRatio_LRU_Zipf(b *testing.B)     { benchmarkHitRatio(b, PolicyLRU, zipfTrace) }
+func BenchmarkHitRatio_TinyLFU_Zipf(b *testing.B) { benchmarkHitRatio(b, PolicyTinyLFU, zipfTrace) }
+func BenchmarkHitRatio_LRU_ZipfScan(b *testing.B) { benchmarkHitRatio(b, PolicyLRU, zipfScanTrace) }
+func BenchmarkHitRatio_TinyLFU_ZipfScan(b *testing.B) {
+	benchmarkHitRatio(b, PolicyTinyLFU, zipfScanTrace)
+}
+
+// ================== GC Cost ==================
+
+// benchmarkGC measures a full collection with 1M []byte entries stored in the cache.
+func benchmarkGC(b *testing.B, args ...interface{}) {
+	c := NewMemory(args...)
+	ctx := context.Background()
+	val := make([]byte, 64)
+	for i := 0; i < 1000000; i++ {
+		c.Put(ctx, i, val[:len(val):len(val)])
+	}
+	runtime.GC()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	c.Close(ctx) // Stop the sweeper, which keeps the cache reachable
+}
+
+func BenchmarkGC_Heap(b *testing.B)  { benchmarkGC(b) }
+func BenchmarkGC_Arena(b *testing.B) { benchmarkGC(b, WithArena(256<<20)) }
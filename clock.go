@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts the time source used for expiration and background sweeps,
+// so that TTL behaviour can be tested deterministically with FakeClock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of *time.Ticker used by the caches.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the default Clock, backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t *systemTicker) C() <-chan time.Time { return t.t.C }
+func (t *systemTicker) Stop()               { t.t.Stop() }
+
+// FakeClock is a manually driven Clock for tests.
+// Time only moves when Advance is called, which also fires any tickers that became due.
+//
+// Usage:
+//
+//	clk := cache.NewFakeClock(time.Now())
+//	c := cache.NewMemory(cache.WithClock(clk))
+//	c.PutTTL(ctx, "k", "v", time.Second)
+//	clk.Advance(2 * time.Second) // "k" is now expired, no sleeping required
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock returns a FakeClock set to t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker that fires as Advance moves the clock past each period.
+// Like time.Ticker, its channel buffers one tick and drops ticks for slow receivers.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("cache: non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: c, period: d, next: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires every ticker that became due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.ch <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	c      *FakeClock
+	period time.Duration
+	next   time.Time
+	ch     chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, tt := range t.c.tickers {
+		if tt == t {
+			t.c.tickers = append(t.c.tickers[:i], t.c.tickers[i+1:]...)
+			return
+		}
+	}
+}
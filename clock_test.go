@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFakeClock(start)
+	if !clk.Now().Equal(start) {
+		t.Fatalf("expected %v, got %v", start, clk.Now())
+	}
+	clk.Advance(1500 * time.Millisecond)
+	if want := start.Add(1500 * time.Millisecond); !clk.Now().Equal(want) {
+		t.Fatalf("expected %v, got %v", want, clk.Now())
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clk := NewFakeClock(time.Unix(0, 0))
+	tk := clk.NewTicker(time.Second)
+
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+
+	clk.Advance(time.Millisecond)
+	select {
+	case tm := <-tk.C():
+		if !tm.Equal(time.Unix(1, 0)) {
+			t.Fatalf("expected tick at 1s, got %v", tm)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+
+	// several periods at once: one buffered tick, the rest are dropped
+	clk.Advance(5 * time.Second)
+	<-tk.C()
+	select {
+	case <-tk.C():
+		t.Fatal("expected dropped ticks")
+	default:
+	}
+
+	tk.Stop()
+	clk.Advance(time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
+
+func TestEntryUsesClock(t *testing.T) {
+	clk := NewFakeClock(time.Unix(100, 0))
+	e := NewEntry(clk, "v", clk.Now().UnixNano(), -1)
+
+	e.ExpireIn(10 * time.Second)
+	if e.TTLDuration() != 10*time.Second {
+		t.Fatalf("expected 10s, got %v", e.TTLDuration())
+	}
+	clk.Advance(10 * time.Second)
+	if !e.Expired() {
+		t.Fatal("expected entry to be expired")
+	}
+}
+
+func TestFakeClockDrivesSweeper(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk))
+	ctx := context.Background()
+	defer c.Close(ctx)
+
+	done := make(chan interface{}, 1)
+	c.ExpireHandler(func(k interface{}, v interface{}) {
+		done <- k
+	})
+	c.PutTTL(ctx, "sweep_k", "v", time.Second)
+
+	// The full sweep runs every 6th tick; ticks are consumed asynchronously,
+	// so keep advancing until the handler reports the expired key.
+	for i := 0; i < 1000; i++ {
+		clk.Advance(5 * time.Second)
+		select {
+		case k := <-done:
+			if k != "sweep_k" {
+				t.Fatalf("expected sweep_k, got %v", k)
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+	t.Fatal("sweeper did not expire the key")
+}
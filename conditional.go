@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// ConditionalCache is implemented by backends that write a key only if a
+// condition holds, checked atomically with the write: Memory under the bucket
+// lock, mysql.MysqlCache in a single statement.
+//
+// Every write of a value gives the entry a new Version (see Entry.Version);
+// read it with GetVersion and pass it to CompareAndSwap for optimistic updates.
+// Expired entries that were not swept yet count as absent.
+type ConditionalCache interface {
+	// PutIfAbsent stores v under k with ttl unless k exists, and reports whether it did.
+	PutIfAbsent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error)
+
+	// PutIfPresent stores v under k with ttl only if k exists, and reports whether it did.
+	PutIfPresent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error)
+
+	// GetVersion returns the value of k and its current version.
+	GetVersion(ctx context.Context, k interface{}) (interface{}, uint64, error)
+
+	// CompareAndSwap stores v under k with ttl if the entry still has version, and
+	// reports whether it did. It returns ErrNoKey if k does not exist.
+	CompareAndSwap(ctx context.Context, k interface{}, version uint64, v interface{}, ttl time.Duration) (bool, error)
+}
+
+var _ ConditionalCache = (*Memory)(nil)
+
+// PutIfAbsent stores v unless k holds a live entry.
+func (m *Memory) PutIfAbsent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error) {
+	return m.putIf(k, v, ttl, func(old *Entry) (bool, error) {
+		return old == nil, nil
+	})
+}
+
+// PutIfPresent replaces the value of a live entry.
+func (m *Memory) PutIfPresent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error) {
+	return m.putIf(k, v, ttl, func(old *Entry) (bool, error) {
+		return old != nil, nil
+	})
+}
+
+// CompareAndSwap replaces the value of k if its version is unchanged.
+// Versions are unique within a Memory, so a deleted and recreated key never
+// matches a version read before the delete.
+func (m *Memory) CompareAndSwap(ctx context.Context, k interface{}, version uint64, v interface{}, ttl time.Duration) (bool, error) {
+	return m.putIf(k, v, ttl, func(old *Entry) (bool, error) {
+		if old == nil {
+			return false, ErrNoKey
+		}
+		return old.Version == version, nil
+	})
+}
+
+// GetVersion returns the value of k and its version.
+func (m *Memory) GetVersion(ctx context.Context, k interface{}) (interface{}, uint64, error) {
+	if !m.initialized() {
+		return nil, 0, ErrNoKey
+	}
+	keyStr, b := m.bucketFor(k)
+
+	// Read under the lock: Tx changes heap entries in place.
+	b.mu.Lock()
+	e, ok := b.get(keyStr)
+	if !ok || e == nil || e.Expired() {
+		b.mu.Unlock()
+		return nil, 0, ErrNoKey
+	}
+	if b.bounded() {
+		b.policy.access(e)
+	}
+	v, version := e.Value, e.Version
+	b.mu.Unlock()
+	return v, version, nil
+}
+
+// putIf stores v under k if cond allows it. cond runs under the bucket lock
+// with the live entry stored under k, or nil if there is none.
+func (m *Memory) putIf(k interface{}, v interface{}, ttl time.Duration, cond func(old *Entry) (bool, error)) (bool, error) {
+	if err := m.ensureStarted(); err != nil {
+		return false, err
+	}
+
+	keyStr, b := m.bucketFor(k)
+
+	if vv, ok := v.(Valuer); ok {
+		var err error
+		if v, err = vv.Value(); err != nil {
+			return false, err
+		}
+	}
+	if b.arena != nil {
+		if err := b.checkArenaValue(keyStr, v); err != nil {
+			return false, err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	old, ok := b.get(keyStr)
+	if !ok || old == nil || old.Expired() {
+		old = nil
+	}
+	put, err := cond(old)
+	if err != nil || !put {
+		return false, err
+	}
+	nowTime := m.now()
+	b.set(keyStr, &Entry{CreatedAt: nowTime, ExpiredAt: ExpiredAt(nowTime, ttl), Value: v, clock: m.clock})
+	return true, nil
+}
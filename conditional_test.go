@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConditional(t *testing.T, c *Memory, clk *FakeClock) {
+	t.Helper()
+	ctx := context.Background()
+
+	if ok, err := c.PutIfPresent(ctx, "k", "a", NoExpiration); ok || err != nil {
+		t.Fatalf("expected no write to a missing key, got %v, %v", ok, err)
+	}
+	if ok, err := c.PutIfAbsent(ctx, "k", "a", time.Second); !ok || err != nil {
+		t.Fatalf("expected the first PutIfAbsent to win, got %v, %v", ok, err)
+	}
+	if ok, _ := c.PutIfAbsent(ctx, "k", "b", NoExpiration); ok {
+		t.Fatal("expected PutIfAbsent to keep the existing value")
+	}
+	v, v1, err := c.GetVersion(ctx, "k")
+	if err != nil || v != "a" {
+		t.Fatalf("expected a, got %v, %v", v, err)
+	}
+
+	if ok, err := c.CompareAndSwap(ctx, "k", v1, "c", NoExpiration); !ok || err != nil {
+		t.Fatalf("expected the swap to succeed, got %v, %v", ok, err)
+	}
+	if ok, _ := c.CompareAndSwap(ctx, "k", v1, "d", NoExpiration); ok {
+		t.Fatal("expected a stale version to fail")
+	}
+	_, v2, _ := c.GetVersion(ctx, "k")
+	if v2 == v1 {
+		t.Fatal("expected the swap to change the version")
+	}
+	c.Tx(ctx, "k", func(e *Entry) error {
+		e.Value = "tx"
+		return nil
+	})
+	if ok, _ := c.CompareAndSwap(ctx, "k", v2, "e", NoExpiration); ok {
+		t.Fatal("expected Tx to change the version")
+	}
+	if ok, _ := c.PutIfPresent(ctx, "k", "f", time.Second); !ok {
+		t.Fatal("expected PutIfPresent to replace the value")
+	}
+	if v, _ := c.Get(ctx, "k"); v != "f" {
+		t.Fatalf("expected f, got %v", v)
+	}
+
+	// Expired entries are absent, even before they are swept.
+	clk.Advance(2 * time.Second)
+	if _, _, err := c.GetVersion(ctx, "k"); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	if _, err := c.CompareAndSwap(ctx, "k", v2, "g", NoExpiration); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	if ok, _ := c.PutIfPresent(ctx, "k", "g", NoExpiration); ok {
+		t.Fatal("expected no write to an expired key")
+	}
+	if ok, _ := c.PutIfAbsent(ctx, "k", "h", NoExpiration); !ok {
+		t.Fatal("expected PutIfAbsent to replace an expired key")
+	}
+}
+
+func TestMemoryConditional(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 1000}`},
+		"lockfree": {WithLockFreeReads()},
+		"arena":    {WithArena(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clk := NewFakeClock(time.Unix(1000, 0))
+			c := NewMemory(append(opts, WithClock(clk))...).(*Memory)
+			defer c.Close(context.Background())
+			testConditional(t, c, clk)
+		})
+	}
+}
+
+func TestMemoryPutIfAbsentRace(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if ok, _ := c.PutIfAbsent(ctx, "lock", i, NoExpiration); ok {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("expected exactly one winner, got %d", wins)
+	}
+}
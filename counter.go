@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ErrNotNumber is returned by IncrBy and IncrByFloat when the stored value
+// cannot be read as a number.
+var ErrNotNumber = errors.New("cache: value is not a number")
+
+// ErrOverflow is returned by IncrBy when the result does not fit into an int64,
+// and by IncrByFloat when it is not a finite number.
+var ErrOverflow = errors.New("cache: increment would overflow")
+
+// CounterCache is implemented by backends with atomic numeric counters:
+// Memory under the bucket lock, mysql.MysqlCache in a transaction.
+//
+// A missing or expired key is created with the delta as its value and a TTL of
+// ttlIfCreate (negative = never expire); an existing key keeps its expiration.
+// Stored values are read like Int64Scanner and Float64Scanner do, so counters
+// may be initialized with Put using an int, a float64 or a decimal string.
+type CounterCache interface {
+	// IncrBy adds delta to the integer stored under k and returns the result.
+	IncrBy(ctx context.Context, k interface{}, delta int64, ttlIfCreate time.Duration) (int64, error)
+
+	// IncrByFloat adds delta to the number stored under k and returns the result.
+	IncrByFloat(ctx context.Context, k interface{}, delta float64, ttlIfCreate time.Duration) (float64, error)
+}
+
+// Incr adds 1 to the counter k, see CounterCache.
+func Incr(ctx context.Context, c CounterCache, k interface{}, ttlIfCreate time.Duration) (int64, error) {
+	return c.IncrBy(ctx, k, 1, ttlIfCreate)
+}
+
+// Decr subtracts 1 from the counter k, see CounterCache.
+func Decr(ctx context.Context, c CounterCache, k interface{}, ttlIfCreate time.Duration) (int64, error) {
+	return c.IncrBy(ctx, k, -1, ttlIfCreate)
+}
+
+// IncrValue returns the stored value v plus delta. Backends use it to implement
+// CounterCache with the same coercion and overflow checks.
+func IncrValue(v interface{}, delta int64) (int64, error) {
+	var n int64
+	if err := Int64Scanner(&n).Scan(v); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotNumber, err)
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	return n + delta, nil
+}
+
+// IncrFloatValue returns the stored value v plus delta, see IncrValue.
+func IncrFloatValue(v interface{}, delta float64) (float64, error) {
+	var f float64
+	if err := Float64Scanner(&f).Scan(v); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotNumber, err)
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrOverflow
+	}
+	return f, nil
+}
+
+var _ CounterCache = (*Memory)(nil)
+
+// IncrBy adds delta to the counter k. The result is stored as an int64, or as a
+// decimal string in arena mode.
+func (m *Memory) IncrBy(ctx context.Context, k interface{}, delta int64, ttlIfCreate time.Duration) (int64, error) {
+	var n int64
+	err := m.incr(k, ttlIfCreate, func(old interface{}, found bool) (interface{}, error) {
+		n = delta
+		if found {
+			var err error
+			if n, err = IncrValue(old, delta); err != nil {
+				return nil, err
+			}
+		}
+		if m.storage == StorageArena {
+			return strconv.FormatInt(n, 10), nil
+		}
+		return n, nil
+	})
+	return n, err
+}
+
+// IncrByFloat adds delta to the counter k. The result is stored as a float64,
+// or as a decimal string in arena mode.
+func (m *Memory) IncrByFloat(ctx context.Context, k interface{}, delta float64, ttlIfCreate time.Duration) (float64, error) {
+	var f float64
+	err := m.incr(k, ttlIfCreate, func(old interface{}, found bool) (interface{}, error) {
+		f = delta
+		if found {
+			var err error
+			if f, err = IncrFloatValue(old, delta); err != nil {
+				return nil, err
+			}
+		} else if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, ErrOverflow
+		}
+		if m.storage == StorageArena {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+		return f, nil
+	})
+	return f, err
+}
+
+// incr replaces the value of k with next(old) under the bucket lock. found is
+// false if k is missing or expired; the entry is then created with ttlIfCreate.
+// Updating a live entry is not reported to the expire handlers, like Tx.
+func (m *Memory) incr(k interface{}, ttlIfCreate time.Duration, next func(old interface{}, found bool) (interface{}, error)) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+
+	keyStr, b := m.bucketFor(k)
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	nowTime := m.now()
+	e := &Entry{CreatedAt: nowTime, ExpiredAt: ExpiredAt(nowTime, ttlIfCreate), clock: m.clock}
+	old, ok := b.get(keyStr)
+	found := ok && old != nil && !old.Expired()
+	var oldValue interface{}
+	if found {
+		oldValue = old.Value
+		e.CreatedAt, e.ExpiredAt = old.CreatedAt, old.ExpiredAt
+	}
+	v, err := next(oldValue, found)
+	if err != nil {
+		return err
+	}
+	if b.arena != nil {
+		if err := b.checkArenaValue(keyStr, v); err != nil {
+			return err
+		}
+	}
+	if found {
+		b.remove(old) // A fresh entry keeps lock-free readers off the old one
+	}
+	e.Value = v
+	b.set(keyStr, e)
+	return nil
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryCounter(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 1000}`},
+		"lockfree": {WithLockFreeReads()},
+		"arena":    {WithArena(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := NewFakeClock(time.Unix(1000, 0))
+			c := NewMemory(append(opts, WithClock(clk))...).(*Memory)
+			defer c.Close(ctx)
+
+			if n, err := Incr(ctx, c, "n", time.Minute); err != nil || n != 1 {
+				t.Fatalf("expected a missing counter to start at 1, got %d, %v", n, err)
+			}
+			clk.Advance(30 * time.Second)
+			if n, _ := c.IncrBy(ctx, "n", 10, time.Hour); n != 11 {
+				t.Fatalf("expected 11, got %d", n)
+			}
+			if n, _ := Decr(ctx, c, "n", time.Hour); n != 10 {
+				t.Fatalf("expected 10, got %d", n)
+			}
+			if ttl, _ := c.TTLDuration(ctx, "n"); ttl != 30*time.Second {
+				t.Fatalf("expected the counter to keep its expiration, got %v", ttl)
+			}
+			var n int64
+			if err := c.Scan(ctx, "n", Int64Scanner(&n)); err != nil || n != 10 {
+				t.Fatalf("expected to scan 10, got %d, %v", n, err)
+			}
+
+			// Expired counters start over.
+			clk.Advance(time.Minute)
+			if n, _ := c.IncrBy(ctx, "n", 5, NoExpiration); n != 5 {
+				t.Fatalf("expected an expired counter to restart, got %d", n)
+			}
+
+			c.Put(ctx, "s", "41")
+			if n, err := Incr(ctx, c, "s", NoExpiration); err != nil || n != 42 {
+				t.Fatalf("expected a decimal string to count, got %d, %v", n, err)
+			}
+			if f, err := c.IncrByFloat(ctx, "s", 0.5, NoExpiration); err != nil || f != 42.5 {
+				t.Fatalf("expected 42.5, got %v, %v", f, err)
+			}
+			var f float64
+			if err := c.Scan(ctx, "s", Float64Scanner(&f)); err != nil || f != 42.5 {
+				t.Fatalf("expected to scan 42.5, got %v, %v", f, err)
+			}
+
+			c.Put(ctx, "word", "abc")
+			if _, err := c.IncrByFloat(ctx, "word", 1, NoExpiration); !errors.Is(err, ErrNotNumber) {
+				t.Fatalf("expected ErrNotNumber, got %v", err)
+			}
+			if v, _ := c.Get(ctx, "word"); v != "abc" {
+				t.Fatalf("expected a failed increment to keep the value, got %v", v)
+			}
+			c.IncrBy(ctx, "max", math.MaxInt64, NoExpiration)
+			if _, err := Incr(ctx, c, "max", NoExpiration); err != ErrOverflow {
+				t.Fatalf("expected ErrOverflow, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryCounterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Incr(ctx, c, "n", NoExpiration)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Get(ctx, "n"); v != int64(800) {
+		t.Fatalf("expected 800, got %v", v)
+	}
+}
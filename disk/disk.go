@@ -0,0 +1,851 @@
+// Package disk implements cache.Cache on a local directory, for single-process
+// deployments such as edge nodes where running MySQL is overkill.
+//
+// Entries are appended to a log file; an in-memory index maps every key to the
+// position of its value, so each read is one positioned read from the file.
+// Overwritten, deleted and expired records are reclaimed by compaction, which
+// rewrites the live records into a new log and atomically replaces the old one.
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-comm/cache"
+)
+
+const (
+	logName  = "cache.log"
+	lockName = "cache.lock" // Held with flock while a DiskCache has the directory open
+)
+
+// ErrLocked is returned by New when another DiskCache, in this process or
+// another one, already has the directory open.
+var ErrLocked = errors.New("disk cache: directory is locked by another cache")
+
+type Option func(*DiskCache)
+
+func WithLogger(logger func(v ...interface{})) Option {
+	return func(c *DiskCache) { c.logger = logger }
+}
+
+// WithClock sets the time source used for expiration and the background loop ticker.
+func WithClock(clock cache.Clock) Option {
+	return func(c *DiskCache) { c.clock = clock }
+}
+
+// WithDispatch configures the worker pool that delivers expire callbacks.
+// A nil Logger in cfg falls back to the cache logger.
+func WithDispatch(cfg cache.DispatchConfig) Option {
+	return func(c *DiskCache) { c.dispatchCfg = cfg }
+}
+
+// WithCheckInterval sets how often expired entries are swept and compaction is considered.
+func WithCheckInterval(d time.Duration) Option {
+	return func(c *DiskCache) { c.checkInterval = d }
+}
+
+// WithCompactRatio sets the share of dead bytes in the log (0 < r < 1) above
+// which the background loop compacts it. Defaults to 0.5.
+func WithCompactRatio(r float64) Option {
+	return func(c *DiskCache) { c.compactRatio = r }
+}
+
+// WithSyncWrites makes every write fsync the log before returning, so that
+// acknowledged writes survive a power loss. Without it, writes survive a crash
+// of the process but may be lost if the machine goes down.
+func WithSyncWrites() Option {
+	return func(c *DiskCache) { c.syncWrites = true }
+}
+
+const (
+	defaultCheckInterval = 30 * time.Second
+	minCheckInterval     = time.Second
+	defaultCompactRatio  = 0.5
+	minCompactSize       = 1 << 20 // Logs smaller than this are never compacted automatically
+)
+
+// entry locates the current value of a key in the log.
+type entry struct {
+	off       int64 // Offset of the value
+	size      int64 // Size of the value
+	rec       int64 // Size of the put record and later expire records, reclaimed by compaction
+	createdAt int64
+	expiredAt int64
+}
+
+// DiskCache is a persistent cache backed by an append-only log in a directory.
+// Only one DiskCache may open a directory at a time; New enforces it with a
+// lock file where the platform supports flock.
+type DiskCache struct {
+	closed        int32 // Set by Close (atomic)
+	dir           string
+	mu            sync.RWMutex
+	lock          *os.File // Lock file, held until Close
+	f             *os.File
+	size          int64 // Log size, the offset of the next record
+	dead          int64 // Bytes of records no longer needed
+	index         map[string]entry
+	buf           []byte // Record encoding buffer, guarded by mu
+	checkInterval time.Duration
+	compactRatio  float64
+	syncWrites    bool
+	clock         cache.Clock
+	logger        func(v ...interface{})
+	cancel        context.CancelFunc
+	loopDone      chan struct{} // Closed when loop returns
+	expireHandler func(k interface{}, v interface{})
+	eventHandler  func(ev cache.ExpireEvent)
+	dispatchCfg   cache.DispatchConfig
+	dispatch      *cache.Dispatcher // Delivers expire callbacks
+}
+
+var _ cache.Cache = (*DiskCache)(nil)
+
+// New opens the cache stored in dir, creating the directory if needed. The log
+// is replayed to rebuild the index; a torn or corrupt tail, as left by a crash
+// in the middle of a write, is truncated and reported to the logger.
+// It returns ErrLocked if the directory is already open.
+func New(dir string, opts ...Option) (*DiskCache, error) {
+	if dir == "" {
+		return nil, errors.New("disk cache: dir is empty")
+	}
+	c := &DiskCache{
+		dir: dir, index: make(map[string]entry),
+		checkInterval: defaultCheckInterval, compactRatio: defaultCompactRatio,
+		logger: log.Println, clock: cache.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.clock == nil {
+		c.clock = cache.SystemClock
+	}
+	if c.checkInterval < minCheckInterval {
+		c.checkInterval = minCheckInterval
+	}
+	if c.compactRatio <= 0 || c.compactRatio >= 1 {
+		c.compactRatio = defaultCompactRatio
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	c.lock = lock
+	if c.dispatchCfg.Logger == nil {
+		c.dispatchCfg.Logger = c.logger
+	}
+	c.dispatch = cache.NewDispatcher(c.dispatchCfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.loopDone = make(chan struct{})
+	go c.loop(ctx, c.clock.NewTicker(c.checkInterval))
+	return c, nil
+}
+
+// open replays the log into the index and truncates anything after the last valid record.
+func (c *DiskCache) open() error {
+	path := filepath.Join(c.dir, logName)
+	if tmp, _ := filepath.Glob(path + ".compact*"); len(tmp) > 0 {
+		for _, p := range tmp {
+			os.Remove(p) // Compaction interrupted by a crash; the old log is intact
+		}
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("disk cache: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("disk cache: %w", err)
+	}
+	now := c.now()
+	end, err := replay(f, func(off int64, r *record) { c.apply(off, r, now) })
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("disk cache: %s: %w", path, err)
+	}
+	if end < st.Size() {
+		c.logger("disk cache: truncating", st.Size()-end, "bytes of torn or corrupt records in", path)
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return fmt.Errorf("disk cache: %w", err)
+		}
+	}
+	if end == 0 {
+		if _, err := f.WriteAt([]byte(logMagic), 0); err != nil {
+			f.Close()
+			return fmt.Errorf("disk cache: %w", err)
+		}
+		end = int64(len(logMagic))
+	}
+	c.f, c.size = f, end
+	return nil
+}
+
+// apply updates the index with a record replayed from the log. Expired entries
+// are dropped right away.
+func (c *DiskCache) apply(off int64, r *record, now int64) {
+	old, ok := c.index[r.key]
+	switch r.op {
+	case opPut:
+		if ok {
+			c.dead += old.rec
+		}
+		e := entry{off: r.valueOffset(off), size: int64(len(r.value)), rec: r.size(), createdAt: r.createdAt, expiredAt: r.expiredAt}
+		if expired(e.expiredAt, now) {
+			delete(c.index, r.key)
+			c.dead += e.rec
+			return
+		}
+		c.index[r.key] = e
+	case opDel:
+		if ok {
+			c.dead += old.rec
+			delete(c.index, r.key)
+		}
+		c.dead += r.size()
+	case opExpire:
+		if !ok {
+			c.dead += r.size()
+			return
+		}
+		old.rec += r.size()
+		old.expiredAt = r.expiredAt
+		if expired(old.expiredAt, now) {
+			delete(c.index, r.key)
+			c.dead += old.rec
+			return
+		}
+		c.index[r.key] = old
+	}
+}
+
+// Close stops the background loop, waits until pending expire callbacks have
+// returned or ctx is done, closes the log file and releases the directory lock.
+// After Close, all operations return cache.ErrClosed. Closing an already closed
+// cache is a no-op.
+func (c *DiskCache) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.loopDone:
+	case <-ctx.Done():
+		c.dispatch.Close(ctx) // Stop the workers without waiting
+		c.closeFile()
+		return ctx.Err()
+	}
+	err := c.closeFile()
+	if derr := c.dispatch.Close(ctx); derr != nil {
+		return derr
+	}
+	return err
+}
+
+func (c *DiskCache) closeFile() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.f.Sync()
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	c.lock.Close() // After the log, so the next owner sees every write
+	return err
+}
+
+func (c *DiskCache) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// notify hands the expire handlers to the dispatcher; events for a key are delivered in order.
+// Must be called without holding c.mu, so that handlers can use the cache.
+func (c *DiskCache) notify(ev cache.ExpireEvent) {
+	h, eh := c.expireHandler, c.eventHandler
+	if !ev.Reason.Legacy() {
+		h = nil
+	}
+	if h == nil && eh == nil {
+		return
+	}
+	c.dispatch.Dispatch(keyToString(ev.Key), func() {
+		if h != nil {
+			h(ev.Key, ev.Value)
+		}
+		if eh != nil {
+			eh(ev)
+		}
+	})
+}
+
+// hasHandler reports whether an event of reason would reach any expire handler.
+func (c *DiskCache) hasHandler(reason cache.ExpireReason) bool {
+	return c.eventHandler != nil || (c.expireHandler != nil && reason.Legacy())
+}
+
+// event describes e, stored under k, leaving the cache for reason. The value is
+// read from the log only if a handler will receive it.
+// Must be called with c.mu held.
+func (c *DiskCache) event(k interface{}, e entry, reason cache.ExpireReason) (cache.ExpireEvent, bool) {
+	if !c.hasHandler(reason) {
+		return cache.ExpireEvent{}, false
+	}
+	ev := cache.ExpireEvent{Key: k, Reason: reason, CreatedAt: e.createdAt, ExpiredAt: e.expiredAt}
+	if v, err := c.read(e); err == nil {
+		ev.Value = v
+	}
+	return ev, true
+}
+
+// NormalizeKey returns the string k is stored under: keys with the same string
+// form, such as 1 and "1", address the same entry. See cache.KeyNormalizer.
+func (c *DiskCache) NormalizeKey(k interface{}) interface{} {
+	return keyToString(k)
+}
+
+func keyToString(k interface{}) string {
+	switch d := k.(type) {
+	case string:
+		return d
+	case []byte:
+		return cache.BytesToStr(d)
+	case cache.Keyer:
+		return string(d.CacheKey())
+	default:
+		if s, ok := d.(interface{ String() string }); ok {
+			return s.String()
+		}
+		return fmt.Sprintf("%v", d)
+	}
+}
+
+// encodeValue converts v to the bytes stored in the log: []byte and string as-is,
+// cache.Valuer through its Value, everything else as JSON.
+func encodeValue(v interface{}) ([]byte, error) {
+	if vv, ok := v.(cache.Valuer); ok {
+		var err error
+		if v, err = vv.Value(); err != nil {
+			return nil, err
+		}
+	}
+	switch d := v.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	case nil:
+		return nil, nil
+	default:
+		return json.Marshal(d)
+	}
+}
+
+// now returns current Unix timestamp in nanoseconds according to the cache clock.
+func (c *DiskCache) now() int64 {
+	return c.clock.Now().UnixNano()
+}
+
+func expired(expiredAt, now int64) bool {
+	return expiredAt >= 0 && expiredAt <= now
+}
+
+// entryTTL returns the remaining TTL of an entry: cache.NoExpiration if it never expires,
+// 0 if it has expired.
+func (c *DiskCache) entryTTL(expiredAt int64) time.Duration {
+	if expiredAt < 0 {
+		return cache.NoExpiration
+	}
+	ttl := expiredAt - c.now()
+	if ttl < 0 {
+		return 0
+	}
+	return time.Duration(ttl)
+}
+
+// read loads the value of e from the log.
+// Must be called with c.mu (read or write) held.
+func (c *DiskCache) read(e entry) ([]byte, error) {
+	v := make([]byte, e.size)
+	if _, err := c.f.ReadAt(v, e.off); err != nil {
+		return nil, fmt.Errorf("disk cache: read: %w", err)
+	}
+	return v, nil
+}
+
+// append writes r at the end of the log and returns its offset. Records
+// larger than maxRecordSize are rejected with cache.ErrTooLarge.
+// Must be called with c.mu held.
+func (c *DiskCache) append(r *record) (int64, error) {
+	if !r.fits() {
+		return 0, cache.ErrTooLarge
+	}
+	c.buf = r.encode(c.buf[:0])
+	off := c.size
+	if _, err := c.f.WriteAt(c.buf, off); err != nil {
+		c.f.Truncate(off) // Drop a partial record so the next one follows a valid log
+		return 0, fmt.Errorf("disk cache: write: %w", err)
+	}
+	if c.syncWrites {
+		if err := c.f.Sync(); err != nil {
+			return 0, fmt.Errorf("disk cache: sync: %w", err)
+		}
+	}
+	c.size += int64(len(c.buf))
+	return off, nil
+}
+
+// lookup returns the live entry stored under key.
+// Must be called with c.mu (read or write) held.
+func (c *DiskCache) lookup(key string) (entry, bool) {
+	e, ok := c.index[key]
+	if !ok || c.entryTTL(e.expiredAt) == 0 {
+		return entry{}, false
+	}
+	return e, true
+}
+
+func (c *DiskCache) Get(ctx context.Context, k interface{}) (interface{}, error) {
+	v, _, err := c.getInternal(k)
+	return v, err
+}
+
+func (c *DiskCache) GetAndTTL(ctx context.Context, k interface{}) (interface{}, int64, error) {
+	v, ttl, err := c.getInternal(k)
+	return v, cache.TTLSeconds(ttl), err
+}
+
+// GetAndTTLDuration returns the value and its exact remaining TTL.
+// See cache.DurationGetter.
+func (c *DiskCache) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	return c.getInternal(k)
+}
+
+func (c *DiskCache) getInternal(k interface{}) ([]byte, time.Duration, error) {
+	if c.isClosed() {
+		return nil, 0, cache.ErrClosed
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.lookup(keyToString(k))
+	if !ok {
+		return nil, 0, cache.ErrNoKey
+	}
+	v, err := c.read(e)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, c.entryTTL(e.expiredAt), nil
+}
+
+func (c *DiskCache) TTL(ctx context.Context, k interface{}) (int64, error) {
+	ttl, err := c.TTLDuration(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return cache.TTLSeconds(ttl), nil
+}
+
+func (c *DiskCache) TTLDuration(ctx context.Context, k interface{}) (time.Duration, error) {
+	if c.isClosed() {
+		return 0, cache.ErrClosed
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.lookup(keyToString(k))
+	if !ok {
+		return 0, cache.ErrNoKey
+	}
+	return c.entryTTL(e.expiredAt), nil
+}
+
+func (c *DiskCache) Scan(ctx context.Context, k interface{}, scan cache.Scanner) error {
+	v, err := c.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return scan.Scan(v)
+}
+
+func (c *DiskCache) ScanAndTTL(ctx context.Context, k interface{}, scan cache.Scanner) (int64, error) {
+	v, ttl, err := c.getInternal(k)
+	if err != nil {
+		return 0, err
+	}
+	return cache.TTLSeconds(ttl), scan.Scan(v)
+}
+
+func (c *DiskCache) Put(ctx context.Context, k interface{}, v interface{}) error {
+	return c.PutTTL(ctx, k, v, cache.NoExpiration)
+}
+
+func (c *DiskCache) PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error {
+	return c.PutTTL(ctx, k, v, cache.SecondsToTTL(sec))
+}
+
+func (c *DiskCache) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	b, err := encodeValue(v)
+	if err != nil {
+		return fmt.Errorf("disk cache: resolve value: %w", err)
+	}
+	createdAt := c.now()
+	ev, notify, err := c.put(k, keyToString(k), b, createdAt, cache.ExpiredAt(createdAt, ttl))
+	if notify {
+		c.notify(ev)
+	}
+	return err
+}
+
+// put writes a value and updates the index, returning the event for the
+// replaced entry, if any handler wants it.
+func (c *DiskCache) put(k interface{}, key string, v []byte, createdAt, expiredAt int64) (cache.ExpireEvent, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &record{op: opPut, createdAt: createdAt, expiredAt: expiredAt, key: key, value: v}
+	var ev cache.ExpireEvent
+	var notify bool
+	old, ok := c.index[key]
+	if ok {
+		reason := cache.ReasonReplaced
+		if expired(old.expiredAt, c.now()) {
+			reason = cache.ReasonExpired // Expired but not yet swept
+		}
+		ev, notify = c.event(k, old, reason)
+	}
+	off, err := c.append(r)
+	if err != nil {
+		return cache.ExpireEvent{}, false, err
+	}
+	if ok {
+		c.dead += old.rec
+	}
+	c.index[key] = entry{off: r.valueOffset(off), size: int64(len(v)), rec: r.size(), createdAt: createdAt, expiredAt: expiredAt}
+	return ev, notify, nil
+}
+
+func (c *DiskCache) Del(ctx context.Context, k interface{}) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	key := keyToString(k)
+	c.mu.Lock()
+	old, ok := c.index[key]
+	if !ok {
+		c.mu.Unlock()
+		return cache.ErrNoKey
+	}
+	reason := cache.ReasonDeleted
+	if expired(old.expiredAt, c.now()) {
+		reason = cache.ReasonExpired // Expired but not yet swept
+	}
+	ev, notify := c.event(k, old, reason)
+	r := &record{op: opDel, key: key}
+	if _, err := c.append(r); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	delete(c.index, key)
+	c.dead += old.rec + r.size()
+	c.mu.Unlock()
+
+	if notify {
+		c.notify(ev)
+	}
+	if reason == cache.ReasonExpired {
+		return cache.ErrNoKey
+	}
+	return nil
+}
+
+func (c *DiskCache) Expire(ctx context.Context, k interface{}, sec int64) error {
+	return c.ExpireIn(ctx, k, cache.SecondsToTTL(sec))
+}
+
+// ExpireIn sets an existing key to expire ttl from now; ttl < 0 makes it non-expiring.
+// Only the new deadline is appended to the log, not the value.
+func (c *DiskCache) ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	key := keyToString(k)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return cache.ErrNoKey
+	}
+	r := &record{op: opExpire, expiredAt: cache.ExpiredAt(c.now(), ttl), key: key}
+	if _, err := c.append(r); err != nil {
+		return err
+	}
+	e.expiredAt = r.expiredAt
+	e.rec += r.size()
+	c.index[key] = e
+	return nil
+}
+
+// Tx runs fn on the entry stored under k while holding the cache write lock, then
+// writes the entry back. Values come back from the log as []byte.
+func (c *DiskCache) Tx(ctx context.Context, k interface{}, fn func(*cache.Entry) error) error {
+	if fn == nil {
+		return errors.New("disk cache: tx fn is nil")
+	}
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	key := keyToString(k)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.lookup(key)
+	if !ok {
+		return cache.ErrNoKey
+	}
+	v, err := c.read(old)
+	if err != nil {
+		return err
+	}
+	e := cache.NewEntry(c.clock, v, old.createdAt, old.expiredAt)
+	if err := fn(e); err != nil {
+		return err
+	}
+	b, err := encodeValue(e.Value)
+	if err != nil {
+		return fmt.Errorf("disk cache: tx resolve value: %w", err)
+	}
+	r := &record{op: opPut, createdAt: e.CreatedAt, expiredAt: e.ExpiredAt, key: key, value: b}
+	off, err := c.append(r)
+	if err != nil {
+		return err
+	}
+	c.dead += old.rec
+	c.index[key] = entry{off: r.valueOffset(off), size: int64(len(b)), rec: r.size(), createdAt: e.CreatedAt, expiredAt: e.ExpiredAt}
+	return nil
+}
+
+func (c *DiskCache) ExpireHandler(h func(k interface{}, v interface{})) {
+	c.expireHandler = h
+}
+
+// ExpireEventHandler sets a callback invoked for every entry leaving the cache, with the reason.
+func (c *DiskCache) ExpireEventHandler(h func(ev cache.ExpireEvent)) {
+	c.eventHandler = h
+}
+
+// Range iterates over all non-expired entries in key order. The keys are
+// collected first; each value is then read without holding the lock, so fn may
+// write to the cache. Entries deleted during the iteration are skipped.
+func (c *DiskCache) Range(ctx context.Context, fn func(k interface{}, v interface{}) error) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.index))
+	for k := range c.index {
+		keys = append(keys, k)
+	}
+	c.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v, _, err := c.getInternal(k)
+		if err == cache.ErrNoKey {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear removes all entries by truncating the log.
+func (c *DiskCache) Clear(ctx context.Context) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	c.mu.Lock()
+	var evs []cache.ExpireEvent
+	if c.eventHandler != nil {
+		for k, e := range c.index {
+			if ev, ok := c.event(k, e, cache.ReasonCleared); ok {
+				evs = append(evs, ev)
+			}
+		}
+	}
+	err := c.f.Truncate(int64(len(logMagic)))
+	if err == nil && c.syncWrites {
+		err = c.f.Sync()
+	}
+	if err == nil {
+		c.size = int64(len(logMagic))
+		c.dead = 0
+		c.index = make(map[string]entry)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("disk cache: clear: %w", err)
+	}
+	for _, ev := range evs {
+		c.notify(ev)
+	}
+	return nil
+}
+
+// Stats is a point-in-time summary of the log.
+type Stats struct {
+	Entries   int   // Number of indexed entries (including expired ones not yet swept)
+	LogBytes  int64 // Size of the log file
+	DeadBytes int64 // Bytes that compaction would reclaim
+}
+
+// Stats returns the current log usage.
+func (c *DiskCache) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Stats{Entries: len(c.index), LogBytes: c.size, DeadBytes: c.dead}
+}
+
+// Compact rewrites the live entries into a new log and atomically replaces the
+// old one, reclaiming the space of overwritten, deleted and expired entries.
+// Writes block while it runs. A crash during compaction leaves the old log in place.
+func (c *DiskCache) Compact(ctx context.Context) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	evs, err := c.compact()
+	for _, ev := range evs {
+		c.notify(ev)
+	}
+	return err
+}
+
+func (c *DiskCache) compact() ([]cache.ExpireEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := filepath.Join(c.dir, logName)
+	tmp, err := os.OpenFile(path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("disk cache: compact: %w", err)
+	}
+	fail := func(err error) ([]cache.ExpireEvent, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("disk cache: compact: %w", err)
+	}
+
+	now := c.now()
+	var evs []cache.ExpireEvent
+	index := make(map[string]entry, len(c.index))
+	buf := []byte(logMagic)
+	var size int64 // Bytes written to tmp so far; buf holds the rest
+	for k, e := range c.index {
+		if expired(e.expiredAt, now) {
+			if ev, ok := c.event(k, e, cache.ReasonExpired); ok {
+				evs = append(evs, ev)
+			}
+			continue
+		}
+		v, err := c.read(e)
+		if err != nil {
+			return fail(err)
+		}
+		r := &record{op: opPut, createdAt: e.createdAt, expiredAt: e.expiredAt, key: k, value: v}
+		index[k] = entry{off: r.valueOffset(size + int64(len(buf))), size: e.size, rec: r.size(), createdAt: e.createdAt, expiredAt: e.expiredAt}
+		buf = r.encode(buf)
+		if len(buf) >= 1<<20 {
+			if _, err := tmp.Write(buf); err != nil {
+				return fail(err)
+			}
+			size += int64(len(buf))
+			buf = buf[:0]
+		}
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		return fail(err)
+	}
+	size += int64(len(buf))
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fail(err)
+	}
+	syncDir(c.dir)
+	c.f.Close()
+	c.f, c.size, c.dead, c.index = tmp, size, 0, index
+	return evs, nil
+}
+
+// syncDir makes a rename in dir durable, where the platform supports it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// loop periodically removes expired entries and compacts the log once enough of it is dead.
+func (c *DiskCache) loop(ctx context.Context, ticker cache.Ticker) {
+	defer ticker.Stop()
+	defer close(c.loopDone)
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+		c.sweep()
+		st := c.Stats()
+		if st.LogBytes >= minCompactSize && float64(st.DeadBytes) >= c.compactRatio*float64(st.LogBytes) {
+			if err := c.Compact(ctx); err != nil {
+				c.logger(err)
+			}
+		}
+	}
+}
+
+// sweep drops expired entries from the index. Their records become dead and are
+// reclaimed by the next compaction; a restart skips them as well.
+func (c *DiskCache) sweep() {
+	c.mu.Lock()
+	now := c.now()
+	var evs []cache.ExpireEvent
+	for k, e := range c.index {
+		if expired(e.expiredAt, now) {
+			if ev, ok := c.event(k, e, cache.ReasonExpired); ok {
+				evs = append(evs, ev)
+			}
+			delete(c.index, k)
+			c.dead += e.rec
+		}
+	}
+	c.mu.Unlock()
+	for _, ev := range evs {
+		c.notify(ev)
+	}
+}
@@ -0,0 +1,357 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-comm/cache"
+)
+
+func newTestCache(t *testing.T, opts ...Option) (*DiskCache, string) {
+	dir, err := ioutil.TempDir("", "cache-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c, err := New(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+func mustGet(t *testing.T, c *DiskCache, k interface{}) string {
+	t.Helper()
+	v, err := c.Get(context.Background(), k)
+	if err != nil {
+		t.Fatalf("get %v: %v", k, err)
+	}
+	return string(v.([]byte))
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	clk := cache.NewFakeClock(time.Unix(1000, 0))
+	c, _ := newTestCache(t, WithClock(clk))
+	defer c.Close(ctx)
+
+	if _, err := c.Get(ctx, "k"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	c.Put(ctx, "k", "v")
+	c.Put(ctx, []byte("b"), []byte{0, 1})
+	c.Put(ctx, 1, map[string]int{"a": 1})
+	if v := mustGet(t, c, "k"); v != "v" {
+		t.Fatalf("expected v, got %s", v)
+	}
+	if v, _ := c.Get(ctx, "b"); !bytes.Equal(v.([]byte), []byte{0, 1}) {
+		t.Fatalf("unexpected bytes value %v", v)
+	}
+	var m map[string]int
+	if err := c.Scan(ctx, 1, cache.DecodeScanner(&m)); err != nil || m["a"] != 1 {
+		t.Fatalf("expected a JSON value, got %v, %v", m, err)
+	}
+
+	c.PutEx(ctx, "ttl", "x", 10)
+	if v, ttl, _ := c.GetAndTTL(ctx, "ttl"); string(v.([]byte)) != "x" || ttl != 10 {
+		t.Fatalf("expected x with ttl 10, got %s, %d", v, ttl)
+	}
+	if ttl, _ := c.TTL(ctx, "k"); ttl != -1 {
+		t.Fatalf("expected -1 for a key without expiration, got %d", ttl)
+	}
+	c.Expire(ctx, "ttl", 1)
+	clk.Advance(2 * time.Second)
+	if _, err := c.Get(ctx, "ttl"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey after expiry, got %v", err)
+	}
+	if err := c.Expire(ctx, "ttl", 10); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey for an expired key, got %v", err)
+	}
+
+	err := c.Tx(ctx, "k", func(e *cache.Entry) error {
+		e.Value = append(e.Value.([]byte), '!')
+		return nil
+	})
+	if v := mustGet(t, c, "k"); err != nil || v != "v!" {
+		t.Fatalf("expected Tx to write back, got %s, %v", v, err)
+	}
+	if err := c.Tx(ctx, "missing", func(*cache.Entry) error { return nil }); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	if err := c.Del(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del(ctx, "b"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	var keys []string
+	c.Range(ctx, func(k, v interface{}) error {
+		keys = append(keys, k.(string))
+		c.Del(ctx, k) // Writing from fn must not deadlock
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "k" {
+		t.Fatalf("expected keys 1 and k in order, got %v", keys)
+	}
+
+	c.Put(ctx, "k", "v")
+	if err := c.Clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Entries != 0 || st.LogBytes != int64(len(logMagic)) {
+		t.Fatalf("unexpected stats after Clear: %+v", st)
+	}
+
+	c.Close(ctx)
+	if _, err := c.Get(ctx, "k"); err != cache.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := c.Put(ctx, "k", "v"); err != cache.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestDiskCacheReopen(t *testing.T) {
+	ctx := context.Background()
+	clk := cache.NewFakeClock(time.Unix(1000, 0))
+	c, dir := newTestCache(t, WithClock(clk))
+	c.Put(ctx, "a", "1")
+	c.Put(ctx, "a", "2")
+	c.Put(ctx, "b", "x")
+	c.Del(ctx, "b")
+	c.PutEx(ctx, "ttl", "x", 60)
+	c.PutEx(ctx, "short", "x", 60)
+	c.Expire(ctx, "short", 1)
+	c.Close(ctx)
+
+	clk.Advance(2 * time.Second)
+	c, err := New(dir, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if v := mustGet(t, c, "a"); v != "2" {
+		t.Fatalf("expected the last write to win, got %s", v)
+	}
+	if _, err := c.Get(ctx, "b"); err != cache.ErrNoKey {
+		t.Fatalf("expected the delete to be replayed, got %v", err)
+	}
+	if ttl, _ := c.TTL(ctx, "ttl"); ttl != 58 {
+		t.Fatalf("expected ttl 58, got %d", ttl)
+	}
+	if _, err := c.Get(ctx, "short"); err != cache.ErrNoKey {
+		t.Fatalf("expected the expire record to be replayed, got %v", err)
+	}
+	if st := c.Stats(); st.Entries != 2 || st.DeadBytes == 0 {
+		t.Fatalf("unexpected stats after reopen: %+v", st)
+	}
+}
+
+func TestDiskCacheRecovery(t *testing.T) {
+	ctx := context.Background()
+	c, dir := newTestCache(t)
+	c.Put(ctx, "a", "1")
+	c.Put(ctx, "b", "2")
+	c.Close(ctx)
+
+	path := filepath.Join(dir, logName)
+	data, _ := ioutil.ReadFile(path)
+	valid := int64(len(data))
+	lastRecord := (&record{op: opPut, key: "b", value: []byte("2")}).size()
+
+	// A write torn in the middle of the last record loses only that record.
+	ioutil.WriteFile(path, data[:len(data)-3], 0644)
+	var logged []interface{}
+	c, err := New(dir, WithLogger(func(v ...interface{}) { logged = v }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "a"); v != "1" {
+		t.Fatalf("expected a to survive, got %s", v)
+	}
+	if _, err := c.Get(ctx, "b"); err != cache.ErrNoKey {
+		t.Fatalf("expected the torn record to be dropped, got %v", err)
+	}
+	if len(logged) == 0 {
+		t.Fatal("expected the truncation to be logged")
+	}
+	if st, _ := os.Stat(path); st.Size() != valid-lastRecord {
+		t.Fatalf("expected the log truncated to %d bytes, got %d", valid-lastRecord, st.Size())
+	}
+	c.Put(ctx, "c", "3") // Appends follow the last valid record
+	c.Close(ctx)
+
+	// Garbage after valid records is dropped.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(bytes.Repeat([]byte{0xff}, 100))
+	f.Close()
+	c, err = New(dir, WithLogger(func(v ...interface{}) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "c"); v != "3" {
+		t.Fatalf("expected c to survive, got %s", v)
+	}
+	c.Close(ctx)
+
+	ioutil.WriteFile(path, []byte("not a log"), 0644)
+	if _, err := New(dir); err == nil {
+		t.Fatal("expected an error for a foreign file")
+	}
+}
+
+func TestDiskCacheLocked(t *testing.T) {
+	ctx := context.Background()
+	c, dir := newTestCache(t)
+	if _, err := New(dir); err != ErrLocked {
+		t.Fatalf("expected ErrLocked while the directory is open, got %v", err)
+	}
+	c.Close(ctx)
+
+	c, err := New(dir)
+	if err != nil {
+		t.Fatalf("expected Close to release the lock, got %v", err)
+	}
+	c.Close(ctx)
+}
+
+func TestDiskCacheTooLarge(t *testing.T) {
+	defer func(n int64) { maxRecordSize = n }(maxRecordSize)
+	maxRecordSize = 64
+
+	ctx := context.Background()
+	c, dir := newTestCache(t)
+	c.Put(ctx, "a", "1")
+	size := c.Stats().LogBytes
+
+	big := string(bytes.Repeat([]byte("v"), 64))
+	if err := c.Put(ctx, "big", big); err != cache.ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if err := c.Put(ctx, big, "1"); err != cache.ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge for a long key, got %v", err)
+	}
+	err := c.Tx(ctx, "a", func(e *cache.Entry) error {
+		e.Value = big
+		return nil
+	})
+	if err != cache.ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge from Tx, got %v", err)
+	}
+	if v := mustGet(t, c, "a"); v != "1" {
+		t.Fatalf("expected a to be unchanged, got %s", v)
+	}
+	if st := c.Stats(); st.LogBytes != size {
+		t.Fatalf("expected nothing appended, log grew from %d to %d bytes", size, st.LogBytes)
+	}
+	c.Close(ctx)
+
+	c, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if v := mustGet(t, c, "a"); v != "1" {
+		t.Fatalf("expected a to survive a reopen, got %s", v)
+	}
+}
+
+func TestDiskCacheCompact(t *testing.T) {
+	ctx := context.Background()
+	clk := cache.NewFakeClock(time.Unix(1000, 0))
+	c, dir := newTestCache(t, WithClock(clk))
+	next := collectEvents(t, c)
+	c.Put(ctx, "k", "0")
+	for i := 1; i < 100; i++ {
+		c.Put(ctx, "k", strconv.Itoa(i))
+		next(1)
+	}
+	c.PutEx(ctx, "ttl", "x", 1)
+	c.Put(ctx, "other", "y")
+	c.ExpireIn(ctx, "other", time.Hour)
+	clk.Advance(2 * time.Second)
+
+	before := c.Stats()
+	if err := c.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(1)["ttl"]; ev.Reason != cache.ReasonExpired || string(ev.Value.([]byte)) != "x" {
+		t.Fatalf("expected an expired event for ttl, got %+v", ev)
+	}
+	st := c.Stats()
+	if st.Entries != 2 || st.DeadBytes != 0 || st.LogBytes >= before.LogBytes {
+		t.Fatalf("expected compaction to shrink the log, got %+v before, %+v after", before, st)
+	}
+	if v := mustGet(t, c, "k"); v != "99" {
+		t.Fatalf("expected 99, got %s", v)
+	}
+	c.Put(ctx, "new", "z")
+	c.Close(ctx)
+
+	c, err := New(dir, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if v := mustGet(t, c, "new"); v != "z" {
+		t.Fatalf("expected writes after compaction to persist, got %s", v)
+	}
+	if ttl, _ := c.TTLDuration(ctx, "other"); ttl != time.Hour-2*time.Second {
+		t.Fatalf("expected the expiration to survive compaction, got %v", ttl)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.compact*")); len(matches) != 0 {
+		t.Fatalf("expected no temporary files left, got %v", matches)
+	}
+}
+
+func TestDiskCacheSweep(t *testing.T) {
+	ctx := context.Background()
+	clk := cache.NewFakeClock(time.Unix(1000, 0))
+	c, _ := newTestCache(t, WithClock(clk), WithCheckInterval(time.Second))
+	defer c.Close(ctx)
+	next := collectEvents(t, c)
+
+	c.PutEx(ctx, "a", "1", 1)
+	c.Put(ctx, "b", "2")
+	clk.Advance(time.Second)
+	if ev := next(1)["a"]; ev.Reason != cache.ReasonExpired {
+		t.Fatalf("expected an expired event for a, got %+v", ev)
+	}
+	if st := c.Stats(); st.Entries != 1 {
+		t.Fatalf("expected the sweep to drop a, got %+v", st)
+	}
+
+	c.Put(ctx, "b", "3")
+	if ev := next(1)["b"]; ev.Reason != cache.ReasonReplaced || string(ev.Value.([]byte)) != "2" {
+		t.Fatalf("expected a replaced event for b, got %+v", ev)
+	}
+	c.Del(ctx, "b")
+	if ev := next(1)["b"]; ev.Reason != cache.ReasonDeleted {
+		t.Fatalf("expected a deleted event for b, got %+v", ev)
+	}
+}
+
+func collectEvents(t *testing.T, c cache.Cache) func(n int) map[interface{}]cache.ExpireEvent {
+	ch := make(chan cache.ExpireEvent, 100)
+	c.ExpireEventHandler(func(ev cache.ExpireEvent) { ch <- ev })
+	return func(n int) map[interface{}]cache.ExpireEvent {
+		got := make(map[interface{}]cache.ExpireEvent, n)
+		for i := 0; i < n; i++ {
+			select {
+			case ev := <-ch:
+				got[ev.Key] = ev
+			case <-time.After(time.Second):
+				t.Fatalf("expected %d events, got %d", n, len(got))
+			}
+		}
+		return got
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockDir creates the lock file of dir. Platforms without flock get no
+// locking: keeping to one process per directory is up to the caller.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,130 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Log file layout: logMagic, then records.
+//
+//	[0:4]   CRC-32 (Castagnoli) of bytes [4:] of the record
+//	[4:8]   payload length
+//	payload:
+//	[8]     op
+//	[9:17]  CreatedAt (Unix ns)
+//	[17:25] ExpiredAt (Unix ns, -1 = never)
+//	[25:29] key length
+//	key, then the value (rest of the payload)
+//
+// A record is only valid if it is complete and its checksum matches; recovery
+// truncates the log at the first invalid record, which drops torn writes.
+const (
+	logMagic      = "GCAL\x00\x01"
+	recHeaderSize = 29
+)
+
+// maxRecordSize caps the payload length of a record: writes above it fail with
+// cache.ErrTooLarge, and replay treats longer lengths as corrupt.
+var maxRecordSize int64 = 1 << 30
+
+const (
+	opPut    byte = 1 // Stores key with value and timestamps
+	opDel    byte = 2 // Deletes key
+	opExpire byte = 3 // Sets ExpiredAt of key, keeping its value
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errBadMagic = errors.New("disk cache: not a cache log file")
+
+// record is a decoded log record.
+type record struct {
+	op                   byte
+	createdAt, expiredAt int64
+	key                  string
+	value                []byte
+}
+
+// encode appends the record to buf.
+func (r *record) encode(buf []byte) []byte {
+	start := len(buf)
+	n := recHeaderSize - 8 + len(r.key) + len(r.value)
+	buf = append(buf, make([]byte, recHeaderSize)...)
+	h := buf[start:]
+	binary.LittleEndian.PutUint32(h[4:8], uint32(n))
+	h[8] = r.op
+	binary.LittleEndian.PutUint64(h[9:17], uint64(r.createdAt))
+	binary.LittleEndian.PutUint64(h[17:25], uint64(r.expiredAt))
+	binary.LittleEndian.PutUint32(h[25:29], uint32(len(r.key)))
+	buf = append(buf, r.key...)
+	buf = append(buf, r.value...)
+	binary.LittleEndian.PutUint32(buf[start:start+4], crc32.Checksum(buf[start+4:], crcTable))
+	return buf
+}
+
+// fits reports whether the record stays within maxRecordSize once encoded,
+// which also keeps its lengths within uint32.
+func (r *record) fits() bool {
+	return r.size()-8 <= maxRecordSize
+}
+
+// size returns the encoded size of the record.
+func (r *record) size() int64 {
+	return int64(recHeaderSize + len(r.key) + len(r.value))
+}
+
+// valueOffset returns the offset of the value of a record written at off.
+func (r *record) valueOffset(off int64) int64 {
+	return off + recHeaderSize + int64(len(r.key))
+}
+
+// replay reads the records of the log file f in order and calls apply for each,
+// with its offset. It returns the offset just past the last valid record; the
+// caller truncates the file there if it is shorter than the file.
+func replay(f *os.File, apply func(off int64, r *record)) (int64, error) {
+	br := bufio.NewReaderSize(f, 1<<16)
+	magic := make([]byte, len(logMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return 0, nil // Empty file or torn header: start over
+	}
+	if string(magic) != logMagic {
+		return 0, errBadMagic
+	}
+
+	off := int64(len(logMagic))
+	var h [recHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(br, h[:8]); err != nil {
+			return off, nil // Clean end or torn length
+		}
+		n := binary.LittleEndian.Uint32(h[4:8])
+		if n < recHeaderSize-8 || int64(n) > maxRecordSize {
+			return off, nil
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return off, nil
+		}
+		crc := crc32.Update(crc32.Checksum(h[4:8], crcTable), crcTable, payload)
+		if crc != binary.LittleEndian.Uint32(h[0:4]) {
+			return off, nil
+		}
+		klen := binary.LittleEndian.Uint32(payload[17:21])
+		if uint64(klen) > uint64(n)-(recHeaderSize-8) {
+			return off, nil
+		}
+		r := &record{
+			op:        payload[0],
+			createdAt: int64(binary.LittleEndian.Uint64(payload[1:9])),
+			expiredAt: int64(binary.LittleEndian.Uint64(payload[9:17])),
+			key:       string(payload[21 : 21+klen]),
+			value:     payload[21+klen:],
+		}
+		apply(off, r)
+		off += int64(8 + n)
+	}
+}
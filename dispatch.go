@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow policies for a Dispatcher, applied when a worker queue is full.
+//
+// A callback that writes back into the cache dispatches new callbacks from a
+// worker. If one lands on the full queue of that same worker, it cannot wait for
+// room, since the worker would wait for itself: it is dropped and logged even
+// with OverflowBlock.
+const (
+	OverflowBlock = "block" // Wait for room in the queue (backpressure, default)
+	OverflowDrop  = "drop"  // Drop the event and count it, see Dispatcher.Dropped
+)
+
+const defaultDispatchQueue = 1024 // Default buffered events per worker
+
+// DispatchConfig configures how expire callbacks are delivered.
+type DispatchConfig struct {
+	Workers   int                    // Worker goroutines, <= 0 uses runtime.NumCPU()
+	QueueSize int                    // Buffered events per worker, <= 0 uses 1024
+	Overflow  string                 // OverflowBlock (default) or OverflowDrop
+	Logger    func(v ...interface{}) // Reports recovered handler panics, nil uses log.Println
+}
+
+// Dispatcher delivers expire callbacks on a fixed pool of workers.
+// Callbacks for the same key always run on the same worker, in the order they
+// were dispatched; a panicking callback is recovered and logged.
+type Dispatcher struct {
+	pending int64  // Accepted callbacks not yet run (atomic, first for 64-bit alignment)
+	dropped uint64 // Callbacks dropped on overflow or after Close (atomic)
+	closed  int32  // Set by Close (atomic)
+	queues  []chan func()
+	workers []int64 // Goroutine ID of the worker of each queue (atomic), 0 until it starts
+	drop    bool
+	logger  func(v ...interface{})
+	done    chan struct{} // Closed by Close to stop the workers
+}
+
+// NewDispatcher starts the workers described by cfg.
+func NewDispatcher(cfg DispatchConfig) *Dispatcher {
+	n := cfg.Workers
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultDispatchQueue
+	}
+	d := &Dispatcher{
+		queues:  make([]chan func(), n),
+		workers: make([]int64, n),
+		drop:    cfg.Overflow == OverflowDrop,
+		logger:  cfg.Logger,
+		done:    make(chan struct{}),
+	}
+	if d.logger == nil {
+		d.logger = log.Println
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan func(), size)
+		go d.work(i)
+	}
+	return d
+}
+
+// Dispatch queues fn on the worker owning key. With OverflowBlock it waits while
+// that worker's queue is full, unless it is called from that worker; with
+// OverflowDrop it drops fn instead.
+// Returns false if fn was dropped, which also happens once the Dispatcher is closed.
+func (d *Dispatcher) Dispatch(key string, fn func()) bool {
+	atomic.AddInt64(&d.pending, 1) // Before the closed check, so Close waits for it
+	if atomic.LoadInt32(&d.closed) != 0 {
+		d.reject()
+		return false
+	}
+	i := hashFNV(key) % uint64(len(d.queues))
+	q := d.queues[i]
+	select {
+	case q <- fn:
+		return true
+	default:
+	}
+	if d.drop {
+		d.reject()
+		return false
+	}
+	if atomic.LoadInt64(&d.workers[i]) == goroutineID() {
+		d.reject()
+		d.logger("cache: expire handler dispatched to its own full queue, callback for", key, "dropped")
+		return false
+	}
+	select {
+	case q <- fn:
+		return true
+	case <-d.done:
+		d.reject()
+		return false
+	}
+}
+
+// reject undoes the pending count of a callback that was not queued.
+func (d *Dispatcher) reject() {
+	atomic.AddInt64(&d.pending, -1)
+	atomic.AddUint64(&d.dropped, 1)
+}
+
+// Dropped returns the number of callbacks dropped so far.
+func (d *Dispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *Dispatcher) work(i int) {
+	atomic.StoreInt64(&d.workers[i], goroutineID())
+	q := d.queues[i]
+	for {
+		select {
+		case fn := <-q:
+			d.run(fn)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) run(fn func()) {
+	defer atomic.AddInt64(&d.pending, -1)
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger("cache: expire handler panic:", r)
+		}
+	}()
+	fn()
+}
+
+// goroutineID returns the ID of the calling goroutine, parsed from the header of
+// its stack trace. It is only used on the slow path of a full queue.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// Close stops accepting callbacks and waits until the queued ones have run or ctx
+// is done, returning ctx.Err() in the latter case. The workers exit either way;
+// callbacks still queued at that point are discarded.
+// Closing an already closed Dispatcher is a no-op.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&d.closed, 0, 1) {
+		return nil
+	}
+	defer close(d.done)
+	return waitPending(ctx, &d.pending)
+}
+
+// waitPending polls until the counter of in-flight callbacks drops to zero or ctx is done.
+func waitPending(ctx context.Context, pending *int64) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(pending) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
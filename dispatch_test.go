@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOrderPerKey(t *testing.T) {
+	d := NewDispatcher(DispatchConfig{Workers: 4, QueueSize: 8})
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	for i := 0; i < 1000; i++ {
+		k, n := strconv.Itoa(i%10), i
+		d.Dispatch(k, func() {
+			mu.Lock()
+			got[k] = append(got[k], n)
+			mu.Unlock()
+		})
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for k, seq := range got {
+		if len(seq) != 100 {
+			t.Fatalf("key %s: expected 100 callbacks, got %d", k, len(seq))
+		}
+		for i := 1; i < len(seq); i++ {
+			if seq[i] < seq[i-1] {
+				t.Fatalf("key %s: callbacks out of order: %v", k, seq)
+			}
+		}
+	}
+}
+
+func TestDispatcherBoundedConcurrency(t *testing.T) {
+	d := NewDispatcher(DispatchConfig{Workers: 3})
+	var running, peak int32
+	for i := 0; i < 100; i++ {
+		d.Dispatch(strconv.Itoa(i), func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	d.Close(context.Background())
+	if p := atomic.LoadInt32(&peak); p > 3 {
+		t.Fatalf("expected at most 3 concurrent callbacks, got %d", p)
+	}
+}
+
+func TestDispatcherDropOnOverflow(t *testing.T) {
+	d := NewDispatcher(DispatchConfig{Workers: 1, QueueSize: 1, Overflow: OverflowDrop})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Dispatch("k", func() { close(started); <-release })
+	<-started
+
+	if !d.Dispatch("k", func() {}) {
+		t.Fatal("expected the queue to accept one callback")
+	}
+	if d.Dispatch("k", func() {}) {
+		t.Fatal("expected a full queue to drop the callback")
+	}
+	if d.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped callback, got %d", d.Dropped())
+	}
+	close(release)
+	d.Close(context.Background())
+
+	if d.Dispatch("k", func() {}) {
+		t.Fatal("expected Dispatch after Close to drop")
+	}
+}
+
+func TestDispatcherRecoversPanic(t *testing.T) {
+	logged := make(chan struct{}, 1)
+	d := NewDispatcher(DispatchConfig{Workers: 1, Logger: func(v ...interface{}) { logged <- struct{}{} }})
+	ran := make(chan struct{})
+	d.Dispatch("k", func() { panic("boom") })
+	d.Dispatch("k", func() { close(ran) })
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not survive a panicking callback")
+	}
+	select {
+	case <-logged:
+	default:
+		t.Fatal("expected the panic to be logged")
+	}
+	d.Close(context.Background())
+}
+
+func TestDispatcherCloseDeadline(t *testing.T) {
+	d := NewDispatcher(DispatchConfig{Workers: 1})
+	release := make(chan struct{})
+	defer close(release)
+	d.Dispatch("k", func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestDispatcherReentrantFullQueue(t *testing.T) {
+	var logged int32
+	d := NewDispatcher(DispatchConfig{Workers: 1, QueueSize: 1, Logger: func(v ...interface{}) {
+		atomic.AddInt32(&logged, 1)
+	}})
+	var ran int32
+	done := make(chan struct{})
+	d.Dispatch("k", func() {
+		defer close(done)
+		for i := 0; i < 3; i++ { // The first fills the queue, the others would wait for this worker
+			d.Dispatch("k", func() { atomic.AddInt32(&ran, 1) })
+		}
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a callback dispatching to its own full queue deadlocked")
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&ran) != 1 || d.Dropped() != 2 || atomic.LoadInt32(&logged) != 2 {
+		t.Fatalf("expected 1 callback run and 2 dropped and logged, got %d, %d, %d", ran, d.Dropped(), logged)
+	}
+}
+
+func TestMemoryHandlerWritesBackWithFullQueue(t *testing.T) {
+	c := NewMemory(WithDispatch(DispatchConfig{Workers: 1, QueueSize: 1, Logger: func(v ...interface{}) {}}))
+	ctx := context.Background()
+
+	var calls int32
+	c.ExpireEventHandler(func(ev ExpireEvent) {
+		// Each write replaces the entry and emits an event for the same
+		// worker: past the first, the queue is full.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			for i := 0; i < 10; i++ {
+				c.Put(ctx, ev.Key, i)
+			}
+		}
+	})
+	c.Put(ctx, "k", "a")
+	c.Put(ctx, "k", "b")
+
+	closed := make(chan error)
+	go func() { closed <- c.Close(ctx) }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a handler writing back deadlocked on its own full queue")
+	}
+}
+
+func TestMemoryHandlerLocksBucketWithFullQueue(t *testing.T) {
+	c := NewMemory(WithDispatch(DispatchConfig{Workers: 1, QueueSize: 1}))
+	ctx := context.Background()
+
+	c.ExpireEventHandler(func(ev ExpireEvent) {
+		// Taking the bucket write lock from the handler must not deadlock
+		// with producers blocked on the full queue.
+		c.ExpireIn(ctx, ev.Key, time.Hour)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.Put(ctx, "k", i)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock between producers and a handler writing back")
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
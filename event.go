@@ -0,0 +1,44 @@
+package cache
+
+// ExpireReason tells why an entry left the cache.
+type ExpireReason uint8
+
+const (
+	ReasonExpired  ExpireReason = iota + 1 // TTL elapsed
+	ReasonDeleted                          // Removed by Del
+	ReasonEvicted                          // Dropped to honour a size limit
+	ReasonReplaced                         // Overwritten by Put / PutEx / PutTTL
+	ReasonCleared                          // Removed by Clear
+)
+
+func (r ExpireReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonReplaced:
+		return "replaced"
+	case ReasonCleared:
+		return "cleared"
+	default:
+		return "unknown"
+	}
+}
+
+// ExpireEvent describes an entry that left the cache, see Cache.ExpireEventHandler.
+type ExpireEvent struct {
+	Key       interface{}
+	Value     interface{} // Value of the removed entry; may be nil if the backend did not load it
+	Reason    ExpireReason
+	CreatedAt int64 // Creation timestamp of the removed entry (Unix nanoseconds)
+	ExpiredAt int64 // Expiration timestamp of the removed entry (Unix nanoseconds), -1 = never expire
+}
+
+// Legacy reports whether events of reason r are also delivered to the plain
+// ExpireHandler callback, which predates Replaced and Cleared notifications.
+func (r ExpireReason) Legacy() bool {
+	return r == ReasonExpired || r == ReasonDeleted || r == ReasonEvicted
+}
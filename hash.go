@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math/bits"
+	"sync"
+)
+
+// Hasher maps a store key to the 64-bit hash that selects its shard.
+// It must be deterministic for the lifetime of the cache and safe for concurrent use.
+type Hasher func(key string) uint64
+
+const (
+	xxPrime1 uint64 = 0x9E3779B185EBCA87
+	xxPrime2 uint64 = 0xC2B2AE3D27D4EB4F
+	xxPrime3 uint64 = 0x165667B19E3779F9
+	xxPrime4 uint64 = 0x85EBCA77C2B2AE63
+	xxPrime5 uint64 = 0x27D4EB2F165667C5
+)
+
+// XXHash is the default Hasher: 64-bit xxHash (XXH64) of the key with seed 0.
+// It mixes every input bit, so keys sharing long prefixes or suffixes still spread evenly.
+func XXHash(key string) uint64 {
+	b := StrToBytes(key)
+	n := len(b)
+	var h uint64
+
+	if n >= 32 {
+		p1 := xxPrime1 // Variable, so that the seed arithmetic wraps around
+		v1 := p1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -p1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+// MapHasher returns a Hasher built on hash/maphash with a random seed, so the
+// shard of a key cannot be predicted (or targeted) from outside the process.
+func MapHasher() Hasher {
+	seed := maphash.MakeSeed()
+	pool := sync.Pool{New: func() interface{} {
+		h := new(maphash.Hash)
+		h.SetSeed(seed)
+		return h
+	}}
+	return func(key string) uint64 {
+		h := pool.Get().(*maphash.Hash)
+		h.WriteString(key)
+		sum := h.Sum64()
+		h.Reset()
+		pool.Put(h)
+		return sum
+	}
+}
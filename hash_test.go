@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestXXHashVectors(t *testing.T) {
+	vectors := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for s, want := range vectors {
+		if got := XXHash(s); got != want {
+			t.Errorf("XXHash(%q) = %x, want %x", s, got, want)
+		}
+	}
+}
+
+func TestMapHasher(t *testing.T) {
+	h := MapHasher()
+	want := h("key")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if h("key") != want {
+					t.Error("MapHasher is not deterministic")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestShardsRoundedToPowerOfTwo(t *testing.T) {
+	ctx := context.Background()
+	for cfg, want := range map[string]int{`{}`: 256, `{"shards": 1}`: 1, `{"shards": 1000}`: 1024, `{"shards": 4096}`: 4096} {
+		c := NewMemory(cfg).(*Memory)
+		c.Put(ctx, "k", "v")
+		if st := c.Stats(); st.Shards != want {
+			t.Errorf("%s: expected %d shards, got %d", cfg, want, st.Shards)
+		}
+		if v, err := c.Get(ctx, "k"); err != nil || v != "v" {
+			t.Errorf("%s: expected v, got %v, %v", cfg, v, err)
+		}
+	}
+	c := NewMemory(WithShards(3)).(*Memory)
+	c.Put(ctx, "k", "v")
+	if n := len(c.ShardOccupancy()); n != 4 {
+		t.Errorf("expected 4 shards, got %d", n)
+	}
+}
+
+func TestShardSkew(t *testing.T) {
+	ctx := context.Background()
+
+	// Sequential keys with a shared prefix must spread evenly with the default hasher.
+	c := NewMemory(WithShards(64)).(*Memory)
+	for i := 0; i < 64000; i++ {
+		c.Put(ctx, "user:session:"+strconv.Itoa(i), i)
+	}
+	st := c.Stats()
+	if st.Entries != 64000 || st.ShardSkew < 1 || st.ShardSkew > 1.2 {
+		t.Fatalf("expected an even distribution, got %+v", st)
+	}
+	sum := 0
+	for _, n := range c.ShardOccupancy() {
+		sum += n
+	}
+	if sum != st.Entries {
+		t.Fatalf("occupancy sums to %d, want %d", sum, st.Entries)
+	}
+
+	// A constant hasher puts everything into one bucket.
+	c = NewMemory(WithShards(16), WithHasher(func(string) uint64 { return 7 })).(*Memory)
+	for i := 0; i < 100; i++ {
+		c.Put(ctx, i, i)
+	}
+	st = c.Stats()
+	if st.MaxShardEntries != 100 || st.MinShardEntries != 0 || st.ShardSkew != 16 {
+		t.Fatalf("expected all entries in one bucket, got %+v", st)
+	}
+	if occ := c.ShardOccupancy(); occ[7] != 100 {
+		t.Fatalf("expected bucket 7 to hold all entries, got %v", occ)
+	}
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// ErrWrongType is returned by hash operations on a key holding another kind of value.
+var ErrWrongType = errors.New("cache: key holds the wrong kind of value")
+
+// HashCache is implemented by backends storing a map of fields under a key,
+// like Redis hashes: Memory stores a Hash value, mysql.MysqlCache one row per
+// field in a child table. A single field is updated without rewriting the others.
+//
+// Hashes live in the key space of the cache: TTL, Expire and Del apply to the
+// whole hash. A hash created by HSet or HIncrBy never expires; set a TTL with
+// Expire. Deleting the last field deletes the key.
+type HashCache interface {
+	// HGet returns the value of field. It returns ErrNoKey if the key or the field does not exist.
+	HGet(ctx context.Context, k interface{}, field string) (interface{}, error)
+
+	// HSet sets fields, creating the hash if needed, and returns how many fields were added.
+	HSet(ctx context.Context, k interface{}, fields map[string]interface{}) (int, error)
+
+	// HDel removes fields and returns how many existed.
+	HDel(ctx context.Context, k interface{}, fields ...string) (int, error)
+
+	// HGetAll returns all fields. It returns ErrNoKey if the key does not exist.
+	HGetAll(ctx context.Context, k interface{}) (map[string]interface{}, error)
+
+	// HIncrBy adds delta to the integer in field, see CounterCache, and returns the result.
+	HIncrBy(ctx context.Context, k interface{}, field string, delta int64) (int64, error)
+
+	// HLen returns the number of fields, 0 if the key does not exist.
+	HLen(ctx context.Context, k interface{}) (int, error)
+}
+
+// Hash is the value of a hash key in Memory, as returned by Get. Like ZSet, a
+// Hash is updated in place, one field at a time; its methods are safe to call
+// concurrently with updates.
+//
+// A Hash is saved in snapshots as JSON, so field values are restored as the
+// types encoding/json decodes into interface{}.
+type Hash struct {
+	mu     sync.RWMutex
+	fields map[string]interface{}
+	size   int64 // Field names plus value sizes, see Memory.valueSize; -1 if unknown
+}
+
+func newHash() *Hash {
+	return &Hash{fields: make(map[string]interface{})}
+}
+
+func init() {
+	RegisterSnapshotCodec((*Hash)(nil), SnapshotCodec{
+		Name: "cache.Hash",
+		Encode: func(v interface{}) ([]byte, error) {
+			return json.Marshal(v.(*Hash).Fields())
+		},
+		Decode: func(data []byte) (interface{}, error) {
+			h := &Hash{size: -1} // Measured by the cache it is loaded into
+			if err := json.Unmarshal(data, &h.fields); err != nil {
+				return nil, err
+			}
+			return h, nil
+		},
+	})
+}
+
+// Get returns the value of field.
+func (h *Hash) Get(field string) (interface{}, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	v, ok := h.fields[field]
+	return v, ok
+}
+
+// Len returns the number of fields.
+func (h *Hash) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.fields)
+}
+
+// Fields returns a copy of the fields.
+func (h *Hash) Fields() map[string]interface{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	fields := make(map[string]interface{}, len(h.fields))
+	for f, v := range h.fields {
+		fields[f] = v
+	}
+	return fields
+}
+
+// clone returns a copy of h. Callers hold mu.
+func (h *Hash) clone() *Hash {
+	c := &Hash{fields: make(map[string]interface{}, len(h.fields)), size: h.size}
+	for f, v := range h.fields {
+		c.fields[f] = v
+	}
+	return c
+}
+
+// set sets field to v, adjusting the size with sizeOf, and reports whether the
+// field was added. Callers hold mu, or own h alone.
+func (h *Hash) set(field string, v interface{}, sizeOf func(interface{}) int64) bool {
+	old, ok := h.fields[field]
+	if ok {
+		h.size -= sizeOf(old)
+	} else {
+		h.size += int64(len(field))
+	}
+	h.fields[field] = v
+	h.size += sizeOf(v)
+	return !ok
+}
+
+// del deletes field and reports whether it existed. Callers hold mu.
+func (h *Hash) del(field string, sizeOf func(interface{}) int64) bool {
+	v, ok := h.fields[field]
+	if !ok {
+		return false
+	}
+	delete(h.fields, field)
+	h.size -= int64(len(field)) + sizeOf(v)
+	return true
+}
+
+// measure returns the size of h, computing it with sizeOf if it is unknown.
+// Called under the bucket lock, which updates hold too.
+func (h *Hash) measure(sizeOf func(interface{}) int64) int64 {
+	if h.size < 0 {
+		h.size = 0
+		for f, v := range h.fields {
+			h.size += int64(len(f)) + sizeOf(v)
+		}
+	}
+	return h.size
+}
+
+var _ HashCache = (*Memory)(nil)
+
+func (m *Memory) HGet(ctx context.Context, k interface{}, field string) (interface{}, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := h.Get(field)
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return v, nil
+}
+
+// HSet sets fields. Valuers are resolved first.
+func (m *Memory) HSet(ctx context.Context, k interface{}, fields map[string]interface{}) (int, error) {
+	vals := make(map[string]interface{}, len(fields))
+	for f, v := range fields {
+		if vv, ok := v.(Valuer); ok {
+			var err error
+			if v, err = vv.Value(); err != nil {
+				return 0, err
+			}
+		}
+		vals[f] = v
+	}
+	added := 0
+	err := m.updateHash(k, len(vals) > 0, 0, func(h *Hash) error {
+		for f, v := range vals {
+			if h.set(f, v, m.valueSize) {
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (m *Memory) HDel(ctx context.Context, k interface{}, fields ...string) (int, error) {
+	deleted := 0
+	err := m.updateHash(k, false, len(fields), func(h *Hash) error {
+		for _, f := range fields {
+			if h.del(f, m.valueSize) {
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// HGetAll returns a copy of the fields.
+func (m *Memory) HGetAll(ctx context.Context, k interface{}) (map[string]interface{}, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		return nil, err
+	}
+	return h.Fields(), nil
+}
+
+// HIncrBy adds delta to field, stored as an int64.
+func (m *Memory) HIncrBy(ctx context.Context, k interface{}, field string, delta int64) (int64, error) {
+	n := delta
+	err := m.updateHash(k, true, 0, func(h *Hash) error {
+		if v, ok := h.fields[field]; ok {
+			var err error
+			if n, err = IncrValue(v, delta); err != nil {
+				return err
+			}
+		}
+		h.set(field, n, m.valueSize)
+		return nil
+	})
+	return n, err
+}
+
+func (m *Memory) HLen(ctx context.Context, k interface{}) (int, error) {
+	h, err := m.hash(k)
+	if err == ErrNoKey {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return h.Len(), nil
+}
+
+// hash returns the live Hash stored under k.
+func (m *Memory) hash(k interface{}) (*Hash, error) {
+	if !m.initialized() {
+		return nil, ErrNoKey
+	}
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.load(keyStr)
+	if !ok || e == nil || e.Expired() {
+		return nil, ErrNoKey
+	}
+	h, ok := e.Value.(*Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return h, nil
+}
+
+// updateHash runs fn on the Hash stored under k, holding the bucket lock and the
+// Hash lock, and updates the size and version of its entry in place. A missing
+// or expired key is created if create is set, with no expiration; otherwise fn
+// is not called. fn must leave h unchanged if it fails. A hash left empty is
+// deleted; if fn may remove up to removing fields and that could empty h, h is
+// copied first so that the deletion is reported with the fields it had.
+// Changing fields is not reported to the expire handlers, like Tx.
+func (m *Memory) updateHash(k interface{}, create bool, removing int, fn func(h *Hash) error) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+	if m.storage == StorageArena {
+		return ErrArenaValue
+	}
+
+	keyStr, b := m.bucketFor(k)
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	e, ok := b.get(keyStr)
+	if !ok || e == nil || e.Expired() {
+		if !create {
+			return nil
+		}
+		h := newHash()
+		if err := fn(h); err != nil {
+			return err
+		}
+		nowTime := m.now()
+		b.set(keyStr, &Entry{CreatedAt: nowTime, ExpiredAt: ExpiredAt(nowTime, NoExpiration), Value: h, clock: m.clock})
+		return nil
+	}
+	h, ok := e.Value.(*Hash)
+	if !ok {
+		return ErrWrongType
+	}
+
+	h.mu.Lock()
+	var prev *Hash
+	if removing >= len(h.fields) && m.hasHandler(ReasonDeleted) {
+		prev = h.clone()
+	}
+	err := fn(h)
+	empty := len(h.fields) == 0
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if empty {
+		b.remove(e)
+		gone := *e
+		gone.Value = prev
+		b.emit(k, &gone, ReasonDeleted)
+		return nil
+	}
+	if b.bounded() {
+		b.policy.access(e)
+	}
+	e = b.writable(e)
+	e.Version = m.nextVersion()
+	b.resize(e)
+	return nil
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryHash(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 1000}`},
+		"lockfree": {WithLockFreeReads()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := NewFakeClock(time.Unix(1000, 0))
+			c := NewMemory(append(opts, WithClock(clk))...).(*Memory)
+			defer c.Close(ctx)
+
+			if n, err := c.HSet(ctx, "u", map[string]interface{}{"name": "ann", "age": 30}); err != nil || n != 2 {
+				t.Fatalf("expected 2 fields added, got %d, %v", n, err)
+			}
+			if ttl, _ := c.TTL(ctx, "u"); ttl != -1 {
+				t.Fatalf("expected a new hash to never expire, got %d", ttl)
+			}
+			c.Expire(ctx, "u", 60)
+			h, _ := c.Get(ctx, "u")
+			if n, _ := c.HSet(ctx, "u", map[string]interface{}{"name": "bob", "city": "x"}); n != 1 {
+				t.Fatalf("expected 1 field added, got %d", n)
+			}
+			if v, _ := h.(*Hash).Get("name"); v != "bob" {
+				t.Fatalf("expected a Hash read before HSet to be updated in place, got %v", v)
+			}
+			if v, _ := c.HGet(ctx, "u", "name"); v != "bob" {
+				t.Fatalf("expected bob, got %v", v)
+			}
+			if _, err := c.HGet(ctx, "u", "missing"); err != ErrNoKey {
+				t.Fatalf("expected ErrNoKey for a missing field, got %v", err)
+			}
+			if n, err := c.HIncrBy(ctx, "u", "age", 2); err != nil || n != 32 {
+				t.Fatalf("expected 32, got %d, %v", n, err)
+			}
+			if n, _ := c.HLen(ctx, "u"); n != 3 {
+				t.Fatalf("expected 3 fields, got %d", n)
+			}
+			if ttl, _ := c.TTL(ctx, "u"); ttl != 60 {
+				t.Fatalf("expected field writes to keep the TTL, got %d", ttl)
+			}
+			all, _ := c.HGetAll(ctx, "u")
+			if len(all) != 3 || all["city"] != "x" || all["age"] != int64(32) {
+				t.Fatalf("unexpected fields %v", all)
+			}
+
+			if n, _ := c.HDel(ctx, "u", "name", "missing"); n != 1 {
+				t.Fatalf("expected 1 field deleted, got %d", n)
+			}
+			c.HDel(ctx, "u", "age", "city")
+			if _, err := c.Get(ctx, "u"); err != ErrNoKey {
+				t.Fatalf("expected deleting the last field to delete the key, got %v", err)
+			}
+			if _, err := c.HGetAll(ctx, "u"); err != ErrNoKey {
+				t.Fatalf("expected ErrNoKey, got %v", err)
+			}
+			if n, err := c.HLen(ctx, "u"); n != 0 || err != nil {
+				t.Fatalf("expected 0, got %d, %v", n, err)
+			}
+
+			c.HIncrBy(ctx, "e", "n", 1)
+			c.Expire(ctx, "e", 1)
+			clk.Advance(2 * time.Second)
+			if n, _ := c.HIncrBy(ctx, "e", "n", 1); n != 1 {
+				t.Fatalf("expected an expired hash to be recreated, got %d", n)
+			}
+
+			c.Put(ctx, "s", "value")
+			if _, err := c.HSet(ctx, "s", map[string]interface{}{"f": 1}); err != ErrWrongType {
+				t.Fatalf("expected ErrWrongType, got %v", err)
+			}
+			if _, err := c.HGet(ctx, "s", "f"); err != ErrWrongType {
+				t.Fatalf("expected ErrWrongType, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryHashInPlace(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(`{"maxBytes": 1000000}`).(*Memory)
+	defer c.Close(ctx)
+	events := make(chan ExpireEvent, 1)
+	c.ExpireEventHandler(func(ev ExpireEvent) { events <- ev })
+
+	c.HSet(ctx, "u", map[string]interface{}{"name": "ann", "city": "paris"})
+	_, v0, _ := c.GetVersion(ctx, "u")
+	c.HSet(ctx, "u", map[string]interface{}{"name": "barbara"})
+	if _, v1, _ := c.GetVersion(ctx, "u"); v1 == v0 {
+		t.Fatal("expected a field write to change the version")
+	}
+	if n, want := c.Stats().Bytes, int64(len("u")+len("name")+len("barbara")+len("city")+len("paris")); n != want {
+		t.Fatalf("expected %d bytes, got %d", want, n)
+	}
+	c.HDel(ctx, "u", "city")
+	if n, want := c.Stats().Bytes, int64(len("u")+len("name")+len("barbara")); n != want {
+		t.Fatalf("expected %d bytes, got %d", want, n)
+	}
+
+	// Deleting the last field reports the hash as it was.
+	c.HDel(ctx, "u", "name")
+	ev := <-events
+	if h, ok := ev.Value.(*Hash); ev.Reason != ReasonDeleted || !ok || h.Len() != 1 {
+		t.Fatalf("expected the deleted hash with its last field, got %+v", ev)
+	}
+	if n := c.Stats().Bytes; n != 0 {
+		t.Fatalf("expected the deleted hash to free its bytes, got %d", n)
+	}
+}
+
+func TestMemoryHashConcurrent(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(WithLockFreeReads()).(*Memory)
+	defer c.Close(ctx)
+	c.HSet(ctx, "u", map[string]interface{}{"n": 0})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			c.HIncrBy(ctx, "u", "n", 1)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if h, err := c.Get(ctx, "u"); err == nil {
+			h.(*Hash).Fields()
+		}
+		c.HGet(ctx, "u", "n")
+	}
+	<-done
+	if v, _ := c.HGet(ctx, "u", "n"); v != int64(1000) {
+		t.Fatalf("expected 1000, got %v", v)
+	}
+}
+
+func TestMemoryHashArena(t *testing.T) {
+	c := NewMemory(WithArena(1 << 20)).(*Memory)
+	defer c.Close(context.Background())
+	if _, err := c.HSet(context.Background(), "u", map[string]interface{}{"f": "v"}); err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+}
+
+func TestMemoryHashSnapshot(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+	c.HSet(ctx, "u", map[string]interface{}{"name": "ann"})
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := NewMemory().(*Memory)
+	defer c2.Close(ctx)
+	if err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c2.HGet(ctx, "u", "name"); err != nil || v != "ann" {
+		t.Fatalf("expected the hash to be restored, got %v, %v", v, err)
+	}
+}
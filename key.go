@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Keyer is implemented by key types that provide their own binary encoding.
+// Keys with equal CacheKey bytes address the same entry.
+type Keyer interface {
+	CacheKey() []byte
+}
+
+// RawKey is a key in Memory's internal encoding. Range and expire events report
+// keys that cannot be turned back into their original type (Keyer, arrays and
+// other non-string, non-integer keys) as RawKey; passing it back to the cache
+// addresses the same entry.
+type RawKey string
+
+// Memory keeps key types in distinct keyspaces so that keys of different types
+// never alias by accident:
+//
+//   - string, []byte, named string types and other fmt.Stringer types share
+//     the byte-string keyspace, stored as-is;
+//   - all integer types (including named ones, even with a String method, such
+//     as enums) share the integer keyspace by value, so int(1), int64(1) and
+//     uint8(1) are the same key, distinct from "1";
+//   - Keyer, arrays of fixed-size elements and other types each get a tagged
+//     encoding that includes their type where needed.
+//
+// Byte strings are stored unchanged unless they start with keyEscape, so the
+// common string-key path does not allocate. Every other key starts with keyEscape
+// followed by a tag byte.
+const keyEscape = 0x00
+
+const (
+	tagString = 's' // Byte string that starts with keyEscape
+	tagUint   = 'u' // Non-negative integer, 8 bytes big-endian
+	tagInt    = 'i' // Negative integer, 8 bytes big-endian two's complement
+	tagKeyer  = 'k' // Keyer bytes
+	tagArray  = 'a' // Type name, keyEscape, then the elements in big-endian binary
+	tagOther  = 'v' // Type name, keyEscape, then the fmt %v representation
+)
+
+// encodeKey returns the store key for k.
+func encodeKey(k interface{}) string {
+	switch d := k.(type) {
+	case nil:
+		return tagged(tagOther, nil)
+	case string:
+		return encodeString(d)
+	case []byte:
+		if len(d) > 0 && d[0] == keyEscape {
+			return encodeString(string(d))
+		}
+		return string(d)
+	case RawKey:
+		return string(d)
+	case int:
+		return encodeInt(int64(d))
+	case int8:
+		return encodeInt(int64(d))
+	case int16:
+		return encodeInt(int64(d))
+	case int32:
+		return encodeInt(int64(d))
+	case int64:
+		return encodeInt(d)
+	case uint:
+		return encodeUint(uint64(d))
+	case uint8:
+		return encodeUint(uint64(d))
+	case uint16:
+		return encodeUint(uint64(d))
+	case uint32:
+		return encodeUint(uint64(d))
+	case uint64:
+		return encodeUint(d)
+	case Keyer:
+		return tagged(tagKeyer, d.CacheKey())
+	}
+	rv := reflect.ValueOf(k)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt(rv.Int()) // Named integers, enums with a String method included
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return encodeUint(rv.Uint())
+	}
+	if s, ok := k.(fmt.Stringer); ok {
+		return encodeString(s.String()) // String() is the key's natural form
+	}
+	if rv.Kind() == reflect.String { // Named string types join the plain keyspace
+		return encodeString(rv.String())
+	}
+	if s, ok := encodeArray(k); ok {
+		return s
+	}
+	return encodeTyped(tagOther, k, []byte(fmt.Sprintf("%v", k)))
+}
+
+func encodeString(s string) string {
+	if len(s) > 0 && s[0] == keyEscape {
+		return tagged(tagString, []byte(s))
+	}
+	return s
+}
+
+func encodeInt(v int64) string {
+	if v >= 0 {
+		return encodeUint(uint64(v))
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	return tagged(tagInt, b[:])
+}
+
+func encodeUint(v uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return tagged(tagUint, b[:])
+}
+
+// encodeArray encodes arrays whose elements have a fixed binary size,
+// such as [16]byte UUIDs or [2]int64 composite keys.
+func encodeArray(k interface{}) (string, bool) {
+	if reflect.TypeOf(k).Kind() != reflect.Array || binary.Size(k) < 0 {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if binary.Write(&buf, binary.BigEndian, k) != nil {
+		return "", false
+	}
+	return encodeTyped(tagArray, k, buf.Bytes()), true
+}
+
+// encodeTyped prefixes payload with the type name of k, keeping types apart.
+func encodeTyped(tag byte, k interface{}, payload []byte) string {
+	name := reflect.TypeOf(k).String()
+	b := make([]byte, 0, 2+len(name)+1+len(payload))
+	b = append(b, keyEscape, tag)
+	b = append(b, name...)
+	b = append(b, keyEscape)
+	b = append(b, payload...)
+	return string(b)
+}
+
+func tagged(tag byte, payload []byte) string {
+	b := make([]byte, 0, 2+len(payload))
+	b = append(b, keyEscape, tag)
+	b = append(b, payload...)
+	return string(b)
+}
+
+// decodeKey turns a store key back into a user-facing key: string for byte
+// strings, int64 for integers (uint64 above math.MaxInt64) and RawKey otherwise.
+// Passing the result back to the cache addresses the same entry.
+func decodeKey(s string) interface{} {
+	if len(s) < 2 || s[0] != keyEscape {
+		return s
+	}
+	payload := s[2:]
+	switch s[1] {
+	case tagString:
+		return payload
+	case tagUint:
+		if len(payload) == 8 {
+			v := binary.BigEndian.Uint64([]byte(payload))
+			if v <= math.MaxInt64 {
+				return int64(v)
+			}
+			return v
+		}
+	case tagInt:
+		if len(payload) == 8 {
+			return int64(binary.BigEndian.Uint64([]byte(payload)))
+		}
+	}
+	return RawKey(s)
+}
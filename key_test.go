@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type testKeyer []byte
+
+func (k testKeyer) CacheKey() []byte { return k }
+
+type testStringer struct{ n uint64 }
+
+func (s testStringer) String() string { return fmt.Sprintf("S%d", s.n) }
+
+// testStatus is an enum: an integer type with a String method.
+type testStatus int
+
+func (s testStatus) String() string { return []string{"S0", "S1", "S2"}[s] }
+
+type namedString string
+
+// keyIdentity is the reference model for key equality: the keyspace and the value within it.
+func keyIdentity(k interface{}) string {
+	switch d := k.(type) {
+	case string:
+		return "str:" + d
+	case namedString:
+		return "str:" + string(d)
+	case []byte:
+		return "str:" + string(d)
+	case testStringer:
+		return "str:" + d.String()
+	case int, int8, int16, int32, int64:
+		return fmt.Sprintf("int:%d", d)
+	case testStatus:
+		return fmt.Sprintf("int:%d", int(d))
+	case uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("int:%d", d)
+	case testKeyer:
+		return "keyer:" + string(d)
+	default:
+		return fmt.Sprintf("%T:%v", d, d)
+	}
+}
+
+// randomKey draws keys of many types from small value ranges so that equal
+// values of different types are frequent.
+func randomKey(r *rand.Rand) interface{} {
+	n := r.Intn(5) - 2
+	u := uint64(r.Intn(3))
+	strs := []string{"", "1", "-1", "a", "\x00", "\x00a", encodeUint(1), encodeInt(-1), "S1", string(tagged(tagKeyer, []byte("1")))}
+	s := strs[r.Intn(len(strs))]
+	switch r.Intn(19) {
+	case 0:
+		return n
+	case 1:
+		return int8(n)
+	case 2:
+		return int16(n)
+	case 3:
+		return int32(n)
+	case 4:
+		return int64(n)
+	case 5:
+		return uint(u)
+	case 6:
+		return uint8(u)
+	case 7:
+		return uint16(u)
+	case 8:
+		return uint32(u)
+	case 9:
+		return u
+	case 10:
+		return s
+	case 11:
+		return []byte(s)
+	case 12:
+		return namedString(s)
+	case 13:
+		return testStringer{u}
+	case 14:
+		return testKeyer(s)
+	case 15:
+		return [2]byte{byte(u), 1}
+	case 16:
+		return [1]uint16{uint16(u)<<8 | 1}
+	case 17:
+		return testStatus(u)
+	default:
+		return []interface{}{uint64(math.MaxUint64), int64(math.MinInt64), true, 1.5}[r.Intn(4)]
+	}
+}
+
+func TestKeyEncodingNoAliasing(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		a, b := randomKey(r), randomKey(r)
+		sameKey := encodeKey(a) == encodeKey(b)
+		sameIdentity := keyIdentity(a) == keyIdentity(b)
+		if sameKey != sameIdentity {
+			t.Fatalf("%T(%#v) vs %T(%#v): same store key %v, same identity %v", a, a, b, b, sameKey, sameIdentity)
+		}
+	}
+}
+
+func TestKeyDecodeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 10000; i++ {
+		k := randomKey(r)
+		enc := encodeKey(k)
+		if got := encodeKey(decodeKey(enc)); got != enc {
+			t.Fatalf("%T(%#v): decoded key %#v encodes to %q, want %q", k, k, decodeKey(enc), got, enc)
+		}
+	}
+}
+
+func TestMemoryKeyTypes(t *testing.T) {
+	c := NewMemory()
+	ctx := context.Background()
+
+	c.Put(ctx, "1", "string")
+	if _, err := c.Get(ctx, 1); err != ErrNoKey {
+		t.Fatalf("expected int 1 to miss after Put(\"1\"), got %v", err)
+	}
+	c.Put(ctx, int32(1), "int")
+	for _, k := range []interface{}{1, int64(1), uint8(1), uint64(1)} {
+		if v, err := c.Get(ctx, k); err != nil || v != "int" {
+			t.Fatalf("%T(1): expected int, got %v, %v", k, v, err)
+		}
+	}
+	if v, _ := c.Get(ctx, []byte("1")); v != "string" {
+		t.Fatalf("expected []byte key to share the string keyspace, got %v", v)
+	}
+
+	uuid := [16]byte{1, 2, 3}
+	c.Put(ctx, uuid, "uuid")
+	c.Put(ctx, testKeyer("k"), "keyer")
+
+	keys := make(map[interface{}]interface{})
+	c.Range(ctx, func(k interface{}, v interface{}) error {
+		keys[fmt.Sprintf("%T", k)] = k
+		if got, err := c.Get(ctx, k); err != nil || got != v {
+			t.Fatalf("Range key %#v does not address its entry: %v, %v", k, got, err)
+		}
+		return nil
+	})
+	if _, ok := keys["int64"]; !ok {
+		t.Fatalf("expected an int64 key from Range, got %v", keys)
+	}
+	if _, ok := keys["cache.RawKey"]; !ok {
+		t.Fatalf("expected RawKey keys from Range, got %v", keys)
+	}
+}
@@ -0,0 +1,462 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ListCache is implemented by backends storing a list of values under a key,
+// like Redis lists: Memory stores a List value, mysql.MysqlCache one row per
+// element in a child table. RPush with LPop (or BLPop) makes a FIFO queue.
+//
+// Lists live in the key space of the cache like hashes, see HashCache: a list
+// created by a push never expires, and popping or trimming the last element
+// deletes the key.
+//
+// Indexes of LRange and LTrim start at 0 and may be negative to count from the
+// end, -1 being the last element; see RangeBounds.
+type ListCache interface {
+	// LPush inserts values at the head, one after the other, and returns the new length.
+	// LPush(k, a, b) leaves b first.
+	LPush(ctx context.Context, k interface{}, values ...interface{}) (int, error)
+
+	// RPush appends values at the tail and returns the new length.
+	RPush(ctx context.Context, k interface{}, values ...interface{}) (int, error)
+
+	// LPop removes and returns the first element. It returns ErrNoKey if the list does not exist.
+	LPop(ctx context.Context, k interface{}) (interface{}, error)
+
+	// RPop removes and returns the last element. It returns ErrNoKey if the list does not exist.
+	RPop(ctx context.Context, k interface{}) (interface{}, error)
+
+	// BLPop is LPop waiting until an element is pushed, or ctx is done.
+	// Concurrent BLPop calls on a key each receive a different element.
+	BLPop(ctx context.Context, k interface{}) (interface{}, error)
+
+	// LRange returns the elements from start to stop, both included.
+	// It returns ErrNoKey if the list does not exist.
+	LRange(ctx context.Context, k interface{}, start, stop int) ([]interface{}, error)
+
+	// LTrim keeps the elements from start to stop, both included, and removes the others.
+	LTrim(ctx context.Context, k interface{}, start, stop int) error
+
+	// LLen returns the number of elements, 0 if the list does not exist.
+	LLen(ctx context.Context, k interface{}) (int, error)
+}
+
+// RangeBounds converts the inclusive, possibly negative indexes start and stop
+// of a sequence of n elements into the half-open range [lo, hi) they select.
+// Out of range indexes are clamped; lo == hi if the range is empty.
+func RangeBounds(n, start, stop int) (lo, hi int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// List is the value of a list key in Memory, as returned by Get. List operations
+// replace it with an updated List, so a List read from the cache never changes.
+//
+// Lists share their storage with the versions they were derived from: pushing
+// to the tail of a list or popping its head does not copy it, which makes
+// RPush and LPop a queue with O(1) operations. Popped elements are released
+// when the storage is next reallocated.
+//
+// A List is saved in snapshots as a JSON array, so elements are restored as
+// the types encoding/json decodes into interface{}.
+type List struct {
+	buf    *listBuf
+	lo, hi int   // Elements are buf.items[lo:hi]
+	size   int64 // Sum of the element sizes, see Memory.valueSize; -1 if not measured yet
+}
+
+// listBuf is the storage of Lists. items[lo:hi] is the largest range written so
+// far, shared by the Lists using it; pushes write outside of it, and only at a
+// boundary, so a List never sees its slots change. Guarded by the bucket lock.
+type listBuf struct {
+	items  []interface{}
+	lo, hi int
+}
+
+func init() {
+	RegisterSnapshotCodec(List{}, SnapshotCodec{
+		Name: "cache.List",
+		Encode: func(v interface{}) ([]byte, error) {
+			return json.Marshal(v.(List).Values())
+		},
+		Decode: func(data []byte) (interface{}, error) {
+			var vals []interface{}
+			if err := json.Unmarshal(data, &vals); err != nil {
+				return nil, err
+			}
+			l := List{}.push(vals, false, nil)
+			l.size = -1 // Measured by the cache it is loaded into, see measure
+			return l, nil
+		},
+	})
+}
+
+// Len returns the number of elements.
+func (l List) Len() int { return l.hi - l.lo }
+
+// Values returns a copy of the elements.
+func (l List) Values() []interface{} {
+	return l.slice(0, l.Len())
+}
+
+// slice returns a copy of the elements [lo, hi).
+func (l List) slice(lo, hi int) []interface{} {
+	if lo >= hi {
+		return []interface{}{}
+	}
+	vals := make([]interface{}, hi-lo)
+	copy(vals, l.buf.items[l.lo+lo:l.lo+hi])
+	return vals
+}
+
+// push returns l with vals added at the head if left is set, else at the tail.
+// size, if not nil, gives the size of each value.
+func (l List) push(vals []interface{}, left bool, size func(v interface{}) int64) List {
+	n := len(vals)
+	b := l.buf
+	if left {
+		if b == nil || l.lo != b.lo || l.lo < n {
+			l = l.realloc(n, 0)
+			b = l.buf
+		}
+		for i, v := range vals {
+			b.items[l.lo-1-i] = v
+		}
+		l.lo -= n
+		b.lo = l.lo
+	} else {
+		if b == nil || l.hi != b.hi || len(b.items)-l.hi < n {
+			l = l.realloc(0, n)
+			b = l.buf
+		}
+		copy(b.items[l.hi:], vals)
+		l.hi += n
+		b.hi = l.hi
+	}
+	if size != nil {
+		for _, v := range vals {
+			l.size += size(v)
+		}
+	}
+	return l
+}
+
+// measure returns l with its size computed by size.
+func (l List) measure(size func(v interface{}) int64) List {
+	l.size = 0
+	for _, v := range l.buf.items[l.lo:l.hi] {
+		l.size += size(v)
+	}
+	return l
+}
+
+// realloc returns l moved to new storage with room for front and back more elements.
+func (l List) realloc(front, back int) List {
+	n := l.Len()
+	if front > 0 {
+		front += n + 4
+	}
+	if back > 0 {
+		back += n + 4
+	}
+	b := &listBuf{items: make([]interface{}, front+n+back), lo: front, hi: front + n}
+	if n > 0 {
+		copy(b.items[front:], l.buf.items[l.lo:l.hi])
+	}
+	return List{buf: b, lo: b.lo, hi: b.hi, size: l.size}
+}
+
+// pop returns l without its first element if left is set, else its last, and
+// the element removed. l must not be empty.
+func (l List) pop(left bool, size func(v interface{}) int64) (List, interface{}) {
+	var v interface{}
+	if left {
+		v = l.buf.items[l.lo]
+		l.lo++
+	} else {
+		l.hi--
+		v = l.buf.items[l.hi]
+	}
+	if size != nil {
+		l.size -= size(v)
+	}
+	return l, v
+}
+
+// trim returns l reduced to its elements [lo, hi).
+func (l List) trim(lo, hi int, size func(v interface{}) int64) List {
+	if size != nil {
+		for _, v := range l.buf.items[l.lo : l.lo+lo] {
+			l.size -= size(v)
+		}
+		for _, v := range l.buf.items[l.lo+hi : l.hi] {
+			l.size -= size(v)
+		}
+	}
+	l.lo, l.hi = l.lo+lo, l.lo+hi
+	return l
+}
+
+var _ ListCache = (*Memory)(nil)
+
+// LPush inserts values at the head. Valuers are resolved first.
+func (m *Memory) LPush(ctx context.Context, k interface{}, values ...interface{}) (int, error) {
+	return m.push(k, values, true)
+}
+
+// RPush appends values at the tail. Valuers are resolved first.
+func (m *Memory) RPush(ctx context.Context, k interface{}, values ...interface{}) (int, error) {
+	return m.push(k, values, false)
+}
+
+func (m *Memory) push(k interface{}, values []interface{}, left bool) (int, error) {
+	vals := make([]interface{}, len(values))
+	for i, v := range values {
+		if vv, ok := v.(Valuer); ok {
+			var err error
+			if v, err = vv.Value(); err != nil {
+				return 0, err
+			}
+		}
+		vals[i] = v
+	}
+	n := 0
+	err := m.updateList(k, len(vals) > 0, func(l List) (List, error) {
+		l = l.push(vals, left, m.valueSize)
+		n = l.Len()
+		return l, nil
+	})
+	return n, err
+}
+
+func (m *Memory) LPop(ctx context.Context, k interface{}) (interface{}, error) {
+	return m.pop(k, true)
+}
+
+func (m *Memory) RPop(ctx context.Context, k interface{}) (interface{}, error) {
+	return m.pop(k, false)
+}
+
+func (m *Memory) pop(k interface{}, left bool) (interface{}, error) {
+	var v interface{}
+	err := ErrNoKey
+	uerr := m.updateList(k, false, func(l List) (List, error) {
+		l, v = l.pop(left, m.valueSize)
+		err = nil
+		return l, nil
+	})
+	if uerr != nil {
+		return nil, uerr
+	}
+	return v, err
+}
+
+// BLPop waits on a signal raised by pushes to k, see bucket.wake. It returns
+// ErrClosed if the cache is closed while waiting.
+func (m *Memory) BLPop(ctx context.Context, k interface{}) (interface{}, error) {
+	if err := m.ensureStarted(); err != nil {
+		return nil, err
+	}
+	if m.storage == StorageArena {
+		return nil, ErrArenaValue
+	}
+	keyStr, b := m.bucketFor(k)
+	for {
+		var v interface{}
+		var wait chan struct{}
+		err := m.updateListLocked(k, keyStr, b, false, func(l List) (List, error) {
+			l, v = l.pop(true, m.valueSize)
+			return l, nil
+		}, func() { wait = b.addWaiter(keyStr) })
+		if err != nil || wait == nil {
+			return v, err
+		}
+
+		select {
+		case <-wait:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-m.done:
+			err = ErrClosed
+		}
+		b.mu.Lock()
+		if !b.removeWaiter(keyStr, wait) {
+			b.wake(keyStr, 1) // Woken meanwhile: pass the element on
+		}
+		b.mu.Unlock()
+		return nil, err
+	}
+}
+
+func (m *Memory) LRange(ctx context.Context, k interface{}, start, stop int) ([]interface{}, error) {
+	l, err := m.list(k)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := RangeBounds(l.Len(), start, stop)
+	return l.slice(lo, hi), nil
+}
+
+func (m *Memory) LTrim(ctx context.Context, k interface{}, start, stop int) error {
+	return m.updateList(k, false, func(l List) (List, error) {
+		lo, hi := RangeBounds(l.Len(), start, stop)
+		return l.trim(lo, hi, m.valueSize), nil
+	})
+}
+
+func (m *Memory) LLen(ctx context.Context, k interface{}) (int, error) {
+	l, err := m.list(k)
+	if err == ErrNoKey {
+		return 0, nil
+	}
+	return l.Len(), err
+}
+
+// list returns the live List stored under k.
+func (m *Memory) list(k interface{}) (List, error) {
+	if !m.initialized() {
+		return List{}, ErrNoKey
+	}
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.load(keyStr)
+	if !ok || e == nil || e.Expired() {
+		return List{}, ErrNoKey
+	}
+	l, ok := e.Value.(List)
+	if !ok {
+		return List{}, ErrWrongType
+	}
+	return l, nil
+}
+
+// updateList stores fn(l), l being the List stored under k, under the bucket
+// lock, like updateHash: a missing or expired key is created if create is set,
+// otherwise fn is not called; a list left empty is deleted. Waiters of BLPop
+// are woken for the elements added.
+func (m *Memory) updateList(k interface{}, create bool, fn func(l List) (List, error)) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+	if m.storage == StorageArena {
+		return ErrArenaValue
+	}
+	keyStr, b := m.bucketFor(k)
+	return m.updateListLocked(k, keyStr, b, create, fn, nil)
+}
+
+// updateListLocked is updateList for the bucket b of k. missing, if not nil,
+// is called under the lock when fn is not called.
+func (m *Memory) updateListLocked(k interface{}, keyStr string, b *bucket, create bool, fn func(l List) (List, error), missing func()) error {
+	b.mu.Lock()
+	defer b.unlock()
+
+	e, ok := b.get(keyStr)
+	if !ok || e == nil || e.Expired() {
+		if !create {
+			if missing != nil {
+				missing()
+			}
+			return nil
+		}
+		l, err := fn(List{})
+		if err != nil || l.Len() == 0 {
+			return err
+		}
+		nowTime := m.now()
+		b.set(keyStr, &Entry{CreatedAt: nowTime, ExpiredAt: ExpiredAt(nowTime, NoExpiration), Value: l, clock: m.clock})
+		b.wake(keyStr, l.Len())
+		return nil
+	}
+	l, ok := e.Value.(List)
+	if !ok {
+		return ErrWrongType
+	}
+	if l.size < 0 {
+		l = l.measure(m.valueSize)
+	}
+	prevLen := l.Len()
+	l, err := fn(l)
+	if err != nil {
+		return err
+	}
+
+	if l.Len() == 0 {
+		b.remove(e)
+		b.emit(k, e, ReasonDeleted)
+		return nil
+	}
+	// Update the entry in place: it keeps its eviction position and timer,
+	// and only the size difference is accounted for.
+	if b.bounded() {
+		b.policy.access(e)
+	}
+	e = b.writable(e)
+	e.Value = l
+	e.Version = m.nextVersion()
+	b.resize(e)
+	if added := l.Len() - prevLen; added > 0 {
+		b.wake(keyStr, added)
+	}
+	return nil
+}
+
+// addWaiter registers a BLPop call waiting for an element of key; the channel
+// is closed by wake. Called under b.mu.
+func (b *bucket) addWaiter(key string) chan struct{} {
+	if b.waiters == nil {
+		b.waiters = make(map[string][]chan struct{})
+	}
+	ch := make(chan struct{})
+	b.waiters[key] = append(b.waiters[key], ch)
+	return ch
+}
+
+// wake wakes up to n waiters of key, oldest first. Called under b.mu.
+func (b *bucket) wake(key string, n int) {
+	ws := b.waiters[key]
+	for ; n > 0 && len(ws) > 0; n-- {
+		close(ws[0])
+		ws[0] = nil
+		ws = ws[1:]
+	}
+	if len(ws) == 0 {
+		delete(b.waiters, key)
+	} else {
+		b.waiters[key] = ws
+	}
+}
+
+// removeWaiter unregisters ch, returning false if it was woken already. Called under b.mu.
+func (b *bucket) removeWaiter(key string, ch chan struct{}) bool {
+	ws := b.waiters[key]
+	for i, w := range ws {
+		if w == ch {
+			ws = append(ws[:i:i], ws[i+1:]...)
+			if len(ws) == 0 {
+				delete(b.waiters, key)
+			} else {
+				b.waiters[key] = ws
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRangeBounds(t *testing.T) {
+	for _, tc := range []struct{ n, start, stop, lo, hi int }{
+		{5, 0, -1, 0, 5},
+		{5, 1, 2, 1, 3},
+		{5, -2, -1, 3, 5},
+		{5, -10, 10, 0, 5},
+		{5, 3, 1, 0, 0},
+		{5, 5, 10, 0, 0},
+		{0, 0, -1, 0, 0},
+	} {
+		if lo, hi := RangeBounds(tc.n, tc.start, tc.stop); lo != tc.lo || hi != tc.hi {
+			t.Errorf("RangeBounds(%d, %d, %d) = %d, %d, want %d, %d", tc.n, tc.start, tc.stop, lo, hi, tc.lo, tc.hi)
+		}
+	}
+}
+
+func TestMemoryList(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 1000}`},
+		"lockfree": {WithLockFreeReads()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := NewMemory(opts...).(*Memory)
+			defer c.Close(ctx)
+
+			if n, err := c.RPush(ctx, "l", "b", "c"); err != nil || n != 2 {
+				t.Fatalf("expected 2 elements, got %d, %v", n, err)
+			}
+			if n, _ := c.LPush(ctx, "l", "a", "z"); n != 4 {
+				t.Fatalf("expected 4 elements, got %d", n)
+			}
+			snap, _ := c.Get(ctx, "l")
+			if v, _ := c.LPop(ctx, "l"); v != "z" {
+				t.Fatalf("expected z, got %v", v)
+			}
+			c.RPush(ctx, "l", "d")
+			if vals, _ := c.LRange(ctx, "l", 0, -1); !reflect.DeepEqual(vals, []interface{}{"a", "b", "c", "d"}) {
+				t.Fatalf("unexpected elements %v", vals)
+			}
+			if vals := snap.(List).Values(); !reflect.DeepEqual(vals, []interface{}{"z", "a", "b", "c"}) {
+				t.Fatalf("expected a List read before to stay unchanged, got %v", vals)
+			}
+			if vals, _ := c.LRange(ctx, "l", -2, 10); !reflect.DeepEqual(vals, []interface{}{"c", "d"}) {
+				t.Fatalf("unexpected elements %v", vals)
+			}
+			if v, _ := c.RPop(ctx, "l"); v != "d" {
+				t.Fatalf("expected d, got %v", v)
+			}
+			if err := c.LTrim(ctx, "l", 1, -1); err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := c.LLen(ctx, "l"); n != 2 {
+				t.Fatalf("expected 2 elements, got %d", n)
+			}
+
+			c.LTrim(ctx, "l", 5, 10)
+			if _, err := c.LRange(ctx, "l", 0, -1); err != ErrNoKey {
+				t.Fatalf("expected trimming all elements to delete the key, got %v", err)
+			}
+			if _, err := c.LPop(ctx, "l"); err != ErrNoKey {
+				t.Fatalf("expected ErrNoKey, got %v", err)
+			}
+			if n, err := c.LLen(ctx, "l"); n != 0 || err != nil {
+				t.Fatalf("expected 0, got %d, %v", n, err)
+			}
+
+			c.Put(ctx, "s", "value")
+			if _, err := c.RPush(ctx, "s", 1); err != ErrWrongType {
+				t.Fatalf("expected ErrWrongType, got %v", err)
+			}
+			c.HSet(ctx, "h", map[string]interface{}{"f": 1})
+			if _, err := c.LPop(ctx, "h"); err != ErrWrongType {
+				t.Fatalf("expected ErrWrongType, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryListQueue(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(`{"maxBytes": 1000000}`).(*Memory)
+	defer c.Close(ctx)
+
+	// Interleaved pushes and pops reuse the storage and keep the size in step.
+	next := 0
+	for i := 0; i < 1000; i++ {
+		c.RPush(ctx, "q", 2*i, 2*i+1)
+		if v, _ := c.LPop(ctx, "q"); v != next {
+			t.Fatalf("expected %d, got %v", next, v)
+		}
+		next++
+	}
+	if n, _ := c.LLen(ctx, "q"); n != 1000 {
+		t.Fatalf("expected 1000 elements, got %d", n)
+	}
+	for ; next < 2000; next++ {
+		if v, _ := c.LPop(ctx, "q"); v != next {
+			t.Fatalf("expected %d, got %v", next, v)
+		}
+	}
+	if n := c.Stats().Bytes; n != 0 {
+		t.Fatalf("expected the emptied queue to free its bytes, got %d", n)
+	}
+}
+
+func TestMemoryBLPop(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+
+	const workers = 4
+	got := make(chan interface{}, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, err := c.BLPop(ctx, "q")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if v == "stop" {
+					return
+				}
+				got <- v
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond) // Let the workers block
+	for i := 0; i < 10; i++ {
+		c.RPush(ctx, "q", i)
+	}
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 10; i++ {
+		seen[<-got] = true
+	}
+	if len(seen) != 10 {
+		t.Fatalf("expected each element to be received once, got %v", seen)
+	}
+	for i := 0; i < workers; i++ {
+		c.RPush(ctx, "q", "stop")
+	}
+	wg.Wait()
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.BLPop(cctx, "q"); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if _, b := c.bucketFor("q"); len(b.waiters) != 0 {
+		t.Fatalf("expected the cancelled waiter to be removed, got %v", b.waiters)
+	}
+}
+
+func TestMemoryListInPlace(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c := NewMemory(WithClock(clk), WithTimingWheel(time.Second), `{"maxEntries": 64, "policy": "tinylfu"}`).(*Memory)
+	defer c.Close(ctx)
+	expired := make(chan ExpireEvent, 10)
+	c.ExpireEventHandler(func(ev ExpireEvent) { expired <- ev })
+
+	c.RPush(ctx, "q", "a")
+	c.ExpireIn(ctx, "q", time.Minute)
+	keyStr, b := c.bucketFor("q")
+	e := b.store[keyStr]
+	_, v0, _ := c.GetVersion(ctx, "q")
+
+	c.RPush(ctx, "q", "bc", "def")
+	c.LPop(ctx, "q")
+	if b.store[keyStr] != e {
+		t.Fatal("expected pushes and pops to update the entry in place")
+	}
+	if _, v1, _ := c.GetVersion(ctx, "q"); v1 == v0 {
+		t.Fatal("expected a push to change the version")
+	}
+	if n, want := c.Stats().Bytes, int64(len("q")+len("bc")+len("def")); n != want {
+		t.Fatalf("expected %d bytes, got %d", want, n)
+	}
+
+	clk.Advance(time.Minute + time.Second) // The timer set by ExpireIn still runs
+	select {
+	case ev := <-expired:
+		if ev.Reason != ReasonExpired || ev.Key != "q" {
+			t.Fatalf("expected q to expire, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the list was not expired by its original timer")
+	}
+}
+
+func TestMemoryListSnapshot(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+	c.RPush(ctx, "l", "a", "b")
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := NewMemory(`{"maxBytes": 1000000}`).(*Memory)
+	defer c2.Close(ctx)
+	if err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2.RPush(ctx, "l", "c")
+	if vals, err := c2.LRange(ctx, "l", 0, -1); err != nil || !reflect.DeepEqual(vals, []interface{}{"a", "b", "c"}) {
+		t.Fatalf("expected the list to be restored, got %v, %v", vals, err)
+	}
+	c2.Del(ctx, "l")
+	if n := c2.Stats().Bytes; n != 0 {
+		t.Fatalf("expected a restored list to be sized, got %d bytes left", n)
+	}
+}
+
+func TestMemoryListArena(t *testing.T) {
+	c := NewMemory(WithArena(1 << 20)).(*Memory)
+	defer c.Close(context.Background())
+	if _, err := c.RPush(context.Background(), "l", "v"); err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+// lruList is an intrusive doubly linked list of entries ordered by recency.
+// The front holds the most recently used entry, the back the least recently used.
+// Not thread-safe: always accessed with the owning bucket lock held.
+type lruList struct {
+	root Entry // Sentinel: root.next is the front, root.prev is the back
+	len  int
+}
+
+// init resets the list to empty. Entries still linked are simply dropped.
+func (l *lruList) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.len = 0
+}
+
+// pushFront inserts e as the most recently used entry.
+func (l *lruList) pushFront(e *Entry) {
+	if l.root.next == nil {
+		l.init()
+	}
+	e.prev = &l.root
+	e.next = l.root.next
+	l.root.next.prev = e
+	l.root.next = e
+	l.len++
+}
+
+// moveToFront marks e as the most recently used entry.
+// No-op if e is not linked into the list.
+func (l *lruList) moveToFront(e *Entry) {
+	if e.prev == nil || l.root.next == e {
+		return
+	}
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = &l.root
+	e.next = l.root.next
+	l.root.next.prev = e
+	l.root.next = e
+}
+
+// remove unlinks e from the list. No-op if e is not linked.
+func (l *lruList) remove(e *Entry) {
+	if e.prev == nil {
+		return
+	}
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.next = nil
+	l.len--
+}
+
+// back returns the least recently used entry, or nil if the list is empty.
+func (l *lruList) back() *Entry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
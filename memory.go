@@ -3,167 +3,744 @@ package cache
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"log"
 	"math/rand"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const defaultBucketCap = 16 // Default capacity per bucket (pre-allocated)
+const (
+	defaultBucketCap = 16  // Default capacity per bucket (pre-allocated)
+	defaultShards    = 256 // Default number of buckets
+)
+
+// Expiration strategies for Memory, selected with the "expiry" config key.
+const (
+	ExpirySweep = "sweep" // Periodic random sampling plus full sweeps (default)
+	ExpiryWheel = "wheel" // Hierarchical timing wheel: expire close to the deadline
+)
 
-// now returns current Unix timestamp in seconds.
+const defaultWheelTick = 100 * time.Millisecond // Default timing wheel resolution
+
+// now returns current Unix timestamp in nanoseconds.
 // Used for expiration calculations.
 func now() int64 {
-	return time.Now().Unix()
+	return time.Now().UnixNano()
+}
+
+// clk returns the configured Clock, falling back to SystemClock.
+func (m *Memory) clk() Clock {
+	if m.clock == nil {
+		return SystemClock
+	}
+	return m.clock
+}
+
+// now returns the current Unix timestamp in nanoseconds according to the cache clock.
+func (m *Memory) now() int64 {
+	return m.clk().Now().UnixNano()
+}
+
+// nextVersion returns a new entry version, unique within the cache.
+func (m *Memory) nextVersion() uint64 {
+	return atomic.AddUint64(&m.version, 1)
 }
 
 // NewMemory creates a new in-memory cache instance.
 // Supports lazy initialization and optional bucket capacity configuration.
+// Arguments may be a JSON config string and/or MemoryOption values.
 //
 // Usage:
 //
 //	cache.NewMemory()                          // default: 16 entries per bucket
 //	cache.NewMemory(`{"cap": 32}`)            // custom: 32 entries per bucket
 //	cache.NewMemory(`{"cap": -1}`)            // invalid: falls back to default (16)
+//	cache.NewMemory(`{"maxEntries": 100000}`) // bounded: LRU eviction beyond ~100000 entries
+//	cache.NewMemory(`{"maxBytes": 67108864}`) // bounded: LRU eviction beyond ~64MB
+//	cache.NewMemory(`{"maxEntries": 100000, "policy": "tinylfu"}`) // bounded: W-TinyLFU eviction
+//	cache.NewMemory(`{"expiry": "wheel", "wheelTick": "50ms"}`)    // timing wheel expiry index
+//	cache.NewMemory(cache.WithSizer(mySizer)) // custom size estimate for non-[]byte/string values
+//	cache.NewMemory(cache.WithClock(clk))     // injectable time source, e.g. a FakeClock in tests
+//	cache.NewMemory(`{"shards": 4096}`)       // more buckets for many-core write-heavy workloads
+//	cache.NewMemory(`{"lockFreeReads": true}`) // reads without locks, writes copy the bucket
+//	cache.NewMemory(`{"storage": "arena", "maxBytes": 1073741824}`) // []byte/string values off the GC heap
+//	cache.NewMemory(`{"snapshotFile": "/var/lib/app/cache.snap", "snapshotInterval": "1m"}`) // warm restarts
 //
-// Note: Buckets are initialized on first write (lazy loading).
-func NewMemory(args ...interface{}) Cache { // Parse optional JSON config: {"cap": N}
-	bucketCap := defaultBucketCap
-	if len(args) > 0 {
-		if cfgStr, ok := args[0].(string); ok {
-			var cfg struct {
-				Cap int `json:"cap"`
-			}
-			if json.Unmarshal([]byte(cfgStr), &cfg) == nil && cfg.Cap > 0 {
-				bucketCap = cfg.Cap
-			}
+// Note: Buckets are initialized on first write (lazy loading), or when a
+// snapshot file is restored.
+func NewMemory(args ...interface{}) Cache {
+	m := &Memory{bucketCap: defaultBucketCap}
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case string:
+			m.parseConfig(a)
+		case MemoryOption:
+			a(m)
+		}
+	}
+	if m.snapshotPath != "" {
+		if err := m.loadSnapshotFile(m.snapshotPath); err != nil {
+			m.log("cache: load snapshot:", err) // Start cold rather than fail
+		}
+	}
+	return m
+}
+
+// parseConfig applies a JSON config: {"cap": N, "maxEntries": N, "maxBytes": N, "policy": "lru"|"tinylfu",
+// "expiry": "sweep"|"wheel", "wheelTick": "100ms", "shards": N, "lockFreeReads": bool,
+// "storage": "heap"|"arena", "snapshotFile": "path", "snapshotInterval": "1m"}.
+// Invalid JSON or non-positive values are ignored.
+func (m *Memory) parseConfig(cfgStr string) {
+	var cfg struct {
+		Cap        int    `json:"cap"`
+		MaxEntries int    `json:"maxEntries"`
+		MaxBytes   int64  `json:"maxBytes"`
+		Policy     string `json:"policy"`
+		Expiry     string `json:"expiry"`
+		WheelTick  string `json:"wheelTick"`
+		Shards     int    `json:"shards"`
+		LockFree   bool   `json:"lockFreeReads"`
+		Storage    string `json:"storage"`
+		Snapshot   string `json:"snapshotFile"`
+		SnapEvery  string `json:"snapshotInterval"`
+	}
+	if json.Unmarshal([]byte(cfgStr), &cfg) != nil {
+		return
+	}
+	if cfg.Cap > 0 {
+		m.bucketCap = cfg.Cap
+	}
+	if cfg.MaxEntries > 0 {
+		m.maxEntries = cfg.MaxEntries
+	}
+	if cfg.MaxBytes > 0 {
+		m.maxBytes = cfg.MaxBytes
+	}
+	if cfg.Policy != "" {
+		m.policy = cfg.Policy
+	}
+	if cfg.Expiry != "" {
+		m.expiry = cfg.Expiry
+	}
+	if d, err := time.ParseDuration(cfg.WheelTick); err == nil && d > 0 {
+		m.wheelTick = d
+	}
+	if cfg.Shards > 0 {
+		m.shards = cfg.Shards
+	}
+	if cfg.LockFree {
+		m.lockFreeReads = true
+	}
+	if cfg.Storage != "" {
+		m.storage = cfg.Storage
+	}
+	if cfg.Snapshot != "" {
+		m.snapshotPath = cfg.Snapshot
+	}
+	if d, err := time.ParseDuration(cfg.SnapEvery); err == nil && d > 0 {
+		m.snapshotEvery = d
+	}
+}
+
+// MemoryOption configures a Memory cache. Pass it to NewMemory.
+type MemoryOption func(*Memory)
+
+// WithClock sets the time source used for expiration and the background sweeper.
+// Defaults to SystemClock; use a FakeClock in tests.
+func WithClock(c Clock) MemoryOption {
+	return func(m *Memory) { m.clock = c }
+}
+
+// WithDispatch configures the worker pool that delivers expire callbacks:
+// concurrency, queue size and what to do when the queue is full. See DispatchConfig.
+// Callbacks that a handler triggers by writing back into the cache are dropped
+// when they land on the full queue of the handler's own worker (see OverflowBlock).
+func WithDispatch(cfg DispatchConfig) MemoryOption {
+	return func(m *Memory) { m.dispatchCfg = cfg }
+}
+
+// WithTimingWheel switches expiration to a hierarchical timing wheel with the given
+// resolution (<= 0 uses 100ms). Entries are removed within one tick of their deadline,
+// and each tick costs time proportional to the number of expiring keys.
+func WithTimingWheel(tick time.Duration) MemoryOption {
+	return func(m *Memory) {
+		m.expiry = ExpiryWheel
+		if tick > 0 {
+			m.wheelTick = tick
+		}
+	}
+}
+
+// WithShards sets the number of buckets. n is rounded up to a power of two;
+// n <= 0 keeps the default of 256. More buckets reduce lock contention on many cores.
+func WithShards(n int) MemoryOption {
+	return func(m *Memory) {
+		if n > 0 {
+			m.shards = n
+		}
+	}
+}
+
+// WithHasher sets the function that picks the bucket of a key, see Hasher.
+// Defaults to XXHash; MapHasher gives a per-process random seed.
+func WithHasher(h Hasher) MemoryOption {
+	return func(m *Memory) { m.hasher = h }
+}
+
+// WithLockFreeReads makes Get, GetAndTTL and TTL read without taking any lock.
+// Each bucket publishes an immutable copy of its map after every write, so
+// writes cost time proportional to the bucket size; use more shards for large caches.
+// Bounded caches (maxEntries / maxBytes) keep locked reads, since a read updates
+// the eviction order.
+func WithLockFreeReads() MemoryOption {
+	return func(m *Memory) { m.lockFreeReads = true }
+}
+
+// WithArena switches storage to StorageArena: keys and values are serialized
+// into ring buffers of maxBytes in total (<= 0 keeps maxBytes, or 64MB if unset),
+// so the garbage collector no longer scans every entry. Only []byte and string
+// values are accepted (see ErrArenaValue); Get returns copies. When the ring is
+// full the oldest entries are evicted, whatever the policy. Arena caches always
+// sweep for expired entries and ignore maxEntries, the timing wheel and lock-free reads.
+// Each shard holds at most 4GiB-1; larger shares are clamped (see WithShards).
+func WithArena(maxBytes int64) MemoryOption {
+	return func(m *Memory) {
+		m.storage = StorageArena
+		if maxBytes > 0 {
+			m.maxBytes = maxBytes
 		}
 	}
+}
 
-	return &Memory{bucketCap: bucketCap}
+// WithSnapshotFile restores the cache from the snapshot at path when it is
+// created, saves a snapshot there every interval (<= 0: only on Close) and once
+// more on Close. Files are replaced atomically. A missing file starts the cache
+// empty; load and save errors are reported to the logger (see WithLogger).
+// See SaveSnapshot for the value types that can be saved.
+func WithSnapshotFile(path string, interval time.Duration) MemoryOption {
+	return func(m *Memory) {
+		m.snapshotPath = path
+		m.snapshotEvery = interval
+	}
+}
+
+// WithLogger sets the function reporting background errors, such as failed
+// snapshot saves and recovered handler panics. Defaults to log.Println.
+func WithLogger(logger func(v ...interface{})) MemoryOption {
+	return func(m *Memory) { m.logger = logger }
+}
+
+// WithSizer sets the function used to estimate the size of values that are
+// neither []byte nor string. See Sizer.
+func WithSizer(s Sizer) MemoryOption {
+	return func(m *Memory) { m.sizer = s }
 }
 
 // bucket is a sharded segment of the cache.
 // Each bucket has its own lock to reduce contention.
 type bucket struct {
-	mu    sync.RWMutex
-	_     [6]uint64 // Padding for cache-line alignment (reduce false sharing)
-	m     *Memory
-	store map[string]*Entry
+	mu     sync.RWMutex
+	_      [6]uint64 // Padding for cache-line alignment (reduce false sharing)
+	m      *Memory
+	store  map[string]*Entry
+	max    int            // Max entries in this bucket, 0 = unbounded
+	policy evictionPolicy // Eviction order, nil when unbounded
+
+	bytes     int64  // Approximate size of all entries in this bucket
+	maxBytes  int64  // Byte budget for this bucket, 0 = unbounded
+	evictions uint64 // Number of entries evicted to honour the limits
+
+	outbox   []queuedEvent // Events raised under the lock, dispatched by unlock
+	flushing int32         // Set while a goroutine drains outbox (atomic)
+
+	waiters map[string][]chan struct{} // BLPop calls waiting per key, see wake
+
+	arena *arena // Serialized entries when the cache uses StorageArena, store is unused
+
+	cow   bool         // Copy-on-write: readers use snap instead of taking mu
+	dirty bool         // store changed since snap was published
+	snap  atomic.Value // Read-only copy of store (map[string]*Entry), when cow
+}
+
+// queuedEvent is an expire event waiting in a bucket outbox.
+type queuedEvent struct {
+	key string // Bucket map key, selects the dispatch worker
+	ev  ExpireEvent
 }
 
 // Memory is the main cache structure.
-// Uses 256 sharded buckets for high concurrency.
+// Uses sharded buckets (256 by default) for high concurrency.
 type Memory struct {
+	version       uint64                             // Last entry version handed out (atomic, first for 64-bit alignment)
+	closed        int32                              // Set by Close (atomic)
+	ready         int32                              // Set once buckets are initialized (atomic)
 	once          sync.Once                          // Ensures one-time initialization
+	done          chan struct{}                      // Closed by Close to stop expireInLoop
+	loopDone      chan struct{}                      // Closed when expireInLoop returns
 	bucketCap     int                                // Capacity per bucket (for pre-allocation)
-	buckets       [256]*bucket                       // Sharded storage
+	maxEntries    int                                // Approximate total entry limit, 0 = unbounded
+	maxBytes      int64                              // Approximate total byte budget, 0 = unbounded
+	policy        string                             // Eviction policy name for bounded caches
+	sizer         Sizer                              // Size estimate for non-[]byte/string values
+	clock         Clock                              // Time source, nil = SystemClock
+	expiry        string                             // Expiration strategy, see ExpirySweep / ExpiryWheel
+	wheelTick     time.Duration                      // Timing wheel resolution
+	wheel         *timingWheel                       // Expiry index, nil when sweeping
+	shards        int                                // Number of buckets, a power of two
+	hasher        Hasher                             // Picks the bucket of a store key
+	lockFreeReads bool                               // Copy-on-write buckets, see WithLockFreeReads
+	storage       string                             // Storage mode, see StorageHeap / StorageArena
+	snapshotPath  string                             // Snapshot file, "" = no persistence
+	snapshotEvery time.Duration                      // Period of snapshot saves, 0 = only on Close
+	snapDone      chan struct{}                      // Closed when snapshotLoop returns, nil if not running
+	logger        func(v ...interface{})             // Reports background errors, nil = log.Println
+	mask          uint64                             // shards - 1
+	buckets       []*bucket                          // Sharded storage, set on first write
 	expireHandler func(k interface{}, v interface{}) // Optional callback on expiration
+	eventHandler  func(ev ExpireEvent)               // Optional callback for every removal, with reason
+	dispatchCfg   DispatchConfig                     // Callback delivery settings
+	dispatch      *Dispatcher                        // Delivers expire callbacks, created on first write
 }
 
 // ensureStarted initializes buckets and starts the cleanup goroutine.
 // Called lazily on first write operation. Thread-safe via sync.Once.
-func (m *Memory) ensureStarted() {
+// Returns ErrClosed once the cache has been closed.
+func (m *Memory) ensureStarted() error {
+	if atomic.LoadInt32(&m.closed) != 0 {
+		return ErrClosed
+	}
 	m.once.Do(func() {
 		bcap := m.bucketCap
 		if bcap <= 0 {
 			bcap = defaultBucketCap
 		}
-		for i := 0; i < len(m.buckets); i++ {
-			m.buckets[i] = &bucket{
-				m:     m,
-				store: make(map[string]*Entry, bcap), // Pre-allocate for performance
+		n := defaultShards
+		if m.shards > 0 {
+			n = nextPowerOfTwo(m.shards)
+		}
+		if m.hasher == nil {
+			m.hasher = XXHash
+		}
+		m.mask = uint64(n - 1)
+		m.buckets = make([]*bucket, n)
+		// Limits are split evenly across buckets (rounded up), so the
+		// effective totals may slightly exceed maxEntries / maxBytes.
+		bmax := 0
+		if m.maxEntries > 0 {
+			bmax = (m.maxEntries + n - 1) / n
+		}
+		var bmaxBytes int64
+		if m.maxBytes > 0 {
+			bmaxBytes = (m.maxBytes + int64(n) - 1) / int64(n)
+		}
+		if m.storage == StorageArena {
+			m.initArenas(n)
+		} else {
+			for i := 0; i < len(m.buckets); i++ {
+				m.buckets[i] = &bucket{
+					m:        m,
+					store:    make(map[string]*Entry, bcap), // Pre-allocate for performance
+					max:      bmax,
+					maxBytes: bmaxBytes,
+				}
+				if bmax > 0 || bmaxBytes > 0 {
+					m.buckets[i].policy = newPolicy(m.policy, bmax, bmaxBytes)
+				} else if m.lockFreeReads {
+					m.buckets[i].cow = true
+					m.buckets[i].snap.Store(map[string]*Entry{})
+				}
 			}
 		}
-		go m.expireInLoop() // Start background cleanup
+		if m.dispatchCfg.Logger == nil {
+			m.dispatchCfg.Logger = m.logger
+		}
+		m.dispatch = NewDispatcher(m.dispatchCfg)
+		m.done = make(chan struct{})
+		m.loopDone = make(chan struct{})
+		tick := m.wheelTick
+		if tick <= 0 {
+			tick = defaultWheelTick
+		}
+		if m.expiry == ExpiryWheel && m.storage != StorageArena {
+			m.wheel = newTimingWheel(int64(tick), m.now())
+		}
+		atomic.StoreInt32(&m.ready, 1) // Publishes the fields above to lock-free readers
+
+		// Create the ticker before starting the goroutine so that a FakeClock
+		// advanced right after the first write already drives it.
+		if m.snapshotPath != "" && m.snapshotEvery > 0 {
+			m.snapDone = make(chan struct{})
+			go m.snapshotLoop(m.clk().NewTicker(m.snapshotEvery))
+		}
+		if m.wheel != nil {
+			go m.wheelLoop(m.clk().NewTicker(tick))
+			return
+		}
+		go m.expireInLoop(m.clk().NewTicker(5 * time.Second)) // Start background cleanup
 	})
+	if !m.initialized() {
+		return ErrClosed // Closed before the first write
+	}
+	return nil
+}
+
+// initialized reports whether the buckets have been created by the first write.
+func (m *Memory) initialized() bool {
+	return atomic.LoadInt32(&m.ready) != 0
+}
+
+// bucketFor returns the store key for k (see encodeKey) and the bucket holding it.
+// The bucket is derived from the store key, so equal keys always share a bucket.
+func (m *Memory) bucketFor(k interface{}) (string, *bucket) {
+	s := encodeKey(k)
+	return s, m.buckets[m.hasher(s)&m.mask]
+}
+
+// nextPowerOfTwo returns the smallest power of two >= n, for n >= 1.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
 }
 
 // expireInLoop runs periodic expiration cleanup.
 // Strategy: random sampling + full sweep to avoid CPU spikes.
-func (m *Memory) expireInLoop() {
-	ticker := time.NewTicker(5 * time.Second)
+func (m *Memory) expireInLoop(ticker Ticker) {
 	defer ticker.Stop()
+	defer close(m.loopDone)
 
 	fullCleanupCounter := 0
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C():
+		case <-m.done:
+			return
+		}
 		fullCleanupCounter++
 
 		// Random sampling: clean 10 random buckets per tick (spread CPU load)
 		for i := 0; i < 10; i++ {
-			idx := rand.Intn(256)
+			idx := rand.Intn(len(m.buckets))
 			m.buckets[idx].cleanup()
 		}
 
 		// Full sweep: clean all buckets every 30 seconds (6 ticks)
 		if fullCleanupCounter >= 6 {
 			fullCleanupCounter = 0
-			for i := 0; i < 256; i++ {
-				m.buckets[i].cleanup()
+			for _, b := range m.buckets {
+				b.cleanup()
 			}
 		}
 	}
 }
 
+// wheelLoop advances the timing wheel every tick and removes the entries that became due.
+func (m *Memory) wheelLoop(ticker Ticker) {
+	defer ticker.Stop()
+	defer close(m.loopDone)
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-m.done:
+			return
+		}
+		for _, t := range m.wheel.advance(m.now()) {
+			t.b.expireTimer(t)
+		}
+	}
+}
+
+// expireTimer removes the entry of a fired timer if it is still stored and expired.
+// Entries whose deadline moved in the meantime are scheduled again.
+func (b *bucket) expireTimer(t *wheelTimer) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	e := t.e
+	if e.timer != t || b.store[e.key] != e {
+		return // Deleted, replaced or evicted since the timer fired
+	}
+	if !e.Expired() {
+		b.m.wheel.schedule(b, e)
+		return
+	}
+	b.remove(e)
+	b.emit(nil, e, ReasonExpired)
+}
+
+// schedule registers e's deadline in the timing wheel, if the cache uses one.
+// Must be called with bucket lock held.
+func (b *bucket) schedule(e *Entry) {
+	if b.m.wheel != nil {
+		b.m.wheel.schedule(b, e)
+	}
+}
+
 // cleanup removes all expired entries from this bucket.
 // Must be called with bucket lock held.
 func (b *bucket) cleanup() {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	defer b.unlock()
 
-	for k, v := range b.store {
+	if b.arena != nil {
+		b.arenaCleanup()
+		return
+	}
+	for _, v := range b.store {
 		if v.Expired() {
-			delete(b.store, k)
+			b.remove(v)
 			// Async callback: notify handler without blocking cleanup
-			if b.m.expireHandler != nil {
-				go b.m.expireHandler(k, v.Value)
+			b.emit(nil, v, ReasonExpired)
+		}
+	}
+}
+
+// load returns the entry stored under key.
+// For bounded buckets the entry is also marked as recently used,
+// which requires the write lock instead of the read lock.
+func (b *bucket) load(key string) (*Entry, bool) {
+	if !b.bounded() {
+		return b.lookup(key)
+	}
+
+	b.mu.Lock()
+	e, ok := b.store[key]
+	if ok && e != nil {
+		b.policy.access(e)
+	}
+	b.mu.Unlock()
+	return e, ok
+}
+
+// lookup returns the entry stored under key without touching the eviction order.
+// Copy-on-write buckets are read from their published snapshot, without locking.
+func (b *bucket) lookup(key string) (*Entry, bool) {
+	if b.arena != nil {
+		b.mu.RLock()
+		e, _, ok := b.arenaLoad(key)
+		b.mu.RUnlock()
+		return e, ok
+	}
+	if b.cow {
+		e, ok := b.snap.Load().(map[string]*Entry)[key]
+		return e, ok
+	}
+	b.mu.RLock()
+	e, ok := b.store[key]
+	b.mu.RUnlock()
+	return e, ok
+}
+
+// publish makes the current store visible to lock-free readers if it changed.
+// Must be called with bucket lock held.
+func (b *bucket) publish() {
+	if !b.cow || !b.dirty {
+		return
+	}
+	snap := make(map[string]*Entry, len(b.store))
+	for k, e := range b.store {
+		snap[k] = e
+	}
+	b.snap.Store(snap)
+	b.dirty = false
+}
+
+// bounded reports whether the bucket enforces an entry or byte limit.
+func (b *bucket) bounded() bool {
+	return b.policy != nil
+}
+
+// overLimit reports whether the bucket exceeds its entry or byte limit.
+func (b *bucket) overLimit() bool {
+	return (b.max > 0 && len(b.store) > b.max) || (b.maxBytes > 0 && b.bytes > b.maxBytes)
+}
+
+// set stores e under key, replacing any previous entry.
+// For bounded buckets, entries are evicted according to the bucket's policy
+// until it fits its limits; evictions are reported to expireHandler.
+// Must be called with bucket lock held.
+func (b *bucket) set(key string, e *Entry) {
+	e.Version = b.m.nextVersion()
+	if b.arena != nil {
+		b.arenaSet(key, e)
+		return
+	}
+	if old, ok := b.store[key]; ok && old != nil {
+		b.remove(old)
+		reason := ReasonReplaced
+		if old.Expired() {
+			reason = ReasonExpired // Expired but not yet swept
+		}
+		b.emit(nil, old, reason)
+	}
+	e.key = key
+	e.size = b.m.entrySize(key, e.Value)
+	b.store[key] = e
+	b.dirty = true
+	b.bytes += e.size
+	b.schedule(e)
+	if !b.bounded() {
+		return
+	}
+
+	b.policy.add(e)
+	b.evict(e)
+}
+
+// resize recomputes the size of e after its value changed in place (e.g. in Tx),
+// evicting other entries if the bucket went over its byte budget.
+// Must be called with bucket lock held.
+func (b *bucket) resize(e *Entry) {
+	size := b.m.entrySize(e.key, e.Value)
+	b.bytes += size - e.size
+	old := e.size
+	e.size = size
+	if b.bounded() {
+		b.policy.resized(e, old)
+		b.evict(e)
+	}
+}
+
+// writable returns e to be modified in place. Copy-on-write buckets return a
+// copy replacing e in the store instead, as lock-free readers may still hold e;
+// the copy is published by unlock. Copy-on-write buckets are never bounded, so
+// the copy needs no eviction bookkeeping.
+// Must be called with bucket lock held.
+func (b *bucket) writable(e *Entry) *Entry {
+	if !b.cow {
+		return e
+	}
+	if b.m.wheel != nil {
+		b.m.wheel.unschedule(e)
+	}
+	c := *e
+	b.store[e.key] = &c
+	b.dirty = true
+	b.schedule(&c)
+	return &c
+}
+
+// evict drops entries chosen by the eviction policy until the bucket fits its limits.
+// keep is never evicted, so a single oversized entry may occupy a bucket alone.
+// Must be called with bucket lock held.
+func (b *bucket) evict(keep *Entry) {
+	for b.overLimit() {
+		victim := b.policy.victim()
+		if victim == nil || victim == keep {
+			break
+		}
+		b.remove(victim)
+		b.evictions++
+		b.emit(nil, victim, ReasonEvicted)
+	}
+}
+
+// emit queues an event for e leaving the bucket for reason. k is the key as given
+// by the caller, or nil to report the key decoded from the store (see decodeKey).
+// It is dispatched by unlock, outside the lock, so that a handler writing back
+// into the cache cannot deadlock with a blocking dispatch.
+// Must be called with bucket lock held.
+func (b *bucket) emit(k interface{}, e *Entry, reason ExpireReason) {
+	if b.m.hasHandler(reason) {
+		if k == nil {
+			k = decodeKey(e.key)
+		}
+		b.outbox = append(b.outbox, queuedEvent{key: e.key, ev: entryEvent(k, e, reason)})
+	}
+}
+
+// unlock publishes the changes made under the write lock to lock-free readers,
+// releases the lock and dispatches the events queued while it was held.
+func (b *bucket) unlock() {
+	b.publish()
+	pending := len(b.outbox) > 0
+	b.mu.Unlock()
+	if pending {
+		b.flush()
+	}
+}
+
+// flush dispatches outbox events in the order they were queued. Only one goroutine
+// drains a bucket at a time; others return immediately and leave their events to it,
+// which keeps events for a key ordered without waiting on a blocked dispatcher.
+func (b *bucket) flush() {
+	for atomic.CompareAndSwapInt32(&b.flushing, 0, 1) {
+		for {
+			b.mu.Lock()
+			evs := b.outbox
+			b.outbox = nil
+			b.mu.Unlock()
+			if len(evs) == 0 {
+				break
+			}
+			for _, q := range evs {
+				b.m.notify(q.key, q.ev)
 			}
 		}
+		atomic.StoreInt32(&b.flushing, 0)
+
+		// Events queued after the last check but before the flag was cleared
+		// were left to us by their producer: take over again if there are any.
+		b.mu.RLock()
+		more := len(b.outbox) > 0
+		b.mu.RUnlock()
+		if !more {
+			return
+		}
 	}
 }
 
-// hashString computes DJB2 hash with proper bit mixing.
-// Inlined by compiler for performance.
-func hashString(s string) uint8 {
-	var h uint32 = 5381
-	for i := 0; i < len(s); i++ {
-		h = h*33 + uint32(s[i])
+// get returns the entry stored under key; for arena buckets a detached copy.
+// Must be called with bucket lock held.
+func (b *bucket) get(key string) (*Entry, bool) {
+	if b.arena != nil {
+		e, _, ok := b.arenaLoad(key)
+		return e, ok
 	}
-	return uint8(h ^ (h >> 8) ^ (h >> 16) ^ (h >> 24))
+	e, ok := b.store[key]
+	return e, ok
 }
 
-// hashInt computes hash for integer keys without string conversion.
-func hashInt(v uint64) uint8 {
-	var h uint32 = 5381
-	for i := 0; i < 8; i++ {
-		h = h*33 + uint32(v&0xff)
-		v >>= 8
+// len returns the number of stored entries.
+// Must be called with bucket lock (read or write) held.
+func (b *bucket) len() int {
+	if b.arena != nil {
+		return len(b.arena.index)
 	}
-	return uint8(h ^ (h >> 8) ^ (h >> 16) ^ (h >> 24))
+	return len(b.store)
 }
 
-func hashKey(k interface{}) (string, uint8) {
-	switch d := k.(type) {
-	case string:
-		return d, hashString(d)
-	case []byte:
-		return string(d), hashString(BytesToStr(d))
-	case int:
-		return strconv.Itoa(d), hashInt(uint64(d))
-	case int64:
-		return strconv.FormatInt(d, 10), hashInt(uint64(d))
-	case uint64:
-		return strconv.FormatUint(d, 10), hashInt(d)
-	default:
-		var s string
-		if ss, ok := k.(interface{ String() string }); ok {
-			s = ss.String()
-		} else {
-			s = fmt.Sprintf("%v", d)
+// size returns the approximate size of all stored entries.
+// Must be called with bucket lock (read or write) held.
+func (b *bucket) size() int64 {
+	if b.arena != nil {
+		return b.arena.live
+	}
+	return b.bytes
+}
+
+// remove deletes e from the bucket.
+// Must be called with bucket lock held.
+func (b *bucket) remove(e *Entry) {
+	if b.arena != nil {
+		if off, r, ok := b.arena.lookup(b.m.hasher(e.key), e.key); ok {
+			b.arena.remove(off, r)
 		}
-		return s, hashString(s)
+		return
 	}
+	delete(b.store, e.key)
+	b.dirty = true
+	if b.policy != nil {
+		b.policy.remove(e)
+	}
+	if b.m.wheel != nil {
+		b.m.wheel.unschedule(e)
+	}
+	b.bytes -= e.size
 }
 
 // ==================== READ OPERATIONS (Lazy: no auto-init) ====================
@@ -173,17 +750,13 @@ func hashKey(k interface{}) (string, uint8) {
 // Note: Does NOT trigger initialization if cache is uninitialized.
 func (m *Memory) Get(ctx context.Context, k interface{}) (interface{}, error) {
 	// Fast path: uninitialized = empty cache
-	if m.buckets[0] == nil {
+	if !m.initialized() {
 		return nil, ErrNoKey
 	}
 
-	// Optimized: hashKey returns both string key and bucket index in one pass
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
-
-	b.mu.RLock()
-	e, ok := b.store[keyStr]
-	b.mu.RUnlock()
+	// Optimized: bucketFor returns both string key and bucket in one pass
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.load(keyStr)
 
 	if !ok || e == nil || e.Expired() {
 		return nil, ErrNoKey
@@ -194,46 +767,55 @@ func (m *Memory) Get(ctx context.Context, k interface{}) (interface{}, error) {
 // GetAndTTL retrieves value and its remaining TTL.
 // Returns ErrNoKey if not found or expired.
 func (m *Memory) GetAndTTL(ctx context.Context, k interface{}) (interface{}, int64, error) {
-	if m.buckets[0] == nil {
+	v, ttl, err := m.GetAndTTLDuration(ctx, k)
+	return v, TTLSeconds(ttl), err
+}
+
+// GetAndTTLDuration retrieves value and its exact remaining TTL, NoExpiration if
+// it never expires. Returns ErrNoKey if not found or expired. See DurationGetter.
+func (m *Memory) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	if !m.initialized() {
 		return nil, 0, ErrNoKey
 	}
 
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
-
-	b.mu.RLock()
-	e, ok := b.store[keyStr]
-	b.mu.RUnlock()
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.load(keyStr)
 
 	if !ok || e == nil {
 		return nil, 0, ErrNoKey
 	}
 
-	ttl := e.TTL()
+	ttl := e.TTLDuration()
 	if ttl == 0 {
 		return nil, 0, ErrNoKey
 	}
 	return e.Value, ttl, nil
 }
 
-// TTL returns remaining TTL for a key.
+// TTL returns remaining TTL for a key in seconds, rounded up.
 // Returns ErrNoKey if not found or expired.
 func (m *Memory) TTL(ctx context.Context, k interface{}) (int64, error) {
-	if m.buckets[0] == nil {
+	ttl, err := m.TTLDuration(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return TTLSeconds(ttl), nil
+}
+
+// TTLDuration returns remaining TTL for a key, NoExpiration if it never expires.
+// Returns ErrNoKey if not found or expired.
+func (m *Memory) TTLDuration(ctx context.Context, k interface{}) (time.Duration, error) {
+	if !m.initialized() {
 		return 0, ErrNoKey
 	}
 
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
-
-	b.mu.RLock()
-	e, ok := b.store[keyStr]
-	b.mu.RUnlock()
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.lookup(keyStr)
 
 	if !ok || e == nil {
 		return 0, ErrNoKey
 	}
-	ttl := e.TTL()
+	ttl := e.TTLDuration()
 	if ttl == 0 {
 		return 0, ErrNoKey
 	}
@@ -245,23 +827,27 @@ func (m *Memory) TTL(ctx context.Context, k interface{}) (int64, error) {
 // Put stores a value with no expiration.
 // Triggers lazy initialization on first call.
 func (m *Memory) Put(ctx context.Context, k interface{}, v interface{}) error {
-	return m.PutEx(ctx, k, v, -1)
+	return m.PutTTL(ctx, k, v, NoExpiration)
 }
 
 // PutEx stores a value with TTL in seconds.
 // sec < 0 means never expire.
 func (m *Memory) PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error {
-	m.ensureStarted()
-
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
+	return m.PutTTL(ctx, k, v, SecondsToTTL(sec))
+}
 
-	nowTime := now()
-	expiredAt := nowTime + sec
-	if sec < 0 {
-		expiredAt = -1
+// PutTTL stores a value with a nanosecond-resolution TTL.
+// ttl < 0 means never expire.
+func (m *Memory) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
 	}
 
+	keyStr, b := m.bucketFor(k)
+
+	nowTime := m.now()
+	expiredAt := ExpiredAt(nowTime, ttl)
+
 	var err error
 	if vv, ok := v.(Valuer); ok {
 		if v, err = vv.Value(); err != nil {
@@ -269,58 +855,72 @@ func (m *Memory) PutEx(ctx context.Context, k interface{}, v interface{}, sec in
 		}
 	}
 
-	e := &Entry{CreatedAt: nowTime, ExpiredAt: expiredAt, Value: v}
+	if b.arena != nil {
+		if err := b.checkArenaValue(keyStr, v); err != nil {
+			return err
+		}
+	}
+
+	e := &Entry{CreatedAt: nowTime, ExpiredAt: expiredAt, Value: v, clock: m.clock}
 	b.mu.Lock()
-	b.store[keyStr] = e
-	b.mu.Unlock()
+	b.set(keyStr, e)
+	b.unlock()
 	return nil
 }
 
 // Del removes a key from cache.
 // Triggers expireHandler callback asynchronously if set.
 func (m *Memory) Del(ctx context.Context, k interface{}) error {
-	m.ensureStarted()
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
 
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
+	keyStr, b := m.bucketFor(k)
 
-	var val interface{}
 	b.mu.Lock()
-	e, ok := b.store[keyStr]
+	e, ok := b.get(keyStr)
 	if ok && e != nil {
-		delete(b.store, keyStr)
-		val = e.Value
+		b.remove(e)
+		b.emit(k, e, ReasonDeleted)
 	}
-	b.mu.Unlock()
+	b.unlock()
 
 	if !ok || e == nil {
 		return ErrNoKey
 	}
-	// Async callback: non-blocking notification
-	if h := b.m.expireHandler; h != nil {
-		go h(k, val)
-	}
 	return nil
 }
 
 // Expire updates the expiration time for an existing key.
 // sec < 0 sets to never expire.
 func (m *Memory) Expire(ctx context.Context, k interface{}, sec int64) error {
-	m.ensureStarted()
+	return m.ExpireIn(ctx, k, SecondsToTTL(sec))
+}
 
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
+// ExpireIn sets an existing key to expire ttl from now.
+// ttl < 0 sets to never expire.
+func (m *Memory) ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+
+	keyStr, b := m.bucketFor(k)
 
 	b.mu.Lock()
-	e, ok := b.store[keyStr]
-	if ok && e != nil {
-		if sec < 0 {
-			e.ExpiredAt = -1
-		} else {
-			e.ExpiredAt = now() + sec
+	var e *Entry
+	var ok bool
+	if b.arena != nil {
+		var off uint32
+		if e, off, ok = b.arenaLoad(keyStr); ok {
+			e.ExpireIn(ttl)
+			b.arena.setExpiredAt(off, e.ExpiredAt)
 		}
+	} else if e, ok = b.store[keyStr]; ok && e != nil {
+		e = b.writable(e)
+		e.ExpireIn(ttl)
+		b.schedule(e)
 	}
-	b.mu.Unlock()
+	b.unlock()
 
 	if !ok || e == nil {
 		return ErrNoKey
@@ -332,32 +932,130 @@ func (m *Memory) Expire(ctx context.Context, k interface{}, sec int64) error {
 // The entry passed to fn implements Valuer, allowing TTL/Expire manipulation.
 // Useful for atomic read-modify-write operations.
 func (m *Memory) Tx(ctx context.Context, k interface{}, fn func(*Entry) error) error {
-	m.ensureStarted()
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
 
-	keyStr, idx := hashKey(k)
-	b := m.buckets[idx]
+	keyStr, b := m.bucketFor(k)
 
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	defer b.unlock()
 
-	e, ok := b.store[keyStr]
+	e, ok := b.get(keyStr)
 	if !ok || e == nil {
 		return ErrNoKey
 	}
+	if b.arena != nil {
+		err := fn(e)
+		e.Version = m.nextVersion()
+		if uerr := b.arenaUpdate(e); uerr != nil {
+			return uerr // The stored entry is left unchanged
+		}
+		return err
+	}
+	if b.bounded() {
+		b.policy.access(e)
+	}
 
+	e = b.writable(e)
+	expiredAt := e.ExpiredAt
 	err := fn(e)
+	e.Version = m.nextVersion() // fn's changes are kept even if it fails
+	b.resize(e)                 // fn may have replaced the value
+	if e.ExpiredAt != expiredAt {
+		b.schedule(e) // fn may have changed the TTL
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// ExpireHandler sets a callback function invoked when entries expire or are deleted.
-// Callbacks run asynchronously to avoid blocking cache operations.
+// ExpireHandler sets a callback function invoked when entries expire, are deleted or evicted.
+// Callbacks run asynchronously on the dispatcher workers (see WithDispatch), in order per key.
 func (m *Memory) ExpireHandler(h func(k interface{}, v interface{})) {
 	m.expireHandler = h
 }
 
+// ExpireEventHandler sets a callback invoked for every entry leaving the cache,
+// including overwrites and Clear. Callbacks run asynchronously.
+func (m *Memory) ExpireEventHandler(h func(ev ExpireEvent)) {
+	m.eventHandler = h
+}
+
+// entryEvent describes e, stored under k, leaving the cache for reason.
+func entryEvent(k interface{}, e *Entry, reason ExpireReason) ExpireEvent {
+	return ExpireEvent{Key: k, Value: e.Value, Reason: reason, CreatedAt: e.CreatedAt, ExpiredAt: e.ExpiredAt}
+}
+
+// hasHandler reports whether an event of reason would reach any expire handler.
+func (m *Memory) hasHandler(reason ExpireReason) bool {
+	return m.eventHandler != nil || (m.expireHandler != nil && reason.Legacy())
+}
+
+// notify hands the expire handlers to the dispatcher, on the worker owning key.
+func (m *Memory) notify(key string, ev ExpireEvent) {
+	h, eh := m.expireHandler, m.eventHandler
+	if !ev.Reason.Legacy() {
+		h = nil
+	}
+	if h == nil && eh == nil {
+		return
+	}
+	m.dispatch.Dispatch(key, func() {
+		if h != nil {
+			h(ev.Key, ev.Value)
+		}
+		if eh != nil {
+			eh(ev)
+		}
+	})
+}
+
+// Close stops the background goroutines, saves a final snapshot if a snapshot
+// file is configured, and waits until pending expire callbacks have returned or
+// ctx is done, returning ctx.Err() in the latter case.
+// After Close, writes return ErrClosed; reads still serve the remaining entries.
+// Closing an already closed cache is a no-op.
+func (m *Memory) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&m.closed, 0, 1) {
+		return nil
+	}
+	m.once.Do(func() {}) // Never start the sweeper after Close
+	if m.done == nil {
+		return nil // Never started
+	}
+	close(m.done)
+	for _, ch := range []chan struct{}{m.loopDone, m.snapDone} {
+		if ch == nil {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			m.dispatch.Close(ctx) // Stop the workers without waiting
+			return ctx.Err()
+		}
+	}
+	var err error
+	if m.snapshotPath != "" {
+		err = m.saveSnapshotFile(m.snapshotPath)
+	}
+	if derr := m.dispatch.Close(ctx); derr != nil {
+		return derr
+	}
+	return err
+}
+
+// log reports a background error to the configured logger.
+func (m *Memory) log(v ...interface{}) {
+	if m.logger != nil {
+		m.logger(v...)
+		return
+	}
+	log.Println(v...)
+}
+
 func (m *Memory) Scan(ctx context.Context, k interface{}, scan Scanner) error {
 	v, err := m.Get(ctx, k)
 	if err != nil {
@@ -382,7 +1080,7 @@ func (m *Memory) ScanAndTTL(ctx context.Context, k interface{}, scan Scanner) (i
 // The iteration stops if fn returns an error, and that error is returned.
 // Safe to call on uninitialized cache (no-op).
 func (m *Memory) Range(ctx context.Context, fn func(k interface{}, v interface{}) error) error {
-	if m.buckets[0] == nil {
+	if !m.initialized() {
 		return nil
 	}
 
@@ -393,10 +1091,17 @@ func (m *Memory) Range(ctx context.Context, fn func(k interface{}, v interface{}
 	for _, b := range m.buckets {
 		// Snapshot one bucket under lock.
 		b.mu.RLock()
-		snapshot := make([]pair, 0, len(b.store))
+		snapshot := make([]pair, 0, b.len())
+		if b.arena != nil {
+			b.arenaEach(func(e *Entry) {
+				if !e.Expired() {
+					snapshot = append(snapshot, pair{decodeKey(e.key), e.Value})
+				}
+			})
+		}
 		for k, e := range b.store {
 			if !e.Expired() {
-				snapshot = append(snapshot, pair{k, e.Value})
+				snapshot = append(snapshot, pair{decodeKey(k), e.Value})
 			}
 		}
 		b.mu.RUnlock()
@@ -412,9 +1117,13 @@ func (m *Memory) Range(ctx context.Context, fn func(k interface{}, v interface{}
 }
 
 // Clear removes all entries from the cache.
+// Each removed entry is reported to ExpireEventHandler with ReasonCleared.
 // Safe to call on uninitialized cache (no-op).
 func (m *Memory) Clear(ctx context.Context) error {
-	if m.buckets[0] == nil {
+	if atomic.LoadInt32(&m.closed) != 0 {
+		return ErrClosed
+	}
+	if !m.initialized() {
 		return nil
 	}
 	bcap := m.bucketCap
@@ -423,9 +1132,99 @@ func (m *Memory) Clear(ctx context.Context) error {
 	}
 	for _, b := range m.buckets {
 		b.mu.Lock()
+		if b.arena != nil {
+			if m.eventHandler != nil {
+				b.arenaEach(func(e *Entry) { b.emit(nil, e, ReasonCleared) })
+			}
+			b.arena.reset()
+			b.unlock()
+			continue
+		}
+		if m.wheel != nil || m.eventHandler != nil {
+			for _, e := range b.store {
+				if m.wheel != nil {
+					m.wheel.unschedule(e)
+				}
+				b.emit(nil, e, ReasonCleared)
+			}
+		}
 		// Replace map to release old entries for GC
 		b.store = make(map[string]*Entry, bcap)
-		b.mu.Unlock()
+		b.dirty = true
+		if b.policy != nil {
+			b.policy.reset()
+		}
+		b.bytes = 0
+		b.unlock()
 	}
 	return nil
 }
+
+// PreservesValues reports true: Memory returns stored values as-is. See ValuePreserver.
+func (m *Memory) PreservesValues() bool {
+	return true
+}
+
+// MemoryStats is a point-in-time summary of Memory usage.
+type MemoryStats struct {
+	Entries   int    // Number of stored entries (including expired ones not yet swept)
+	Bytes     int64  // Approximate size of all entries, see Sizer
+	MaxBytes  int64  // Configured byte budget, 0 = unbounded
+	Evictions uint64 // Entries evicted so far to honour maxEntries / maxBytes
+	Dropped   uint64 // Expire callbacks dropped by the dispatcher, see OverflowDrop
+
+	Shards          int     // Number of buckets
+	MinShardEntries int     // Entries in the emptiest bucket
+	MaxShardEntries int     // Entries in the fullest bucket
+	ShardSkew       float64 // MaxShardEntries / mean entries per bucket; 1 = perfectly even, 0 = empty
+}
+
+// Stats returns current usage, summed over all buckets.
+// Each bucket is read under its own lock, so the totals are not an atomic snapshot.
+// Safe to call on uninitialized cache (returns zero usage).
+func (m *Memory) Stats() MemoryStats {
+	st := MemoryStats{MaxBytes: m.maxBytes}
+	if m.storage == StorageArena {
+		st.MaxBytes = m.arenaBytes()
+	}
+	if !m.initialized() {
+		return st
+	}
+	if m.dispatch != nil {
+		st.Dropped = m.dispatch.Dropped()
+	}
+	st.Shards = len(m.buckets)
+	for i, b := range m.buckets {
+		b.mu.RLock()
+		n := b.len()
+		st.Entries += n
+		st.Bytes += b.size()
+		st.Evictions += b.evictions
+		b.mu.RUnlock()
+		if i == 0 || n < st.MinShardEntries {
+			st.MinShardEntries = n
+		}
+		if n > st.MaxShardEntries {
+			st.MaxShardEntries = n
+		}
+	}
+	if st.Entries > 0 {
+		st.ShardSkew = float64(st.MaxShardEntries) * float64(st.Shards) / float64(st.Entries)
+	}
+	return st
+}
+
+// ShardOccupancy returns the number of entries in each bucket, for checking how
+// evenly keys are distributed. Returns nil on an uninitialized cache.
+func (m *Memory) ShardOccupancy() []int {
+	if !m.initialized() {
+		return nil
+	}
+	out := make([]int, len(m.buckets))
+	for i, b := range m.buckets {
+		b.mu.RLock()
+		out[i] = b.len()
+		b.mu.RUnlock()
+	}
+	return out
+}
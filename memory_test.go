@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -34,7 +36,7 @@ func TestEntryNeverExpire(t *testing.T) {
 }
 
 func TestEntryExpired(t *testing.T) {
-	e := &Entry{ExpiredAt: now() - 10, Value: "x"}
+	e := &Entry{ExpiredAt: now() - int64(10*time.Second), Value: "x"}
 	if !e.Expired() {
 		t.Fatal("past entry should be expired")
 	}
@@ -44,7 +46,7 @@ func TestEntryExpired(t *testing.T) {
 }
 
 func TestEntryExpire(t *testing.T) {
-	e := &Entry{ExpiredAt: now() + 100, Value: "x"}
+	e := &Entry{ExpiredAt: now() + int64(100*time.Second), Value: "x"}
 	// set to never expire
 	e.Expire(-1)
 	if e.ExpiredAt != -1 {
@@ -113,11 +115,12 @@ func TestPutExAndGetAndTTL(t *testing.T) {
 }
 
 func TestExpiration(t *testing.T) {
-	c := newCache()
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk))
 	ctx := context.Background()
 
 	c.PutEx(ctx, "k3", "temp", 1)
-	time.Sleep(1500 * time.Millisecond)
+	clk.Advance(1500 * time.Millisecond)
 
 	_, err := c.Get(ctx, "k3")
 	if err != ErrNoKey {
@@ -359,19 +362,19 @@ func TestLazyInit(t *testing.T) {
 	c := newCache().(*Memory)
 	ctx := context.Background()
 
-	if c.buckets[0] != nil {
+	if c.initialized() {
 		t.Fatal("expected buckets nil before first write")
 	}
 
 	// Get should not trigger init
 	c.Get(ctx, "lazy")
-	if c.buckets[0] != nil {
+	if c.initialized() {
 		t.Fatal("expected buckets still nil after Get")
 	}
 
 	// Put triggers init
 	c.Put(ctx, "lazy", "v")
-	if c.buckets[0] == nil {
+	if !c.initialized() {
 		t.Fatal("expected buckets initialized after Put")
 	}
 }
@@ -427,6 +430,99 @@ func TestConcurrent(t *testing.T) {
 	}
 }
 
+func TestLockFreeReads(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []*Memory{
+		NewMemory(WithLockFreeReads()).(*Memory),
+		NewMemory(`{"lockFreeReads": true, "shards": 4}`).(*Memory),
+	} {
+		c.Put(ctx, "a", 1)
+		if !c.buckets[0].cow {
+			t.Fatal("expected copy-on-write buckets")
+		}
+		if v, err := c.Get(ctx, "a"); err != nil || v != 1 {
+			t.Fatalf("expected 1, got %v, %v", v, err)
+		}
+		c.PutEx(ctx, "a", 2, 60)
+		if v, ttl, err := c.GetAndTTL(ctx, "a"); err != nil || v != 2 || ttl != 60 {
+			t.Fatalf("expected 2 with ttl 60, got %v, %d, %v", v, ttl, err)
+		}
+		c.Del(ctx, "a")
+		if _, err := c.TTL(ctx, "a"); err != ErrNoKey {
+			t.Fatalf("expected ErrNoKey after Del, got %v", err)
+		}
+		c.Put(ctx, "b", 3)
+		c.Clear(ctx)
+		if _, err := c.Get(ctx, "b"); err != ErrNoKey {
+			t.Fatalf("expected ErrNoKey after Clear, got %v", err)
+		}
+	}
+
+	// Bounded caches keep locked reads to maintain the eviction order.
+	c := NewMemory(`{"maxEntries": 100}`, WithLockFreeReads()).(*Memory)
+	c.Put(ctx, "a", 1)
+	if c.buckets[0].cow {
+		t.Fatal("expected locked reads for a bounded cache")
+	}
+}
+
+func TestLockFreeReadsConcurrent(t *testing.T) {
+	c := NewMemory(WithLockFreeReads(), WithShards(4))
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func(n int) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(j % 50)
+				if n%2 == 0 {
+					c.Put(ctx, key, j)
+				} else if v, err := c.Get(ctx, key); err == nil {
+					if _, ok := v.(int); !ok {
+						t.Errorf("unexpected value %v", v)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+func TestLockFreeReadsInPlaceUpdates(t *testing.T) {
+	c := NewMemory(WithLockFreeReads()).(*Memory)
+	ctx := context.Background()
+	c.Put(ctx, "a", 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for j := 0; j < 1000; j++ {
+			c.ExpireIn(ctx, "a", time.Minute)
+			c.Tx(ctx, "a", func(e *Entry) error {
+				e.Value = j
+				return nil
+			})
+		}
+	}()
+	for j := 0; j < 1000; j++ {
+		c.GetAndTTL(ctx, "a")
+	}
+	<-done
+
+	// Updates made in place are published to lock-free readers.
+	c.ExpireIn(ctx, "a", 30*time.Second)
+	if ttl, err := c.TTLDuration(ctx, "a"); err != nil || ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("expected the new TTL to be visible, got %v, %v", ttl, err)
+	}
+	if v, _ := c.Get(ctx, "a"); v != 999 {
+		t.Fatalf("expected 999, got %v", v)
+	}
+}
+
 // ==================== Range ====================
 
 func TestRangeBasic(t *testing.T) {
@@ -568,3 +664,480 @@ func TestRangeCallbackCanWrite(t *testing.T) {
 	}
 	// "c" may or may not exist depending on bucket iteration order — no assertion needed.
 }
+
+// ==================== Eviction ====================
+
+// sameBucketKeys returns n distinct string keys that hash into the same bucket
+// with the default hasher and shard count.
+func sameBucketKeys(n int) []string {
+	shard := func(k string) uint64 { return XXHash(encodeKey(k)) & (defaultShards - 1) }
+	target := shard("evict_0")
+	out := []string{"evict_0"}
+	for i := 1; len(out) < n; i++ {
+		k := "evict_" + strconv.Itoa(i)
+		if shard(k) == target {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func TestMaxEntriesEvictsLRU(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 512}`) // 2 entries per bucket
+	ctx := context.Background()
+	ks := sameBucketKeys(3)
+
+	c.Put(ctx, ks[0], 0)
+	c.Put(ctx, ks[1], 1)
+	// Touch ks[0] so that ks[1] becomes the least recently used.
+	if _, err := c.Get(ctx, ks[0]); err != nil {
+		t.Fatal("Get failed:", err)
+	}
+	c.Put(ctx, ks[2], 2)
+
+	if _, err := c.Get(ctx, ks[1]); err != ErrNoKey {
+		t.Fatalf("expected %s to be evicted, got %v", ks[1], err)
+	}
+	for _, k := range []string{ks[0], ks[2]} {
+		if _, err := c.Get(ctx, k); err != nil {
+			t.Fatalf("expected %s to survive, got %v", k, err)
+		}
+	}
+}
+
+func TestMaxEntriesOverwriteDoesNotEvict(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 512}`)
+	ctx := context.Background()
+	ks := sameBucketKeys(2)
+
+	c.Put(ctx, ks[0], 0)
+	c.Put(ctx, ks[1], 1)
+	c.Put(ctx, ks[0], 10)
+	c.Put(ctx, ks[1], 11)
+
+	for i, k := range ks {
+		v, err := c.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", k, err)
+		}
+		if v != 10+i {
+			t.Fatalf("expected %d, got %v", 10+i, v)
+		}
+	}
+}
+
+func TestMaxEntriesEvictionNotifiesHandler(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 256}`) // 1 entry per bucket
+	ctx := context.Background()
+	ks := sameBucketKeys(2)
+
+	done := make(chan interface{}, 1)
+	c.ExpireHandler(func(k interface{}, v interface{}) {
+		done <- k
+	})
+
+	c.Put(ctx, ks[0], "first")
+	c.Put(ctx, ks[1], "second")
+
+	select {
+	case k := <-done:
+		if k != ks[0] {
+			t.Fatalf("expected %s evicted, got %v", ks[0], k)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expireHandler not called on eviction")
+	}
+}
+
+func TestMaxEntriesBounded(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 1000}`)
+	ctx := context.Background()
+
+	for i := 0; i < 10000; i++ {
+		c.Put(ctx, i, i)
+	}
+	n := 0
+	c.Range(ctx, func(k interface{}, v interface{}) error {
+		n++
+		return nil
+	})
+	// Per-bucket limit is ceil(1000/256) = 4.
+	if n > 4*256 {
+		t.Fatalf("expected at most %d entries, got %d", 4*256, n)
+	}
+}
+
+// ==================== Size Accounting ====================
+
+func TestStatsBytes(t *testing.T) {
+	c := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	if st := c.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Fatalf("expected empty stats before init, got %+v", st)
+	}
+
+	c.Put(ctx, "ab", "hello")          // 2 + 5
+	c.Put(ctx, "cd", []byte("world!")) // 2 + 6
+	if st := c.Stats(); st.Entries != 2 || st.Bytes != 15 {
+		t.Fatalf("expected 2 entries / 15 bytes, got %+v", st)
+	}
+
+	c.Put(ctx, "ab", "hi") // overwrite: 2 + 2
+	if st := c.Stats(); st.Bytes != 12 {
+		t.Fatalf("expected 12 bytes after overwrite, got %+v", st)
+	}
+
+	c.Tx(ctx, "cd", func(e *Entry) error {
+		e.Value = "w"
+		return nil
+	})
+	if st := c.Stats(); st.Bytes != 7 {
+		t.Fatalf("expected 7 bytes after Tx, got %+v", st)
+	}
+
+	c.Del(ctx, "ab")
+	if st := c.Stats(); st.Entries != 1 || st.Bytes != 3 {
+		t.Fatalf("expected 1 entry / 3 bytes after Del, got %+v", st)
+	}
+
+	c.Clear(ctx)
+	if st := c.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Fatalf("expected empty stats after Clear, got %+v", st)
+	}
+}
+
+func TestWithSizer(t *testing.T) {
+	c := NewMemory(WithSizer(func(v interface{}) int64 { return 100 })).(*Memory)
+	ctx := context.Background()
+
+	c.Put(ctx, "k", struct{ A, B int }{1, 2})
+	c.Put(ctx, "s", "str") // strings never go through the sizer
+	if st := c.Stats(); st.Bytes != 1+100+1+3 {
+		t.Fatalf("expected 105 bytes, got %+v", st)
+	}
+}
+
+func TestMaxBytesEvictsLRU(t *testing.T) {
+	// 256 * 20 bytes: each bucket holds 20 bytes.
+	c := NewMemory(`{"maxBytes": 5120}`).(*Memory)
+	ctx := context.Background()
+	ks := sameBucketKeys(3) // keys are 7-8 bytes long
+
+	c.Put(ctx, ks[0], "12")
+	c.Put(ctx, ks[1], "34")
+	c.Get(ctx, ks[0])
+	c.Put(ctx, ks[2], "56")
+
+	if _, err := c.Get(ctx, ks[1]); err != ErrNoKey {
+		t.Fatalf("expected %s to be evicted, got %v", ks[1], err)
+	}
+	if st := c.Stats(); st.Evictions != 1 {
+		t.Fatalf("expected 1 eviction, got %+v", st)
+	}
+}
+
+func TestMaxBytesOversizedEntryKept(t *testing.T) {
+	c := NewMemory(`{"maxBytes": 2560}`).(*Memory) // 10 bytes per bucket
+	ctx := context.Background()
+	ks := sameBucketKeys(2)
+
+	c.Put(ctx, ks[0], "a")
+	c.Put(ctx, ks[1], "this value is larger than the bucket budget")
+
+	if _, err := c.Get(ctx, ks[1]); err != nil {
+		t.Fatalf("expected oversized entry to be stored, got %v", err)
+	}
+	if _, err := c.Get(ctx, ks[0]); err != ErrNoKey {
+		t.Fatalf("expected %s to be evicted, got %v", ks[0], err)
+	}
+}
+
+// ==================== Close ====================
+
+func TestCloseRejectsWrites(t *testing.T) {
+	c := newCache()
+	ctx := context.Background()
+
+	c.Put(ctx, "k", "v")
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if err := c.Put(ctx, "k2", "v"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Put, got %v", err)
+	}
+	if err := c.Del(ctx, "k"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Del, got %v", err)
+	}
+	if err := c.Expire(ctx, "k", 10); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Expire, got %v", err)
+	}
+	if err := c.Tx(ctx, "k", func(e *Entry) error { return nil }); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Tx, got %v", err)
+	}
+	if err := c.Clear(ctx); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Clear, got %v", err)
+	}
+	// reads keep working
+	if v, err := c.Get(ctx, "k"); err != nil || v != "v" {
+		t.Fatalf("expected v after Close, got %v, %v", v, err)
+	}
+	// closing twice is a no-op
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("second Close failed:", err)
+	}
+}
+
+func TestCloseBeforeFirstWrite(t *testing.T) {
+	c := NewMemory().(*Memory)
+	ctx := context.Background()
+
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if err := c.Put(ctx, "k", "v"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if c.initialized() {
+		t.Fatal("expected buckets to stay uninitialized after Close")
+	}
+}
+
+func TestCloseStopsSweeper(t *testing.T) {
+	c := newCache().(*Memory)
+	ctx := context.Background()
+
+	c.Put(ctx, "k", "v")
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	select {
+	case <-c.loopDone:
+	default:
+		t.Fatal("expected expireInLoop to have exited")
+	}
+}
+
+func TestCloseFlushesCallbacks(t *testing.T) {
+	c := newCache()
+	ctx := context.Background()
+
+	var called int32
+	c.ExpireHandler(func(k interface{}, v interface{}) {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&called, 1)
+	})
+	c.Put(ctx, "a", 1)
+	c.Put(ctx, "b", 2)
+	c.Del(ctx, "a")
+	c.Del(ctx, "b")
+
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if n := atomic.LoadInt32(&called); n != 2 {
+		t.Fatalf("expected 2 callbacks flushed by Close, got %d", n)
+	}
+}
+
+func TestCloseContextDeadline(t *testing.T) {
+	c := newCache()
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	defer close(release)
+	c.ExpireHandler(func(k interface{}, v interface{}) { <-release })
+	c.Put(ctx, "a", 1)
+	c.Del(ctx, "a")
+
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := c.Close(cctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+// ==================== Duration TTL ====================
+
+func TestPutTTLSubSecond(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk))
+	ctx := context.Background()
+
+	if err := c.PutTTL(ctx, "ms_k", "v", 50*time.Millisecond); err != nil {
+		t.Fatal("PutTTL failed:", err)
+	}
+	ttl, err := c.TTLDuration(ctx, "ms_k")
+	if err != nil {
+		t.Fatal("TTLDuration failed:", err)
+	}
+	if ttl != 50*time.Millisecond {
+		t.Fatalf("expected ttl 50ms, got %v", ttl)
+	}
+	// second-based views round up so a live key never reports 0
+	if sec, _ := c.TTL(ctx, "ms_k"); sec != 1 {
+		t.Fatalf("expected TTL 1s, got %d", sec)
+	}
+	if _, sec, _ := c.GetAndTTL(ctx, "ms_k"); sec != 1 {
+		t.Fatalf("expected GetAndTTL 1s, got %d", sec)
+	}
+
+	clk.Advance(49 * time.Millisecond)
+	if ttl, _ := c.TTLDuration(ctx, "ms_k"); ttl != time.Millisecond {
+		t.Fatalf("expected ttl 1ms, got %v", ttl)
+	}
+	clk.Advance(time.Millisecond)
+	if _, err := c.Get(ctx, "ms_k"); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey after expiry, got %v", err)
+	}
+	if _, err := c.TTLDuration(ctx, "ms_k"); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey from TTLDuration, got %v", err)
+	}
+}
+
+func TestExpireIn(t *testing.T) {
+	c := newCache()
+	ctx := context.Background()
+
+	c.Put(ctx, "ei_k", "v")
+	if ttl, _ := c.TTLDuration(ctx, "ei_k"); ttl != NoExpiration {
+		t.Fatalf("expected NoExpiration, got %v", ttl)
+	}
+
+	if err := c.ExpireIn(ctx, "ei_k", 1500*time.Millisecond); err != nil {
+		t.Fatal("ExpireIn failed:", err)
+	}
+	ttl, _ := c.TTLDuration(ctx, "ei_k")
+	if ttl <= time.Second || ttl > 1500*time.Millisecond {
+		t.Fatalf("expected ttl in (1s,1.5s], got %v", ttl)
+	}
+	if sec, _ := c.TTL(ctx, "ei_k"); sec != 2 {
+		t.Fatalf("expected TTL rounded up to 2s, got %d", sec)
+	}
+
+	if err := c.ExpireIn(ctx, "ei_k", NoExpiration); err != nil {
+		t.Fatal("ExpireIn failed:", err)
+	}
+	if ttl, _ := c.TTLDuration(ctx, "ei_k"); ttl != NoExpiration {
+		t.Fatalf("expected NoExpiration, got %v", ttl)
+	}
+
+	if err := c.ExpireIn(ctx, "not_exist", time.Second); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	cases := []struct {
+		in   time.Duration
+		want int64
+	}{
+		{NoExpiration, -1},
+		{0, 0},
+		{time.Nanosecond, 1},
+		{time.Second, 1},
+		{time.Second + 1, 2},
+		{90 * time.Second, 90},
+	}
+	for _, c := range cases {
+		if got := TTLSeconds(c.in); got != c.want {
+			t.Errorf("TTLSeconds(%v) = %d, want %d", c.in, got, c.want)
+		}
+	}
+	if SecondsToTTL(-5) != NoExpiration || SecondsToTTL(3) != 3*time.Second {
+		t.Fatal("unexpected SecondsToTTL conversion")
+	}
+}
+
+// ==================== Expire Events ====================
+
+// collectEvents registers an ExpireEventHandler on c and returns a function
+// that waits for the next n events, keyed by their key.
+func collectEvents(t *testing.T, c Cache) func(n int) map[interface{}]ExpireEvent {
+	ch := make(chan ExpireEvent, 100)
+	c.ExpireEventHandler(func(ev ExpireEvent) { ch <- ev })
+	return func(n int) map[interface{}]ExpireEvent {
+		got := make(map[interface{}]ExpireEvent, n)
+		for i := 0; i < n; i++ {
+			select {
+			case ev := <-ch:
+				got[ev.Key] = ev
+			case <-time.After(time.Second):
+				t.Fatalf("expected %d events, got %d", n, len(got))
+			}
+		}
+		return got
+	}
+}
+
+func TestExpireEventReasons(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk), `{"maxEntries": 1}`)
+	ctx := context.Background()
+	defer c.Close(ctx)
+	next := collectEvents(t, c)
+
+	c.PutTTL(ctx, "k", "v1", time.Minute)
+	created := clk.Now().UnixNano()
+	clk.Advance(time.Second)
+	c.Put(ctx, "k", "v2")
+	ev := next(1)["k"]
+	if ev.Reason != ReasonReplaced || ev.Value != "v1" {
+		t.Fatalf("expected v1 replaced, got %+v", ev)
+	}
+	if ev.CreatedAt != created || ev.ExpiredAt != created+int64(time.Minute) {
+		t.Fatalf("unexpected timestamps: %+v", ev)
+	}
+
+	c.Del(ctx, "k")
+	if ev := next(1)["k"]; ev.Reason != ReasonDeleted || ev.Value != "v2" || ev.ExpiredAt != -1 {
+		t.Fatalf("expected v2 deleted, got %+v", ev)
+	}
+
+	c.PutTTL(ctx, "short", 3, time.Second)
+	clk.Advance(2 * time.Second)
+	_, b := c.(*Memory).bucketFor("short")
+	b.cleanup()
+	if ev := next(1)["short"]; ev.Reason != ReasonExpired {
+		t.Fatalf("expected short expired, got %+v", ev)
+	}
+
+	// maxEntries 1 is split across buckets: force an eviction within one bucket.
+	ks := sameBucketKeys(2)
+	c.Put(ctx, ks[0], 1)
+	c.Put(ctx, ks[1], 2)
+	if ev := next(1)[ks[0]]; ev.Reason != ReasonEvicted {
+		t.Fatalf("expected %s evicted, got %+v", ks[0], ev)
+	}
+
+	c.Clear(ctx)
+	if ev := next(1)[ks[1]]; ev.Reason != ReasonCleared || ev.Value != 2 {
+		t.Fatalf("expected %s cleared, got %+v", ks[1], ev)
+	}
+}
+
+func TestExpireHandlerIgnoresReplacedAndCleared(t *testing.T) {
+	c := NewMemory()
+	ctx := context.Background()
+
+	var calls int32
+	c.ExpireHandler(func(k interface{}, v interface{}) { atomic.AddInt32(&calls, 1) })
+	next := collectEvents(t, c)
+
+	c.Put(ctx, "a", 1)
+	c.Put(ctx, "a", 2)
+	c.Clear(ctx)
+	next(2)
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("expected no ExpireHandler calls, got %d", n)
+	}
+}
+
+func TestExpireReasonString(t *testing.T) {
+	if ReasonEvicted.String() != "evicted" || ExpireReason(0).String() != "unknown" {
+		t.Fatal("unexpected ExpireReason names")
+	}
+}
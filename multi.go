@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"context"
+	"sort"
+)
+
+// KeyValue is one entry written by PutMultiEx.
+type KeyValue struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// MultiResult is the result of GetMulti for one key.
+type MultiResult struct {
+	Value interface{}
+	Found bool // False if the key does not exist or has expired
+}
+
+// MultiCache is implemented by backends that read and write many keys in one
+// operation, such as Memory (one lock per bucket) and mysql.MysqlCache (one
+// statement per batch). Use the GetMulti, PutMultiEx and DelMulti functions,
+// which fall back to per-key calls for other backends.
+type MultiCache interface {
+	// GetMulti returns one result per key, in the order of keys.
+	GetMulti(ctx context.Context, keys []interface{}) ([]MultiResult, error)
+
+	// PutMultiEx stores all kvs with a TTL (in seconds). If sec is negative, the entries never expire.
+	// If a key appears more than once, the last value wins.
+	PutMultiEx(ctx context.Context, kvs []KeyValue, sec int64) error
+
+	// DelMulti removes the keys and returns how many existed. Missing keys are not an error.
+	DelMulti(ctx context.Context, keys []interface{}) (int, error)
+}
+
+// GetMulti reads keys from c in one operation if c implements MultiCache,
+// otherwise with one Get per key.
+func GetMulti(ctx context.Context, c Cache, keys []interface{}) ([]MultiResult, error) {
+	if mc, ok := c.(MultiCache); ok {
+		return mc.GetMulti(ctx, keys)
+	}
+	res := make([]MultiResult, len(keys))
+	for i, k := range keys {
+		v, err := c.Get(ctx, k)
+		if err == ErrNoKey {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[i] = MultiResult{Value: v, Found: true}
+	}
+	return res, nil
+}
+
+// PutMultiEx writes kvs to c in one operation if c implements MultiCache or
+// BatchWriter, otherwise with one PutEx per entry.
+func PutMultiEx(ctx context.Context, c Cache, kvs []KeyValue, sec int64) error {
+	if mc, ok := c.(MultiCache); ok {
+		return mc.PutMultiEx(ctx, kvs, sec)
+	}
+	if bw, ok := c.(BatchWriter); ok {
+		items := make([]BatchItem, len(kvs))
+		for i, kv := range kvs {
+			items[i] = BatchItem{Key: kv.Key, Value: kv.Value, TTL: SecondsToTTL(sec)}
+		}
+		return bw.PutBatch(ctx, items)
+	}
+	for _, kv := range kvs {
+		if err := c.PutEx(ctx, kv.Key, kv.Value, sec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DelMulti removes keys from c in one operation if c implements MultiCache,
+// otherwise with one Del per key. It returns how many keys existed.
+func DelMulti(ctx context.Context, c Cache, keys []interface{}) (int, error) {
+	if mc, ok := c.(MultiCache); ok {
+		return mc.DelMulti(ctx, keys)
+	}
+	n := 0
+	for _, k := range keys {
+		err := c.Del(ctx, k)
+		if err == ErrNoKey {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+var _ MultiCache = (*Memory)(nil)
+
+// keyRef is a key of a multi-key operation, located in its bucket.
+type keyRef struct {
+	i     int    // Position in the caller's slice
+	key   string // Store key
+	shard uint64 // Bucket index
+}
+
+// groupKeys locates keys and orders them by bucket, keeping the caller's order
+// within a bucket. fn is called once per bucket with its keys.
+func (m *Memory) groupKeys(keys []interface{}, fn func(b *bucket, refs []keyRef)) {
+	refs := make([]keyRef, len(keys))
+	for i, k := range keys {
+		s := encodeKey(k)
+		refs[i] = keyRef{i: i, key: s, shard: m.hasher(s) & m.mask}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].shard < refs[j].shard })
+	for start := 0; start < len(refs); {
+		end := start + 1
+		for end < len(refs) && refs[end].shard == refs[start].shard {
+			end++
+		}
+		fn(m.buckets[refs[start].shard], refs[start:end])
+		start = end
+	}
+}
+
+// GetMulti returns the values of keys, taking each bucket lock once.
+func (m *Memory) GetMulti(ctx context.Context, keys []interface{}) ([]MultiResult, error) {
+	res := make([]MultiResult, len(keys))
+	if !m.initialized() {
+		return res, nil
+	}
+	m.groupKeys(keys, func(b *bucket, refs []keyRef) {
+		found := func(r keyRef, e *Entry, ok bool) {
+			if ok && e != nil && !e.Expired() {
+				res[r.i] = MultiResult{Value: e.Value, Found: true}
+			}
+		}
+		switch {
+		case b.bounded():
+			b.mu.Lock()
+			for _, r := range refs {
+				e, ok := b.store[r.key]
+				if ok && e != nil {
+					b.policy.access(e)
+				}
+				found(r, e, ok)
+			}
+			b.mu.Unlock()
+		case b.cow:
+			snap := b.snap.Load().(map[string]*Entry)
+			for _, r := range refs {
+				e, ok := snap[r.key]
+				found(r, e, ok)
+			}
+		default: // Heap or arena
+			b.mu.RLock()
+			for _, r := range refs {
+				e, ok := b.get(r.key)
+				found(r, e, ok)
+			}
+			b.mu.RUnlock()
+		}
+	})
+	return res, nil
+}
+
+// PutMultiEx stores kvs, taking each bucket lock once. Values are resolved and,
+// in arena mode, checked before anything is written, so a failing value leaves
+// the cache unchanged.
+func (m *Memory) PutMultiEx(ctx context.Context, kvs []KeyValue, sec int64) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+	keys := make([]interface{}, len(kvs))
+	vals := make([]interface{}, len(kvs))
+	for i, kv := range kvs {
+		keys[i], vals[i] = kv.Key, kv.Value
+		if vv, ok := kv.Value.(Valuer); ok {
+			var err error
+			if vals[i], err = vv.Value(); err != nil {
+				return err
+			}
+		}
+	}
+	if m.storage == StorageArena {
+		for i, k := range keys {
+			key, b := m.bucketFor(k)
+			if err := b.checkArenaValue(key, vals[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	nowTime := m.now()
+	expiredAt := ExpiredAt(nowTime, SecondsToTTL(sec))
+	m.groupKeys(keys, func(b *bucket, refs []keyRef) {
+		b.mu.Lock()
+		for _, r := range refs {
+			b.set(r.key, &Entry{CreatedAt: nowTime, ExpiredAt: expiredAt, Value: vals[r.i], clock: m.clock})
+		}
+		b.unlock()
+	})
+	return nil
+}
+
+// DelMulti removes keys, taking each bucket lock once, and returns how many existed.
+func (m *Memory) DelMulti(ctx context.Context, keys []interface{}) (int, error) {
+	if err := m.ensureStarted(); err != nil {
+		return 0, err
+	}
+	n := 0
+	m.groupKeys(keys, func(b *bucket, refs []keyRef) {
+		b.mu.Lock()
+		for _, r := range refs {
+			if e, ok := b.get(r.key); ok && e != nil {
+				b.remove(e)
+				b.emit(keys[r.i], e, ReasonDeleted)
+				n++
+			}
+		}
+		b.unlock()
+	})
+	return n, nil
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testMulti(t *testing.T, c Cache) {
+	t.Helper()
+	ctx := context.Background()
+	kvs := make([]KeyValue, 100)
+	for i := range kvs {
+		kvs[i] = KeyValue{Key: i, Value: "v"}
+	}
+	kvs = append(kvs, KeyValue{Key: 0, Value: "last"})
+	if err := PutMultiEx(ctx, c, kvs, 60); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := c.TTL(ctx, 50); ttl != 60 {
+		t.Fatalf("expected ttl 60, got %d", ttl)
+	}
+
+	res, err := GetMulti(ctx, c, []interface{}{0, "missing", 99, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 4 || res[0] != (MultiResult{Value: "last", Found: true}) || res[1].Found ||
+		res[2] != (MultiResult{Value: "v", Found: true}) || !res[3].Found {
+		t.Fatalf("unexpected results %+v", res)
+	}
+
+	keys := make([]interface{}, 0, 60)
+	for i := 0; i < 50; i++ {
+		keys = append(keys, i)
+	}
+	keys = append(keys, "missing")
+	if n, err := DelMulti(ctx, c, keys); err != nil || n != 50 {
+		t.Fatalf("expected 50 deleted, got %d, %v", n, err)
+	}
+	if res, _ := GetMulti(ctx, c, []interface{}{0, 50}); res[0].Found || !res[1].Found {
+		t.Fatalf("expected only the deleted keys to be gone, got %+v", res)
+	}
+}
+
+func TestMemoryMulti(t *testing.T) {
+	for name, c := range map[string]Cache{
+		"heap":     NewMemory(),
+		"bounded":  NewMemory(`{"maxEntries": 1000}`),
+		"lockfree": NewMemory(WithLockFreeReads()),
+		"arena":    NewMemory(WithArena(1 << 20)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer c.Close(context.Background())
+			testMulti(t, c)
+		})
+	}
+}
+
+func TestMultiFallback(t *testing.T) {
+	c := NewTiered(NewMemory(), NewMemory())
+	defer c.Close(context.Background())
+	testMulti(t, c)
+}
+
+func TestMemoryMultiExpiredAndEvents(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c := NewMemory(WithClock(clk)).(*Memory)
+	events := collectEvents(t, c)
+
+	if res, _ := c.GetMulti(ctx, []interface{}{"a"}); res[0].Found {
+		t.Fatal("expected a miss before the first write")
+	}
+	c.PutMultiEx(ctx, []KeyValue{{"a", 1}, {"b", 2}}, 1)
+	clk.Advance(2 * time.Second)
+	if res, _ := c.GetMulti(ctx, []interface{}{"a", "b"}); res[0].Found || res[1].Found {
+		t.Fatalf("expected expired entries to be misses, got %+v", res)
+	}
+
+	c.PutMultiEx(ctx, []KeyValue{{"a", 1}}, -1)
+	events(1) // "a" expired and was replaced
+	c.DelMulti(ctx, []interface{}{"a"})
+	if ev := events(1)["a"]; ev.Reason != ReasonDeleted || ev.Value != 1 {
+		t.Fatalf("expected a deleted event for a, got %+v", ev)
+	}
+
+	arena := NewMemory(WithArena(1 << 20))
+	err := arena.(*Memory).PutMultiEx(ctx, []KeyValue{{"ok", "1"}, {"bad", 1}}, -1)
+	if err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+	if _, err := arena.Get(ctx, "ok"); err != ErrNoKey {
+		t.Fatal("expected a rejected batch to write nothing")
+	}
+}
@@ -0,0 +1,168 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.ConditionalCache = (*MysqlCache)(nil)
+
+// PutIfAbsent stores v with INSERT IGNORE unless a live row exists under k.
+// An expired row that was not swept yet is deleted first, and reported as
+// expired. Like any INSERT IGNORE, values too large for the v column are
+// truncated instead of failing.
+func (c *MysqlCache) PutIfAbsent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error) {
+	if c.isClosed() {
+		return false, cache.ErrClosed
+	}
+	key := keyToString(k)
+	b, err := sqlValue(v)
+	if err != nil {
+		return false, fmt.Errorf("mysql cache: resolve value: %w", err)
+	}
+	createdAt := c.now()
+	expiredAt := cache.ExpiredAt(createdAt, ttl)
+	if !c.hasHandler() {
+		if _, err := c.db.ExecContext(ctx, c.sql.delExpiredSQL, key, createdAt); err != nil {
+			return false, err
+		}
+		return execAffected(ctx, c.db, c.sql.insertIgnoreSQL, key, b, createdAt, expiredAt)
+	}
+
+	// Load the row in the same transaction to report the expired row it replaces.
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	old, err := c.getRow(ctx, tx, c.sql.getSQL+` FOR UPDATE`, key)
+	if err != nil && !errors.Is(err, cache.ErrNoKey) {
+		tx.Rollback()
+		return false, err
+	}
+	if old != nil {
+		if c.entryTTL(old.expiredAt) != 0 {
+			tx.Rollback()
+			return false, nil
+		}
+		if _, err = tx.ExecContext(ctx, c.sql.delSQL, key); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	stored, err := execAffected(ctx, tx, c.sql.insertIgnoreSQL, key, b, createdAt, expiredAt)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if old != nil {
+		c.notify(old.event(k, cache.ReasonExpired))
+	}
+	return stored, nil
+}
+
+// PutIfPresent updates the row under k if it has not expired.
+func (c *MysqlCache) PutIfPresent(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) (bool, error) {
+	return c.update(ctx, k, v, ttl, c.sql.updateLiveSQL)
+}
+
+// CompareAndSwap updates the row under k with UPDATE ... WHERE version=?.
+// Versions count the writes of a row, so a row that was deleted and created
+// again starts over at 1 and may match a version read before the delete.
+// Writes by binaries predating the version column do not change it.
+func (c *MysqlCache) CompareAndSwap(ctx context.Context, k interface{}, version uint64, v interface{}, ttl time.Duration) (bool, error) {
+	updated, err := c.update(ctx, k, v, ttl, c.sql.updateLiveSQL+` AND version=?`, version)
+	if err != nil || updated {
+		return updated, err
+	}
+	// Tell a missing key from a version mismatch.
+	if _, _, err := c.GetVersion(ctx, k); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// GetVersion returns the value of k, as []byte like Get, and its version.
+func (c *MysqlCache) GetVersion(ctx context.Context, k interface{}) (interface{}, uint64, error) {
+	var v []byte
+	var createdAt, expiredAt int64
+	var version uint64
+	err := c.db.QueryRowContext(ctx, c.sql.getVersionSQL, keyToString(k)).Scan(&v, &createdAt, &expiredAt, &version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, cache.ErrNoKey
+		}
+		return nil, 0, err
+	}
+	if c.entryTTL(expiredAt) == 0 {
+		return nil, 0, cache.ErrNoKey
+	}
+	return v, version, nil
+}
+
+// update runs query, a conditional UPDATE taking the arguments of updateLiveSQL
+// followed by extra, and reports whether it wrote the row. With ExpireEventHandler
+// set, the row is loaded in the same transaction to report its replacement.
+func (c *MysqlCache) update(ctx context.Context, k interface{}, v interface{}, ttl time.Duration, query string, extra ...interface{}) (bool, error) {
+	if c.isClosed() {
+		return false, cache.ErrClosed
+	}
+	key := keyToString(k)
+	b, err := sqlValue(v)
+	if err != nil {
+		return false, fmt.Errorf("mysql cache: resolve value: %w", err)
+	}
+	createdAt := c.now()
+	args := append([]interface{}{b, createdAt, cache.ExpiredAt(createdAt, ttl), key, createdAt}, extra...)
+	if c.eventHandler == nil {
+		return c.execValue(ctx, c.db, []interface{}{key}, query, args...)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	old, err := c.getRow(ctx, tx, c.sql.getSQL+` FOR UPDATE`, key)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, cache.ErrNoKey) {
+			return false, nil
+		}
+		return false, err
+	}
+	updated, err := c.execValue(ctx, tx, []interface{}{key}, query, args...)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if updated {
+		c.notify(old.event(k, cache.ReasonReplaced))
+	}
+	return updated, nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// execAffected runs query and reports whether it changed any row. Every write
+// changes the version, so updated rows are counted even if the value is the same.
+func execAffected(ctx context.Context, e execer, query string, args ...interface{}) (bool, error) {
+	rs, err := e.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := rs.RowsAffected()
+	return n > 0, err
+}
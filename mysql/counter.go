@@ -0,0 +1,146 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.CounterCache = (*MysqlCache)(nil)
+
+// IncrBy adds delta to the counter k, stored as a decimal string. A live counter
+// is updated in place with a single UPDATE, see incrSQL; other cases go through
+// incr.
+func (c *MysqlCache) IncrBy(ctx context.Context, k interface{}, delta int64, ttlIfCreate time.Duration) (int64, error) {
+	if c.isClosed() {
+		return 0, cache.ErrClosed
+	}
+	if n, ok, err := c.incrInPlace(ctx, keyToString(k), delta); err != nil || ok {
+		return n, err
+	}
+	var n int64
+	err := c.incr(ctx, k, ttlIfCreate, func(old []byte, found bool) ([]byte, error) {
+		n = delta
+		if found {
+			var err error
+			if n, err = cache.IncrValue(old, delta); err != nil {
+				return nil, err
+			}
+		}
+		return strconv.AppendInt(nil, n, 10), nil
+	})
+	return n, err
+}
+
+// incrInPlace adds delta to the live counter under key with incrSQL, and returns
+// the new value, reported as the insert ID of the statement. It reports false,
+// leaving the row alone, if key is missing, expired, not a value, not a decimal
+// of at most 18 digits, or would overflow.
+func (c *MysqlCache) incrInPlace(ctx context.Context, key string, delta int64) (int64, bool, error) {
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if delta >= 0 {
+		hi -= delta
+	} else {
+		lo -= delta
+	}
+	rs, err := c.db.ExecContext(ctx, c.sql.incrSQL, delta, key, c.now(), lo, hi)
+	if err != nil {
+		return 0, false, err
+	}
+	if n, err := rs.RowsAffected(); err != nil || n == 0 {
+		return 0, false, err
+	}
+	n, err := rs.LastInsertId()
+	return n, err == nil, err
+}
+
+// IncrByFloat adds delta to the counter k, stored as a decimal string. See incr.
+func (c *MysqlCache) IncrByFloat(ctx context.Context, k interface{}, delta float64, ttlIfCreate time.Duration) (float64, error) {
+	var f float64
+	err := c.incr(ctx, k, ttlIfCreate, func(old []byte, found bool) ([]byte, error) {
+		f = delta
+		if found {
+			var err error
+			if f, err = cache.IncrFloatValue(old, delta); err != nil {
+				return nil, err
+			}
+		} else if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, cache.ErrOverflow
+		}
+		return strconv.AppendFloat(nil, f, 'f', -1, 64), nil
+	})
+	return f, err
+}
+
+// incr stores next(old) under k. A missing key is created with INSERT IGNORE;
+// otherwise the row is loaded FOR UPDATE and rewritten in the same transaction.
+// The arithmetic is done here rather than in SQL, which would go through DOUBLE
+// for blob values and treat non-numeric ones as 0.
+func (c *MysqlCache) incr(ctx context.Context, k interface{}, ttlIfCreate time.Duration, next func(old []byte, found bool) ([]byte, error)) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	key := keyToString(k)
+	for {
+		v, err := next(nil, false)
+		if err != nil {
+			return err
+		}
+		now := c.now()
+		created, err := execAffected(ctx, c.db, c.sql.insertIgnoreSQL, key, v, now, cache.ExpiredAt(now, ttlIfCreate))
+		if err != nil || created {
+			return err
+		}
+		done, err := c.incrRow(ctx, k, key, ttlIfCreate, next)
+		if err != nil || done {
+			return err
+		}
+		// The row was deleted after the insert failed; create it again.
+	}
+}
+
+// incrRow rewrites the row under key with next(old). An expired row is replaced
+// as if missing, and reported as expired. Returns false if there is no row.
+func (c *MysqlCache) incrRow(ctx context.Context, k interface{}, key string, ttlIfCreate time.Duration, next func(old []byte, found bool) ([]byte, error)) (bool, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	old, err := c.getRow(ctx, tx, c.sql.getSQL+` FOR UPDATE`, key)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, cache.ErrNoKey) {
+			return false, nil
+		}
+		return false, err
+	}
+	createdAt, expiredAt := old.createdAt, old.expiredAt
+	live := c.entryTTL(expiredAt) != 0
+	var v []byte
+	if live {
+		v, err = next(old.v, true)
+	} else {
+		createdAt = c.now()
+		expiredAt = cache.ExpiredAt(createdAt, ttlIfCreate)
+		v, err = next(nil, false)
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err = c.execValue(ctx, tx, []interface{}{key}, c.sql.updateSQL, v, createdAt, expiredAt, key); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if !live {
+		c.notify(old.event(k, cache.ReasonExpired))
+	}
+	return true, nil
+}
@@ -0,0 +1,197 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.HashCache = (*MysqlCache)(nil)
+
+// A hash key is a row of the main table of kindHash, which carries the TTL of
+// the hash, and one row per field in <tableName>_hash. Deleting or expiring the
+// key deletes its fields through the foreign key. Get returns nil for hash keys,
+// and hash operations on keys written by Put return cache.ErrWrongType.
+
+// HGet returns the value of field as []byte, like Get.
+func (c *MysqlCache) HGet(ctx context.Context, k interface{}, field string) (interface{}, error) {
+	var kind int
+	var hasField bool
+	var expiredAt int64
+	var v []byte
+	err := c.db.QueryRowContext(ctx, c.sql.hGetSQL, field, keyToString(k)).Scan(&kind, &expiredAt, &hasField, &v)
+	if err := c.checkKind(err, kind, kindHash, expiredAt); err != nil {
+		return nil, err
+	}
+	if !hasField {
+		return nil, cache.ErrNoKey
+	}
+	return v, nil
+}
+
+// HSet upserts fields in one statement, creating the hash if needed.
+func (c *MysqlCache) HSet(ctx context.Context, k interface{}, fields map[string]interface{}) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names) // Stable lock order for concurrent writers
+	vals := make([]interface{}, len(names))
+	for i, f := range names {
+		var err error
+		if vals[i], err = sqlValue(fields[f]); err != nil {
+			return 0, fmt.Errorf("mysql cache: resolve value: %w", err)
+		}
+	}
+
+	added := 0
+	err := c.kindTx(ctx, k, kindHash, true, func(tx *sql.Tx, r *row, exists bool) error {
+		key := r.k
+		var sb strings.Builder
+		sb.WriteString(c.sql.hPutSQL)
+		args := make([]interface{}, 0, 3*len(names))
+		keys := make([]interface{}, 0, len(names)+1)
+		keys = append(keys, key)
+		for i, f := range names {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(" (?, ?, ?)")
+			args = append(args, key, f, vals[i])
+			keys = append(keys, f)
+		}
+		sb.WriteString(` ON DUPLICATE KEY UPDATE v=VALUES(v)`)
+
+		existing := 0
+		if exists {
+			q := c.sql.hCountSQL + ` AND field IN` + placeholders(len(names))
+			if err := tx.QueryRowContext(ctx, q, keys...).Scan(&existing); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+		added = len(names) - existing
+		return nil
+	})
+	return added, err
+}
+
+// HDel deletes fields, and the key with its last field.
+func (c *MysqlCache) HDel(ctx context.Context, k interface{}, fields ...string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	deleted := 0
+	var emptied *row
+	err := c.kindTx(ctx, k, kindHash, false, func(tx *sql.Tx, r *row, exists bool) error {
+		if !exists {
+			return nil
+		}
+		key := r.k
+		args := make([]interface{}, 0, len(fields)+1)
+		args = append(args, key)
+		for _, f := range fields {
+			args = append(args, f)
+		}
+		rs, err := tx.ExecContext(ctx, c.sql.hDelFieldsSQL+placeholders(len(fields)), args...)
+		if err != nil {
+			return err
+		}
+		n, _ := rs.RowsAffected()
+		deleted = int(n)
+		var left int
+		if err := tx.QueryRowContext(ctx, c.sql.hCountSQL, key).Scan(&left); err != nil {
+			return err
+		}
+		if left == 0 {
+			_, err = tx.ExecContext(ctx, c.sql.delSQL, key)
+			emptied = r
+		}
+		return err
+	})
+	if err == nil && emptied != nil {
+		c.notify(emptied.event(k, cache.ReasonDeleted))
+	}
+	return deleted, err
+}
+
+// HGetAll returns all fields, with values as []byte.
+func (c *MysqlCache) HGetAll(ctx context.Context, k interface{}) (map[string]interface{}, error) {
+	rows, err := c.db.QueryContext(ctx, c.sql.hGetAllSQL, keyToString(k))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var kind int
+	var expiredAt int64
+	fields := make(map[string]interface{})
+	found := false
+	for rows.Next() {
+		var field sql.NullString
+		var v []byte
+		if err := rows.Scan(&kind, &expiredAt, &field, &v); err != nil {
+			return nil, err
+		}
+		found = true
+		if field.Valid {
+			fields[field.String] = v
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, cache.ErrNoKey
+	}
+	if err := c.checkKind(nil, kind, kindHash, expiredAt); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// HIncrBy adds delta to field in a transaction, see IncrBy.
+func (c *MysqlCache) HIncrBy(ctx context.Context, k interface{}, field string, delta int64) (int64, error) {
+	n := delta
+	err := c.kindTx(ctx, k, kindHash, true, func(tx *sql.Tx, r *row, exists bool) error {
+		var old []byte
+		err := tx.QueryRowContext(ctx, c.sql.hFieldSQL, r.k, field).Scan(&old)
+		switch {
+		case err == nil:
+			if n, err = cache.IncrValue(old, delta); err != nil {
+				return err
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+		q := c.sql.hPutSQL + ` (?, ?, ?) ON DUPLICATE KEY UPDATE v=VALUES(v)`
+		_, err = tx.ExecContext(ctx, q, r.k, field, strconv.AppendInt(nil, n, 10))
+		return err
+	})
+	return n, err
+}
+
+func (c *MysqlCache) HLen(ctx context.Context, k interface{}) (int, error) {
+	key := keyToString(k)
+	var kind int
+	var expiredAt int64
+	var n int
+	err := c.db.QueryRowContext(ctx, c.sql.hLenSQL, key, key).Scan(&kind, &expiredAt, &n)
+	if err := c.checkKind(err, kind, kindHash, expiredAt); err != nil {
+		if err == cache.ErrNoKey {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-comm/cache"
+)
+
+// Values of the kind column. Keys of other kinds than kindValue keep a NULL v
+// in the main table, and their elements in a child table.
+const (
+	kindValue = 0
+	kindHash  = 1 // Fields in <tableName>_hash, see HSet
+	kindList  = 2 // Elements in <tableName>_list, see RPush
+	kindZSet  = 3 // Members in <tableName>_zset, see ZAdd
+)
+
+// checkKind turns the result of reading the main row of a key into cache.ErrNoKey
+// if it is missing or expired, or cache.ErrWrongType if it is not of kind want.
+func (c *MysqlCache) checkKind(err error, kind, want int, expiredAt int64) error {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cache.ErrNoKey
+		}
+		return err
+	}
+	if c.entryTTL(expiredAt) == 0 {
+		return cache.ErrNoKey
+	}
+	if kind != want {
+		return cache.ErrWrongType
+	}
+	return nil
+}
+
+// childSQL returns the statements deleting the child rows of kind left under
+// keys that became values, and deleting a key if it has no child rows.
+func (c *MysqlCache) childSQL(kind int) (orphansSQL, delEmptySQL string) {
+	switch kind {
+	case kindHash:
+		return c.sql.hOrphansSQL, c.sql.delEmptyHashSQL
+	case kindList:
+		return c.sql.lOrphansSQL, c.sql.delEmptyListSQL
+	case kindZSet:
+		return c.sql.zOrphansSQL, c.sql.delEmptyZSetSQL
+	}
+	return "", ""
+}
+
+// execValue runs query, a value write to the rows of keys (row keys, see
+// keyToString) resetting their kind with kindReset, and reports whether it
+// changed any row. If it replaced a hash, list or sorted set, the child rows left
+// under keys are then deleted through e; the foreign keys only delete them along
+// with the main row. A value write is thus a single statement unless it replaces
+// another kind.
+func (c *MysqlCache) execValue(ctx context.Context, e execer, keys []interface{}, query string, args ...interface{}) (bool, error) {
+	rs, err := e.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := rs.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	replaced, err := rs.LastInsertId()
+	if err != nil || replaced == kindValue {
+		return n > 0, err
+	}
+	kinds := []int{int(replaced)}
+	if len(keys) > 1 {
+		// Only the last kind replaced is reported.
+		kinds = []int{kindHash, kindList, kindZSet}
+	}
+	for _, kind := range kinds {
+		orphansSQL, _ := c.childSQL(kind)
+		if orphansSQL == "" {
+			continue
+		}
+		if _, err = e.ExecContext(ctx, orphansSQL+placeholders(len(keys)), keys...); err != nil {
+			return false, err
+		}
+	}
+	return n > 0, nil
+}
+
+// kindTx runs fn in a transaction holding the lock on r, the main row of k;
+// exists reports whether k is a live key of the given kind. If create is set, a
+// missing or expired key is first created empty with no expiration; an expired
+// row is deleted with its child rows and reported as expired.
+func (c *MysqlCache) kindTx(ctx context.Context, k interface{}, kind int, create bool, fn func(tx *sql.Tx, r *row, exists bool) error) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	key := keyToString(k)
+	if !create {
+		return c.kindTxLocked(ctx, k, key, kind, false, fn)
+	}
+	// Create the row before locking it: locking a missing row only takes a gap
+	// lock, and concurrent creators would deadlock on their inserts.
+	created, err := execAffected(ctx, c.db, c.sql.insertKindSQL, key, c.now(), kind)
+	if err != nil {
+		return err
+	}
+	if err = c.kindTxLocked(ctx, k, key, kind, true, fn); err != nil && created {
+		// Do not leave the key created above empty, unless someone else filled it
+		// meanwhile. ctx may be what failed, so the delete does not use it.
+		_, delEmptySQL := c.childSQL(kind)
+		c.db.ExecContext(context.Background(), delEmptySQL, key, key)
+	}
+	return err
+}
+
+func (c *MysqlCache) kindTxLocked(ctx context.Context, k interface{}, key string, kind int, create bool, fn func(tx *sql.Tx, r *row, exists bool) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	r := &row{k: key}
+	var rowKind int
+	scanErr := tx.QueryRowContext(ctx, c.sql.kindSQL, key).Scan(&rowKind, &r.createdAt, &r.expiredAt)
+	err = c.checkKind(scanErr, rowKind, kind, r.expiredAt)
+	if err != nil && err != cache.ErrNoKey {
+		tx.Rollback()
+		return err
+	}
+	exists := err == nil
+	var expired *row
+	if !exists && create {
+		if scanErr == nil {
+			// Expired but not swept yet: drop it with its child rows.
+			if _, err = tx.ExecContext(ctx, c.sql.delSQL, key); err != nil {
+				tx.Rollback()
+				return err
+			}
+			expired = r
+			r = &row{k: key}
+		}
+		r.createdAt, r.expiredAt = c.now(), -1
+		if _, err = tx.ExecContext(ctx, c.sql.insertKindSQL, key, r.createdAt, kind); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = fn(tx, r, exists); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if expired != nil {
+		c.notify(expired.event(k, cache.ReasonExpired))
+	}
+	return nil
+}
+
+// dropIfEmpty deletes the key k, whose main row was r, if a pop took its last
+// child row, and reports it as deleted. It does not lock the key while checking:
+// a concurrent push makes the delete a no-op. Errors are ignored, leaving the
+// emptied key to expiry or to the next pop.
+func (c *MysqlCache) dropIfEmpty(ctx context.Context, k interface{}, r *row, existsSQL, delSQL string) {
+	var more bool
+	if err := c.db.QueryRowContext(ctx, existsSQL, r.k).Scan(&more); err != nil || more {
+		return
+	}
+	if deleted, err := execAffected(ctx, c.db, delSQL, r.k, r.k); err == nil && deleted {
+		c.notify(r.event(k, cache.ReasonDeleted))
+	}
+}
@@ -0,0 +1,271 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.ListCache = (*MysqlCache)(nil)
+
+// A list key is a row of the main table of kindList, which carries the TTL of
+// the list, and one row per element in <tableName>_list, ordered by seq. Pushes
+// lock the main row; pops only lock the element they take, skipping those
+// locked by concurrent pops, so several processes can consume a queue at once.
+// A list emptied by a pop is deleted right after the pop commits, unless it was
+// pushed to meanwhile.
+
+// LPush inserts values at the head in one statement, creating the list if needed.
+func (c *MysqlCache) LPush(ctx context.Context, k interface{}, values ...interface{}) (int, error) {
+	return c.push(ctx, k, values, true)
+}
+
+// RPush appends values at the tail in one statement, creating the list if needed.
+func (c *MysqlCache) RPush(ctx context.Context, k interface{}, values ...interface{}) (int, error) {
+	return c.push(ctx, k, values, false)
+}
+
+func (c *MysqlCache) push(ctx context.Context, k interface{}, values []interface{}, left bool) (int, error) {
+	vals := make([]interface{}, len(values))
+	for i, v := range values {
+		var err error
+		if vals[i], err = sqlValue(v); err != nil {
+			return 0, fmt.Errorf("mysql cache: resolve value: %w", err)
+		}
+	}
+
+	n := 0
+	err := c.kindTx(ctx, k, kindList, len(vals) > 0, func(tx *sql.Tx, r *row, exists bool) error {
+		if !exists && len(vals) == 0 {
+			return nil
+		}
+		var first, last int64
+		if err := tx.QueryRowContext(ctx, c.sql.lBoundsSQL, r.k).Scan(&first, &last, &n); err != nil {
+			return err
+		}
+		if len(vals) == 0 {
+			return nil
+		}
+		var sb strings.Builder
+		sb.WriteString(c.sql.lPutSQL)
+		args := make([]interface{}, 0, 3*len(vals))
+		for i, v := range vals {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(" (?, ?, ?)")
+			seq := last + 1 + int64(i)
+			if left {
+				seq = first - 1 - int64(i)
+			}
+			args = append(args, r.k, seq, v)
+		}
+		if _, err := tx.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+		n += len(vals)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(vals) > 0 {
+		c.signalPush(keyToString(k))
+	}
+	return n, nil
+}
+
+// LPop removes the first element, as []byte like Get. Elements locked by a
+// concurrent pop are skipped; ErrNoKey is returned if there are no others.
+func (c *MysqlCache) LPop(ctx context.Context, k interface{}) (interface{}, error) {
+	return c.pop(ctx, k, true)
+}
+
+// RPop removes the last element, see LPop.
+func (c *MysqlCache) RPop(ctx context.Context, k interface{}) (interface{}, error) {
+	return c.pop(ctx, k, false)
+}
+
+func (c *MysqlCache) pop(ctx context.Context, k interface{}, left bool) (interface{}, error) {
+	if c.isClosed() {
+		return nil, cache.ErrClosed
+	}
+	key := keyToString(k)
+	q := c.sql.lPopLastSQL
+	if left {
+		q = c.sql.lPopFirstSQL
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &row{k: key}
+	var seq int64
+	var v []byte
+	err = tx.QueryRowContext(ctx, q, key, c.now()).Scan(&seq, &v, &r.createdAt, &r.expiredAt)
+	if err != nil {
+		tx.Rollback()
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		// Tell a key of another kind from a missing or drained list.
+		var kind, n int
+		var expiredAt int64
+		err = c.db.QueryRowContext(ctx, c.sql.lLenSQL, key, key).Scan(&kind, &expiredAt, &n)
+		if err := c.checkKind(err, kind, kindList, expiredAt); err != nil {
+			return nil, err
+		}
+		return nil, cache.ErrNoKey
+	}
+	if _, err = tx.ExecContext(ctx, c.sql.lDelSQL, key, seq); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	c.dropIfEmpty(ctx, k, r, c.sql.lExistsSQL, c.sql.delEmptyListSQL)
+	return v, nil
+}
+
+// BLPop polls the table every poll interval, see WithPollInterval, and right
+// after a push to k through c. It returns cache.ErrClosed once c is closed.
+func (c *MysqlCache) BLPop(ctx context.Context, k interface{}) (interface{}, error) {
+	key := keyToString(k)
+	var t cache.Ticker
+	for {
+		pushed := c.pushSignal(key)
+		v, err := c.pop(ctx, k, true)
+		if err != cache.ErrNoKey {
+			return v, err
+		}
+		if t == nil {
+			t = c.clock.NewTicker(c.pollInterval)
+			defer t.Stop()
+		}
+		select {
+		case <-pushed:
+		case <-t.C():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, cache.ErrClosed
+		}
+	}
+}
+
+// pushSignal returns a channel closed by the next push to key through c.
+func (c *MysqlCache) pushSignal(key string) <-chan struct{} {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	if c.pushed == nil {
+		c.pushed = make(map[string]chan struct{})
+	}
+	ch, ok := c.pushed[key]
+	if !ok {
+		ch = make(chan struct{})
+		c.pushed[key] = ch
+	}
+	return ch
+}
+
+// signalPush wakes the BLPop calls waiting on key.
+func (c *MysqlCache) signalPush(key string) {
+	c.pushMu.Lock()
+	if ch, ok := c.pushed[key]; ok {
+		close(ch)
+		delete(c.pushed, key)
+	}
+	c.pushMu.Unlock()
+}
+
+// LRange reads the elements, as []byte, in a read-only transaction.
+func (c *MysqlCache) LRange(ctx context.Context, k interface{}, start, stop int) ([]interface{}, error) {
+	key := keyToString(k)
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var kind, n int
+	var expiredAt int64
+	err = tx.QueryRowContext(ctx, c.sql.lLenSQL, key, key).Scan(&kind, &expiredAt, &n)
+	if err := c.checkKind(err, kind, kindList, expiredAt); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, cache.ErrNoKey // Drained, not deleted yet
+	}
+	lo, hi := cache.RangeBounds(n, start, stop)
+	vals := make([]interface{}, 0, hi-lo)
+	if lo == hi {
+		return vals, nil
+	}
+	rows, err := tx.QueryContext(ctx, c.sql.lRangeSQL, key, hi-lo, lo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v []byte
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, rows.Err()
+}
+
+// LTrim deletes the elements out of range, and the key if none is left.
+func (c *MysqlCache) LTrim(ctx context.Context, k interface{}, start, stop int) error {
+	var emptied *row
+	err := c.kindTx(ctx, k, kindList, false, func(tx *sql.Tx, r *row, exists bool) error {
+		if !exists {
+			return nil
+		}
+		var first, last int64
+		var n int
+		if err := tx.QueryRowContext(ctx, c.sql.lBoundsSQL, r.k).Scan(&first, &last, &n); err != nil {
+			return err
+		}
+		lo, hi := cache.RangeBounds(n, start, stop)
+		if lo == hi {
+			_, err := tx.ExecContext(ctx, c.sql.delSQL, r.k)
+			emptied = r
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, c.sql.lSeqSQL, r.k, lo).Scan(&first); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, c.sql.lSeqSQL, r.k, hi-1).Scan(&last); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, c.sql.lTrimSQL, r.k, first, last)
+		return err
+	})
+	if err == nil && emptied != nil {
+		c.notify(emptied.event(k, cache.ReasonDeleted))
+	}
+	return err
+}
+
+func (c *MysqlCache) LLen(ctx context.Context, k interface{}) (int, error) {
+	key := keyToString(k)
+	var kind, n int
+	var expiredAt int64
+	err := c.db.QueryRowContext(ctx, c.sql.lLenSQL, key, key).Scan(&kind, &expiredAt, &n)
+	if err := c.checkKind(err, kind, kindList, expiredAt); err != nil {
+		if err == cache.ErrNoKey {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return n, nil
+}
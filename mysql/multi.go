@@ -0,0 +1,98 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.MultiCache = (*MysqlCache)(nil)
+
+// GetMulti loads keys with one SELECT ... WHERE k IN (...) per WithBatchSize keys.
+// Values are returned as []byte, like Get.
+func (c *MysqlCache) GetMulti(ctx context.Context, keys []interface{}) ([]cache.MultiResult, error) {
+	res := make([]cache.MultiResult, len(keys))
+	pos := make(map[string][]int, len(keys)) // Row key -> positions in keys
+	rowKeys := make([]interface{}, 0, len(keys))
+	for i, k := range keys {
+		key := keyToString(k)
+		if _, ok := pos[key]; !ok {
+			rowKeys = append(rowKeys, key)
+		}
+		pos[key] = append(pos[key], i)
+	}
+	for len(rowKeys) > 0 {
+		n := len(rowKeys)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		rows, err := c.getRows(ctx, c.db, rowKeys[:n], "")
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			if c.entryTTL(r.expiredAt) == 0 {
+				continue
+			}
+			for _, i := range pos[r.k] {
+				res[i] = cache.MultiResult{Value: r.v, Found: true}
+			}
+		}
+		rowKeys = rowKeys[n:]
+	}
+	return res, nil
+}
+
+// PutMultiEx stores kvs with multi-row upserts, see PutBatch.
+func (c *MysqlCache) PutMultiEx(ctx context.Context, kvs []cache.KeyValue, sec int64) error {
+	items := make([]cache.BatchItem, len(kvs))
+	for i, kv := range kvs {
+		items[i] = cache.BatchItem{Key: kv.Key, Value: kv.Value, TTL: cache.SecondsToTTL(sec)}
+	}
+	return c.PutBatch(ctx, items)
+}
+
+// DelMulti deletes keys with one DELETE ... WHERE k IN (...) per WithBatchSize keys
+// and returns how many rows were deleted. Like Del, expired rows not yet swept count.
+func (c *MysqlCache) DelMulti(ctx context.Context, keys []interface{}) (int, error) {
+	if c.isClosed() {
+		return 0, cache.ErrClosed
+	}
+	orig := make(map[string]interface{}, len(keys)) // Row key -> caller key, for events
+	rowKeys := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		key := keyToString(k)
+		if _, ok := orig[key]; !ok {
+			orig[key] = k
+			rowKeys = append(rowKeys, key)
+		}
+	}
+	deleted := 0
+	for len(rowKeys) > 0 {
+		n := len(rowKeys)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		var old []*row
+		if c.hasHandler() {
+			var err error
+			if old, err = c.getRows(ctx, c.db, rowKeys[:n], ""); err != nil {
+				return deleted, err
+			}
+		}
+		rs, err := c.db.ExecContext(ctx, c.sql.deleteByKeysSQL+placeholders(n), rowKeys[:n]...)
+		if err != nil {
+			return deleted, err
+		}
+		affected, _ := rs.RowsAffected()
+		deleted += int(affected)
+		for _, r := range old {
+			// Rows that had already expired are not reported as deleted.
+			if c.entryTTL(r.expiredAt) != 0 {
+				c.notify(r.event(orig[r.k], cache.ReasonDeleted))
+			}
+		}
+		rowKeys = rowKeys[n:]
+	}
+	return deleted, nil
+}
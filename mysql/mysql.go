@@ -8,41 +8,207 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-comm/cache"
 )
 
+// Timestamps are Unix nanoseconds. Tables created by earlier versions used
+// second-resolution createdAt/expiredAt columns and had no version or kind
+// column; see UpgradeSchema.
 const createTableSQL = `CREATE TABLE IF NOT EXISTS %s (
 	k varchar(127) NOT NULL DEFAULT '',
 	v blob,           -- blob types: tinyblob(255B) blob(64KB) mediumblob(16MB) longblob(4GB)
-	createdAt bigint NOT NULL DEFAULT 0,
-	expiredAt bigint NOT NULL DEFAULT 0,
+	createdAtNs bigint NOT NULL DEFAULT 0,
+	expiredAtNs bigint NOT NULL DEFAULT 0,  -- -1 = never expire
+	version bigint unsigned NOT NULL DEFAULT 0,  -- 1 on insert, +1 on every write
+	kind tinyint NOT NULL DEFAULT 0,  -- 0 = value in v, else elements in a child table, see kindValue
 	PRIMARY KEY (k),
-	KEY idx_expiredAt (expiredAt)
+	KEY idx_expiredAtNs (expiredAtNs)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 `
 
+// createHashTableSQL creates the fields of hash keys (see HSet): one row per
+// field, deleted with the row of their key in the main table.
+const createHashTableSQL = `CREATE TABLE IF NOT EXISTS %[1]s_hash (
+	k varchar(127) NOT NULL DEFAULT '',
+	field varchar(255) NOT NULL DEFAULT '',
+	v blob,
+	PRIMARY KEY (k, field),
+	FOREIGN KEY (k) REFERENCES %[1]s (k) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+`
+
+// createListTableSQL creates the elements of list keys (see RPush), ordered by seq.
+const createListTableSQL = `CREATE TABLE IF NOT EXISTS %[1]s_list (
+	k varchar(127) NOT NULL DEFAULT '',
+	seq bigint NOT NULL,
+	v blob,
+	PRIMARY KEY (k, seq),
+	FOREIGN KEY (k) REFERENCES %[1]s (k) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+`
+
+// createZSetTableSQL creates the members of sorted set keys (see ZAdd). Members
+// are binary strings so that they sort byte-wise, like in cache.ZSet.
+const createZSetTableSQL = `CREATE TABLE IF NOT EXISTS %[1]s_zset (
+	k varchar(127) NOT NULL DEFAULT '',
+	member varbinary(255) NOT NULL,
+	score double NOT NULL,
+	PRIMARY KEY (k, member),
+	KEY idx_score (k, score, member),
+	FOREIGN KEY (k) REFERENCES %[1]s (k) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+`
+
+// childTablesSQL lists the tables created along with the main table.
+var childTablesSQL = []string{createHashTableSQL, createListTableSQL, createZSetTableSQL}
+
+// popListSQL locks the first or last element of a live list, skipping the
+// elements locked by concurrent pops; formatted with the table name, kindList
+// and the order, ASC or DESC.
+const popListSQL = `SELECT l.seq, l.v, m.createdAtNs, m.expiredAtNs FROM %[1]s_list l JOIN %[1]s m ON m.k=l.k
+	WHERE l.k=? AND m.kind=%[2]d AND (m.expiredAtNs<0 OR m.expiredAtNs>?)
+	ORDER BY l.seq %[3]s LIMIT 1 FOR UPDATE OF l SKIP LOCKED`
+
+// popZSetSQL locks the members of a live sorted set with the lowest scores,
+// skipping those locked by concurrent pops, like popListSQL; formatted with the
+// table name and kindZSet.
+const popZSetSQL = `SELECT z.member, z.score, m.createdAtNs, m.expiredAtNs FROM %[1]s_zset z JOIN %[1]s m ON m.k=z.k
+	WHERE z.k=? AND m.kind=%[2]d AND (m.expiredAtNs<0 OR m.expiredAtNs>?)
+	ORDER BY z.score, z.member LIMIT ? FOR UPDATE OF z SKIP LOCKED`
+
+// existsChildSQL and delEmptySQL check for the child rows of a key, and delete
+// it if it has none left; orphansSQL deletes the child rows left under keys that
+// became values. Formatted with the table name, the child table suffix and the
+// kind of the key.
+const (
+	existsChildSQL = `SELECT EXISTS (SELECT 1 FROM %[1]s_%[2]s WHERE k=?)`
+	delEmptySQL    = `DELETE FROM %[1]s WHERE k=? AND kind=%[3]d AND NOT EXISTS (SELECT 1 FROM %[1]s_%[2]s WHERE k=?)`
+	orphansSQL     = `DELETE c FROM %[1]s_%[2]s c JOIN %[1]s m ON m.k=c.k WHERE m.kind=0 AND c.k IN`
+)
+
+// kindReset makes a value write turn its row into a value. A row that was a hash,
+// list or sorted set reports its kind as the insert ID of the statement, as the
+// table has no AUTO_INCREMENT column; see execValue.
+const kindReset = `kind=IF(kind=0, 0, 0*LAST_INSERT_ID(kind))`
+
+// incrSQL adds an integer to a live counter in place; see incrInPlace. The
+// conditions are checked in order: v must be a decimal of at most 18 digits before
+// it is cast, as a truncating cast fails the statement in strict mode, and the
+// result must fit in a BIGINT. The new value is reported as the insert ID, as in
+// kindReset.
+const incrSQL = `UPDATE %s SET v=CAST(v AS SIGNED)+?, version=version+1+0*LAST_INSERT_ID(CAST(v AS SIGNED))
+	WHERE k=? AND kind=0 AND (expiredAtNs<0 OR expiredAtNs>?)
+	AND CONVERT(v USING latin1) REGEXP '^-?[0-9]{1,18}$' AND CAST(v AS SIGNED) BETWEEN ? AND ?`
+
+// upsertSuffix completes putBatchSQL, updating existing rows like putSQL.
+const upsertSuffix = ` ON DUPLICATE KEY UPDATE v=VALUES(v), createdAtNs=VALUES(createdAtNs), expiredAtNs=VALUES(expiredAtNs), version=version+1, ` + kindReset
+
 func buildSQL(tableName string) sqlSet {
 	return sqlSet{
 		putSQL: fmt.Sprintf(
-			`INSERT INTO %s (k, v, createdAt, expiredAt) VALUES (?, ?, ?, ?)
-			ON DUPLICATE KEY UPDATE v=VALUES(v), createdAt=VALUES(createdAt), expiredAt=VALUES(expiredAt)`, tableName),
-		getSQL:          fmt.Sprintf(`SELECT v, createdAt, expiredAt FROM %s WHERE k=? LIMIT 1`, tableName),
+			`INSERT INTO %s (k, v, createdAtNs, expiredAtNs, version) VALUES (?, ?, ?, ?, 1)
+			ON DUPLICATE KEY UPDATE v=VALUES(v), createdAtNs=VALUES(createdAtNs), expiredAtNs=VALUES(expiredAtNs), version=version+1, `+kindReset, tableName),
+		putBatchSQL:     fmt.Sprintf(`INSERT INTO %s (k, v, createdAtNs, expiredAtNs, version) VALUES`, tableName),
+		getSQL:          fmt.Sprintf(`SELECT v, createdAtNs, expiredAtNs FROM %s WHERE k=? LIMIT 1`, tableName),
+		getByKeysSQL:    fmt.Sprintf(`SELECT k, v, createdAtNs, expiredAtNs FROM %s WHERE k IN`, tableName),
 		delSQL:          fmt.Sprintf(`DELETE FROM %s WHERE k=?`, tableName),
-		expiredAtRelSQL: fmt.Sprintf(`UPDATE %s SET expiredAt=createdAt+? WHERE k=?`, tableName),
-		expiredAtAbsSQL: fmt.Sprintf(`UPDATE %s SET expiredAt=? WHERE k=?`, tableName),
-		expiredScanSQL:  fmt.Sprintf(`SELECT k FROM %s WHERE expiredAt>=0 AND expiredAt<? LIMIT ?`, tableName),
+		expiredAtSQL:    fmt.Sprintf(`UPDATE %s SET expiredAtNs=? WHERE k=?`, tableName),
+		expiredScanSQL:  fmt.Sprintf(`SELECT k FROM %s WHERE expiredAtNs>=0 AND expiredAtNs<? LIMIT ?`, tableName),
+		expiredRowsSQL:  fmt.Sprintf(`SELECT k, v, createdAtNs, expiredAtNs FROM %s WHERE expiredAtNs>=0 AND expiredAtNs<? LIMIT ?`, tableName),
+		clearRowsSQL:    fmt.Sprintf(`SELECT k, v, createdAtNs, expiredAtNs FROM %s LIMIT ?`, tableName),
 		deleteByKeysSQL: fmt.Sprintf(`DELETE FROM %s WHERE k IN`, tableName),
 		clearSQL:        fmt.Sprintf(`DELETE FROM %s`, tableName),
+		insertIgnoreSQL: fmt.Sprintf(`INSERT IGNORE INTO %s (k, v, createdAtNs, expiredAtNs, version) VALUES (?, ?, ?, ?, 1)`, tableName),
+		delExpiredSQL:   fmt.Sprintf(`DELETE FROM %s WHERE k=? AND expiredAtNs>=0 AND expiredAtNs<=?`, tableName),
+		updateLiveSQL: fmt.Sprintf(
+			`UPDATE %s SET v=?, createdAtNs=?, expiredAtNs=?, version=version+1, `+kindReset+`
+			WHERE k=? AND (expiredAtNs<0 OR expiredAtNs>?)`, tableName),
+		getVersionSQL: fmt.Sprintf(`SELECT v, createdAtNs, expiredAtNs, version FROM %s WHERE k=? LIMIT 1`, tableName),
+		updateSQL:     fmt.Sprintf(`UPDATE %s SET v=?, createdAtNs=?, expiredAtNs=?, version=version+1, `+kindReset+` WHERE k=?`, tableName),
+		incrSQL:       fmt.Sprintf(incrSQL, tableName),
+		insertKindSQL: fmt.Sprintf(`INSERT IGNORE INTO %s (k, createdAtNs, expiredAtNs, version, kind) VALUES (?, ?, -1, 1, ?)`, tableName),
+		kindSQL:       fmt.Sprintf(`SELECT kind, createdAtNs, expiredAtNs FROM %s WHERE k=? FOR UPDATE`, tableName),
+		hGetSQL: fmt.Sprintf(
+			`SELECT m.kind, m.expiredAtNs, h.field IS NOT NULL, h.v FROM %[1]s m
+			LEFT JOIN %[1]s_hash h ON h.k=m.k AND h.field=? WHERE m.k=?`, tableName),
+		hGetAllSQL: fmt.Sprintf(
+			`SELECT m.kind, m.expiredAtNs, h.field, h.v FROM %[1]s m
+			LEFT JOIN %[1]s_hash h ON h.k=m.k WHERE m.k=?`, tableName),
+		hLenSQL: fmt.Sprintf(
+			`SELECT kind, expiredAtNs, (SELECT COUNT(*) FROM %[1]s_hash WHERE k=?) FROM %[1]s WHERE k=?`, tableName),
+		hCountSQL:       fmt.Sprintf(`SELECT COUNT(*) FROM %s_hash WHERE k=?`, tableName),
+		hFieldSQL:       fmt.Sprintf(`SELECT v FROM %s_hash WHERE k=? AND field=? FOR UPDATE`, tableName),
+		hPutSQL:         fmt.Sprintf(`INSERT INTO %s_hash (k, field, v) VALUES`, tableName),
+		hDelFieldsSQL:   fmt.Sprintf(`DELETE FROM %s_hash WHERE k=? AND field IN`, tableName),
+		hOrphansSQL:     fmt.Sprintf(orphansSQL, tableName, "hash"),
+		delEmptyHashSQL: fmt.Sprintf(delEmptySQL, tableName, "hash", kindHash),
+		lLenSQL: fmt.Sprintf(
+			`SELECT kind, expiredAtNs, (SELECT COUNT(*) FROM %[1]s_list WHERE k=?) FROM %[1]s WHERE k=?`, tableName),
+		lBoundsSQL:      fmt.Sprintf(`SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0), COUNT(*) FROM %s_list WHERE k=?`, tableName),
+		lPutSQL:         fmt.Sprintf(`INSERT INTO %s_list (k, seq, v) VALUES`, tableName),
+		lPopFirstSQL:    fmt.Sprintf(popListSQL, tableName, kindList, "ASC"),
+		lPopLastSQL:     fmt.Sprintf(popListSQL, tableName, kindList, "DESC"),
+		lDelSQL:         fmt.Sprintf(`DELETE FROM %s_list WHERE k=? AND seq=?`, tableName),
+		lExistsSQL:      fmt.Sprintf(existsChildSQL, tableName, "list"),
+		delEmptyListSQL: fmt.Sprintf(delEmptySQL, tableName, "list", kindList),
+		lRangeSQL:       fmt.Sprintf(`SELECT v FROM %s_list WHERE k=? ORDER BY seq LIMIT ? OFFSET ?`, tableName),
+		lSeqSQL:         fmt.Sprintf(`SELECT seq FROM %s_list WHERE k=? ORDER BY seq LIMIT 1 OFFSET ?`, tableName),
+		lTrimSQL:        fmt.Sprintf(`DELETE FROM %s_list WHERE k=? AND (seq<? OR seq>?)`, tableName),
+		lOrphansSQL:     fmt.Sprintf(orphansSQL, tableName, "list"),
+		zCardSQL: fmt.Sprintf(
+			`SELECT kind, expiredAtNs, (SELECT COUNT(*) FROM %[1]s_zset WHERE k=?) FROM %[1]s WHERE k=?`, tableName),
+		zScoreSQL: fmt.Sprintf(
+			`SELECT m.kind, m.expiredAtNs, z.score FROM %[1]s m
+			LEFT JOIN %[1]s_zset z ON z.k=m.k AND z.member=? WHERE m.k=?`, tableName),
+		zRangeSQL: fmt.Sprintf(
+			`SELECT m.kind, m.expiredAtNs, z.member, z.score FROM %[1]s m
+			LEFT JOIN %[1]s_zset z ON z.k=m.k AND z.score>=? AND z.score<=? WHERE m.k=?
+			ORDER BY z.score, z.member LIMIT ?`, tableName),
+		zRankSQL:        fmt.Sprintf(`SELECT COUNT(*) FROM %s_zset WHERE k=? AND (score<? OR (score=? AND member<?))`, tableName),
+		zCountSQL:       fmt.Sprintf(`SELECT COUNT(*) FROM %s_zset WHERE k=?`, tableName),
+		zPutSQL:         fmt.Sprintf(`INSERT INTO %s_zset (k, member, score) VALUES`, tableName),
+		zDelSQL:         fmt.Sprintf(`DELETE FROM %s_zset WHERE k=? AND member IN`, tableName),
+		zPopSQL:         fmt.Sprintf(popZSetSQL, tableName, kindZSet),
+		zExistsSQL:      fmt.Sprintf(existsChildSQL, tableName, "zset"),
+		zOrphansSQL:     fmt.Sprintf(orphansSQL, tableName, "zset"),
+		scanStartSQL:    fmt.Sprintf(`SELECT k, expiredAtNs FROM %s WHERE k LIKE ? ESCAPE '!' ORDER BY k LIMIT ?`, tableName),
+		scanNextSQL:     fmt.Sprintf(`SELECT k, expiredAtNs FROM %s WHERE k > ? AND k LIKE ? ESCAPE '!' ORDER BY k LIMIT ?`, tableName),
+		delEmptyZSetSQL: fmt.Sprintf(delEmptySQL, tableName, "zset", kindZSet),
 	}
 }
 
 type sqlSet struct {
-	putSQL, getSQL, delSQL           string
-	expiredAtRelSQL, expiredAtAbsSQL string
-	expiredScanSQL, deleteByKeysSQL  string
-	clearSQL                         string
+	putSQL, getSQL, delSQL          string
+	putBatchSQL, getByKeysSQL       string // Followed by the rows / key list
+	expiredAtSQL                    string
+	expiredScanSQL, deleteByKeysSQL string
+	expiredRowsSQL, clearRowsSQL    string // Like expiredScanSQL / clearSQL, loading rows for ExpireEventHandler
+	clearSQL                        string
+	insertIgnoreSQL, delExpiredSQL  string // Conditional writes, see PutIfAbsent
+	updateLiveSQL, getVersionSQL    string
+	updateSQL, incrSQL              string
+	insertKindSQL, kindSQL          string // Keys with child rows, see kindTx
+	hCountSQL                       string // Hash operations, see HSet
+	hGetSQL, hGetAllSQL, hLenSQL    string
+	hFieldSQL                       string
+	hPutSQL, hDelFieldsSQL          string // Followed by the rows / field list
+	hOrphansSQL, delEmptyHashSQL    string
+	lLenSQL, lBoundsSQL, lPutSQL    string // List operations, see RPush
+	lPopFirstSQL, lPopLastSQL       string
+	lDelSQL, lExistsSQL             string
+	delEmptyListSQL                 string
+	lRangeSQL, lSeqSQL, lTrimSQL    string
+	lOrphansSQL                     string
+	zCardSQL, zScoreSQL, zRangeSQL  string // Sorted set operations, see ZAdd
+	zRankSQL, zCountSQL, zPopSQL    string
+	zPutSQL, zDelSQL                string // Followed by the rows / member list
+	zExistsSQL, delEmptyZSetSQL     string
+	zOrphansSQL                     string
+	scanStartSQL, scanNextSQL       string // Key scans, see ScanKeys
 }
 
 type Option func(*MysqlCache)
@@ -63,24 +229,65 @@ func WithAutoCreateTable() Option {
 	return func(c *MysqlCache) { c.autoCreate = true }
 }
 
+// WithClock sets the time source used for expiration and the expire loop ticker.
+// Timestamps are shared through the table, so all processes should agree on the time source.
+func WithClock(clock cache.Clock) Option {
+	return func(c *MysqlCache) { c.clock = clock }
+}
+
+// WithDispatch configures the worker pool that delivers expire callbacks.
+// A nil Logger in cfg falls back to the cache logger.
+func WithDispatch(cfg cache.DispatchConfig) Option {
+	return func(c *MysqlCache) { c.dispatchCfg = cfg }
+}
+
+// WithPollInterval sets how often BLPop checks the table for elements pushed by
+// other processes. Pushes through the same MysqlCache wake it up at once.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *MysqlCache) { c.pollInterval = d }
+}
+
+// WithSchemaUpgrade makes New run UpgradeSchema on the table before use.
+func WithSchemaUpgrade() Option {
+	return func(c *MysqlCache) { c.upgrade = true }
+}
+
 const (
 	defaultCheckInterval = 30 * time.Second
 	minCheckInterval     = 5 * time.Second
 	defaultBatchSize     = 100
+	defaultPollInterval  = 100 * time.Millisecond
+	defaultScanCount     = 100 // Keys per ScanKeys call, like cache.Memory
 )
 
 type MysqlCache struct {
+	closed              int32 // Set by Close (atomic)
 	db                  *sql.DB
 	tableName           string
 	sql                 sqlSet
 	checkInterval       time.Duration
 	batchSize           int
 	noCheck, autoCreate bool
+	upgrade             bool
+	clock               cache.Clock
 	logger              func(v ...interface{})
 	cancel              context.CancelFunc
+	loopDone            chan struct{} // Closed when expireLoop returns
+	done                chan struct{} // Closed by Close, stops BLPop
+	pollInterval        time.Duration
+	pushMu              sync.Mutex
+	pushed              map[string]chan struct{} // Closed by the next push to a key, see BLPop
 	expireHandler       func(k interface{}, v interface{})
+	eventHandler        func(ev cache.ExpireEvent)
+	dispatchCfg         cache.DispatchConfig
+	dispatch            *cache.Dispatcher // Delivers expire callbacks
 }
 
+var (
+	_ cache.Cache       = (*MysqlCache)(nil)
+	_ cache.BatchWriter = (*MysqlCache)(nil)
+)
+
 func New(db *sql.DB, tableName string, opts ...Option) (*MysqlCache, error) {
 	if db == nil {
 		return nil, errors.New("mysql cache: db is nil")
@@ -91,34 +298,181 @@ func New(db *sql.DB, tableName string, opts ...Option) (*MysqlCache, error) {
 	c := &MysqlCache{
 		db: db, tableName: tableName, sql: buildSQL(tableName),
 		checkInterval: defaultCheckInterval, batchSize: defaultBatchSize,
-		logger: log.Println,
+		logger: log.Println, clock: cache.SystemClock,
+		pollInterval: defaultPollInterval, done: make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.clock == nil {
+		c.clock = cache.SystemClock
+	}
 	if c.checkInterval < minCheckInterval {
 		c.checkInterval = minCheckInterval
 	}
 	if c.batchSize < 1 {
 		c.batchSize = defaultBatchSize
 	}
+	if c.pollInterval <= 0 {
+		c.pollInterval = defaultPollInterval
+	}
 	if c.autoCreate {
 		if _, err := db.Exec(fmt.Sprintf(createTableSQL, tableName)); err != nil {
 			return nil, fmt.Errorf("mysql cache: auto create table: %w", err)
 		}
+		for _, q := range childTablesSQL {
+			if _, err := db.Exec(fmt.Sprintf(q, tableName)); err != nil {
+				return nil, fmt.Errorf("mysql cache: auto create table: %w", err)
+			}
+		}
+	}
+	if c.upgrade {
+		if err := UpgradeSchema(context.Background(), db, tableName); err != nil {
+			return nil, err
+		}
 	}
+	if c.dispatchCfg.Logger == nil {
+		c.dispatchCfg.Logger = c.logger
+	}
+	c.dispatch = cache.NewDispatcher(c.dispatchCfg)
 	if !c.noCheck {
 		ctx, cancel := context.WithCancel(context.Background())
 		c.cancel = cancel
-		go c.expireLoop(ctx)
+		c.loopDone = make(chan struct{})
+		go c.expireLoop(ctx, c.clock.NewTicker(c.checkInterval))
 	}
 	return c, nil
 }
 
-func (c *MysqlCache) Close() {
+// Close stops the expiration loop and waits until it has exited and pending
+// expire callbacks have returned, or ctx is done. After Close, writes return
+// cache.ErrClosed, and BLPop calls return it. The underlying *sql.DB is owned by
+// the caller and stays open.
+// Closing an already closed cache is a no-op.
+func (c *MysqlCache) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	close(c.done)
 	if c.cancel != nil {
 		c.cancel()
+		select {
+		case <-c.loopDone:
+		case <-ctx.Done():
+			c.dispatch.Close(ctx) // Stop the workers without waiting
+			return ctx.Err()
+		}
 	}
+	return c.dispatch.Close(ctx)
+}
+
+func (c *MysqlCache) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// notify hands the expire handlers to the dispatcher; events for a key are delivered in order.
+func (c *MysqlCache) notify(ev cache.ExpireEvent) {
+	h, eh := c.expireHandler, c.eventHandler
+	if !ev.Reason.Legacy() {
+		h = nil
+	}
+	if h == nil && eh == nil {
+		return
+	}
+	c.dispatch.Dispatch(keyToString(ev.Key), func() {
+		if h != nil {
+			h(ev.Key, ev.Value)
+		}
+		if eh != nil {
+			eh(ev)
+		}
+	})
+}
+
+// hasHandler reports whether any expire handler is registered.
+func (c *MysqlCache) hasHandler() bool {
+	return c.expireHandler != nil || c.eventHandler != nil
+}
+
+// row is a stored cache row.
+type row struct {
+	k                    string
+	v                    []byte
+	createdAt, expiredAt int64
+}
+
+// event describes r, stored under k, leaving the cache for reason.
+// Rows loaded by key only (expiry without ExpireEventHandler) carry a nil value.
+func (r *row) event(k interface{}, reason cache.ExpireReason) cache.ExpireEvent {
+	ev := cache.ExpireEvent{Key: k, Reason: reason, CreatedAt: r.createdAt, ExpiredAt: r.expiredAt}
+	if r.v != nil {
+		ev.Value = r.v
+	}
+	return ev
+}
+
+// rowQuerier is implemented by *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// getRow loads the row stored under key. Returns cache.ErrNoKey if there is none,
+// regardless of its expiration.
+func (c *MysqlCache) getRow(ctx context.Context, q rowQuerier, query string, key string) (*row, error) {
+	r := &row{k: key}
+	err := q.QueryRowContext(ctx, query, key).Scan(&r.v, &r.createdAt, &r.expiredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, cache.ErrNoKey
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// rowsQuerier is implemented by *sql.DB and *sql.Tx.
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getRows loads the rows stored under keys (row keys, see keyToString), regardless
+// of their expiration. suffix is appended to the query, e.g. " FOR UPDATE".
+func (c *MysqlCache) getRows(ctx context.Context, q rowsQuerier, keys []interface{}, suffix string) ([]*row, error) {
+	rows, err := q.QueryContext(ctx, c.sql.getByKeysSQL+placeholders(len(keys))+suffix, keys...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var found []*row
+	for rows.Next() {
+		r := &row{}
+		if err := rows.Scan(&r.k, &r.v, &r.createdAt, &r.expiredAt); err != nil {
+			return nil, err
+		}
+		found = append(found, r)
+	}
+	return found, rows.Err()
+}
+
+// placeholders returns an IN list of n placeholders: " (?,?,?)".
+func placeholders(n int) string {
+	var sb strings.Builder
+	sb.Grow(3 + 2*n)
+	sb.WriteString(" (")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('?')
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// NormalizeKey returns the string k is stored under: keys with the same string
+// form, such as 1 and "1", address the same row. See cache.KeyNormalizer.
+func (c *MysqlCache) NormalizeKey(k interface{}) interface{} {
+	return keyToString(k)
 }
 
 func keyToString(k interface{}) string {
@@ -127,6 +481,8 @@ func keyToString(k interface{}) string {
 		return d
 	case []byte:
 		return cache.BytesToStr(d)
+	case cache.Keyer:
+		return string(d.CacheKey())
 	default:
 		if s, ok := d.(interface{ String() string }); ok {
 			return s.String()
@@ -153,19 +509,22 @@ func sqlValue(v interface{}) (interface{}, error) {
 	}
 }
 
-func now() int64 {
-	return time.Now().Unix()
+// now returns current Unix timestamp in nanoseconds according to the cache clock.
+func (c *MysqlCache) now() int64 {
+	return c.clock.Now().UnixNano()
 }
 
-func entryTTL(expiredAt int64) int64 {
+// entryTTL returns the remaining TTL of a row: cache.NoExpiration if it never expires,
+// 0 if it has expired.
+func (c *MysqlCache) entryTTL(expiredAt int64) time.Duration {
 	if expiredAt < 0 {
-		return -1
+		return cache.NoExpiration
 	}
-	ttl := expiredAt - now()
+	ttl := expiredAt - c.now()
 	if ttl < 0 {
 		return 0
 	}
-	return ttl
+	return time.Duration(ttl)
 }
 
 func (c *MysqlCache) Get(ctx context.Context, k interface{}) (interface{}, error) {
@@ -174,28 +533,37 @@ func (c *MysqlCache) Get(ctx context.Context, k interface{}) (interface{}, error
 }
 
 func (c *MysqlCache) GetAndTTL(ctx context.Context, k interface{}) (interface{}, int64, error) {
+	v, ttl, err := c.getInternal(ctx, k)
+	return v, cache.TTLSeconds(ttl), err
+}
+
+// GetAndTTLDuration returns the value and its exact remaining TTL in one query.
+// See cache.DurationGetter.
+func (c *MysqlCache) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
 	return c.getInternal(ctx, k)
 }
 
-func (c *MysqlCache) getInternal(ctx context.Context, k interface{}) (interface{}, int64, error) {
-	key := keyToString(k)
-	var v []byte
-	var createdAt, expiredAt int64
-	err := c.db.QueryRowContext(ctx, c.sql.getSQL, key).Scan(&v, &createdAt, &expiredAt)
+func (c *MysqlCache) getInternal(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	r, err := c.getRow(ctx, c.db, c.sql.getSQL, keyToString(k))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, 0, cache.ErrNoKey
-		}
 		return nil, 0, err
 	}
-	ttl := entryTTL(expiredAt)
+	ttl := c.entryTTL(r.expiredAt)
 	if ttl == 0 {
 		return nil, 0, cache.ErrNoKey
 	}
-	return v, ttl, nil
+	return r.v, ttl, nil
 }
 
 func (c *MysqlCache) TTL(ctx context.Context, k interface{}) (int64, error) {
+	_, ttl, err := c.getInternal(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return cache.TTLSeconds(ttl), nil
+}
+
+func (c *MysqlCache) TTLDuration(ctx context.Context, k interface{}) (time.Duration, error) {
 	_, ttl, err := c.getInternal(ctx, k)
 	return ttl, err
 }
@@ -213,7 +581,7 @@ func (c *MysqlCache) ScanAndTTL(ctx context.Context, k interface{}, scan cache.S
 	if err != nil {
 		return 0, err
 	}
-	return ttl, scan.Scan(v)
+	return cache.TTLSeconds(ttl), scan.Scan(v)
 }
 
 func (c *MysqlCache) Put(ctx context.Context, k interface{}, v interface{}) error {
@@ -221,31 +589,147 @@ func (c *MysqlCache) Put(ctx context.Context, k interface{}, v interface{}) erro
 }
 
 func (c *MysqlCache) PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error {
+	return c.PutTTL(ctx, k, v, cache.SecondsToTTL(sec))
+}
+
+func (c *MysqlCache) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
 	key := keyToString(k)
 	b, err := sqlValue(v)
 	if err != nil {
 		return fmt.Errorf("mysql cache: resolve value: %w", err)
 	}
-	createdAt := now()
-	expiredAt := int64(-1)
-	if sec >= 0 {
-		expiredAt = createdAt + sec
+	createdAt := c.now()
+	expiredAt := cache.ExpiredAt(createdAt, ttl)
+	if c.eventHandler != nil {
+		return c.replace(ctx, k, key, b, createdAt, expiredAt)
 	}
-	_, err = c.db.ExecContext(ctx, c.sql.putSQL, key, b, createdAt, expiredAt)
+	_, err = c.execValue(ctx, c.db, []interface{}{key}, c.sql.putSQL, key, b, createdAt, expiredAt)
 	return err
 }
 
+// replace upserts a row like PutTTL, loading the previous row in the same
+// transaction so that its replacement can be reported to ExpireEventHandler.
+func (c *MysqlCache) replace(ctx context.Context, k interface{}, key string, v interface{}, createdAt, expiredAt int64) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	old, err := c.getRow(ctx, tx, c.sql.getSQL+` FOR UPDATE`, key)
+	if err != nil && !errors.Is(err, cache.ErrNoKey) {
+		tx.Rollback()
+		return err
+	}
+	if _, err = c.execValue(ctx, tx, []interface{}{key}, c.sql.putSQL, key, v, createdAt, expiredAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if old != nil {
+		reason := cache.ReasonReplaced
+		if c.entryTTL(old.expiredAt) == 0 {
+			reason = cache.ReasonExpired // Expired but not yet swept
+		}
+		c.notify(old.event(k, reason))
+	}
+	return nil
+}
+
+// PutBatch stores items with one multi-row INSERT ... ON DUPLICATE KEY UPDATE
+// per WithBatchSize rows. Batches are written in order; if one fails, the
+// following ones are not attempted. See cache.BatchWriter.
+func (c *MysqlCache) PutBatch(ctx context.Context, items []cache.BatchItem) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	createdAt := c.now()
+	for len(items) > 0 {
+		n := len(items)
+		if n > c.batchSize {
+			n = c.batchSize
+		}
+		if err := c.putBatch(ctx, items[:n], createdAt); err != nil {
+			return err
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+func (c *MysqlCache) putBatch(ctx context.Context, items []cache.BatchItem, createdAt int64) error {
+	var sb strings.Builder
+	sb.WriteString(c.sql.putBatchSQL)
+	args := make([]interface{}, 0, 4*len(items))
+	keys := make(map[string]interface{}, len(items)) // Row key -> caller key, for events
+	for i, it := range items {
+		b, err := sqlValue(it.Value)
+		if err != nil {
+			return fmt.Errorf("mysql cache: resolve value: %w", err)
+		}
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(" (?, ?, ?, ?, 1)")
+		key := keyToString(it.Key)
+		keys[key] = it.Key
+		args = append(args, key, b, createdAt, cache.ExpiredAt(createdAt, it.TTL))
+	}
+	sb.WriteString(upsertSuffix)
+	rowKeys := make([]interface{}, 0, len(keys))
+	for k := range keys {
+		rowKeys = append(rowKeys, k)
+	}
+	if c.eventHandler == nil {
+		_, err := c.execValue(ctx, c.db, rowKeys, sb.String(), args...)
+		return err
+	}
+
+	// Load the replaced rows in the same transaction, like replace.
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	old, err := c.getRows(ctx, tx, rowKeys, " FOR UPDATE")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = c.execValue(ctx, tx, rowKeys, sb.String(), args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for _, r := range old {
+		reason := cache.ReasonReplaced
+		if c.entryTTL(r.expiredAt) == 0 {
+			reason = cache.ReasonExpired // Expired but not yet swept
+		}
+		c.notify(r.event(keys[r.k], reason))
+	}
+	return nil
+}
+
 func (c *MysqlCache) Del(ctx context.Context, k interface{}) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
 	key := keyToString(k)
-	var val interface{}
-	var hasVal bool
-	if c.expireHandler != nil {
+	var old *row
+	if c.hasHandler() {
 		var err error
-		val, _, err = c.getInternal(ctx, k)
+		old, err = c.getRow(ctx, c.db, c.sql.getSQL, key)
 		if err != nil && !errors.Is(err, cache.ErrNoKey) {
 			return err
 		}
-		hasVal = err == nil
+		if old != nil && c.entryTTL(old.expiredAt) == 0 {
+			old = nil // Already expired: not reported as deleted
+		}
 	}
 	rs, err := c.db.ExecContext(ctx, c.sql.delSQL, key)
 	if err != nil {
@@ -255,24 +739,25 @@ func (c *MysqlCache) Del(ctx context.Context, k interface{}) error {
 	if n == 0 {
 		return cache.ErrNoKey
 	}
-	if hasVal && c.expireHandler != nil {
-		go c.expireHandler(k, val)
+	if old != nil {
+		c.notify(old.event(k, cache.ReasonDeleted))
 	}
 	return nil
 }
 
 func (c *MysqlCache) Expire(ctx context.Context, k interface{}, sec int64) error {
+	return c.ExpireIn(ctx, k, cache.SecondsToTTL(sec))
+}
+
+// ExpireIn sets an existing key to expire ttl from now; ttl < 0 makes it non-expiring.
+// Note that MySQL reports 0 affected rows when the new value equals the old one,
+// which is indistinguishable from a missing key.
+func (c *MysqlCache) ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error {
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
 	key := keyToString(k)
-	var sqlStr string
-	var arg int64
-	if sec < 0 {
-		sqlStr = c.sql.expiredAtAbsSQL
-		arg = -1
-	} else {
-		sqlStr = c.sql.expiredAtRelSQL
-		arg = sec
-	}
-	rs, err := c.db.ExecContext(ctx, sqlStr, arg, key)
+	rs, err := c.db.ExecContext(ctx, c.sql.expiredAtSQL, cache.ExpiredAt(c.now(), ttl), key)
 	if err != nil {
 		return err
 	}
@@ -306,7 +791,7 @@ func (c *MysqlCache) Range(ctx context.Context, fn func(k interface{}, v interfa
 // It returns the last key of the page, whether any row was found, and any error.
 func (c *MysqlCache) rangeScan(ctx context.Context, lastKey string, limit int, fn func(k interface{}, v interface{}) error) (string, bool, error) {
 	rows, err := c.db.QueryContext(ctx,
-		"SELECT k, v, expiredAt FROM "+c.tableName+" WHERE k > ? ORDER BY k LIMIT ?",
+		"SELECT k, v, expiredAtNs FROM "+c.tableName+" WHERE k > ? ORDER BY k LIMIT ?",
 		lastKey, limit)
 	if err != nil {
 		return lastKey, false, err
@@ -325,7 +810,7 @@ func (c *MysqlCache) rangeScan(ctx context.Context, lastKey string, limit int, f
 		// Always advance the cursor, even for expired entries,
 		// to avoid an infinite loop on a page full of expired rows.
 		lastKey = k
-		if entryTTL(expiredAt) == 0 {
+		if c.entryTTL(expiredAt) == 0 {
 			continue
 		}
 		if err := fn(k, v); err != nil {
@@ -338,15 +823,31 @@ func (c *MysqlCache) rangeScan(ctx context.Context, lastKey string, limit int, f
 	return lastKey, hasRow, nil
 }
 
+// Clear removes all rows. With an ExpireEventHandler registered, rows are loaded
+// and deleted in batches so that each one can be reported with cache.ReasonCleared.
 func (c *MysqlCache) Clear(ctx context.Context) error {
-	_, err := c.db.ExecContext(ctx, c.sql.clearSQL)
-	return err
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
+	if c.eventHandler == nil {
+		_, err := c.db.ExecContext(ctx, c.sql.clearSQL)
+		return err
+	}
+	for {
+		done, err := c.deleteBatch(ctx, cache.ReasonCleared, c.sql.clearRowsSQL, c.batchSize)
+		if err != nil || done {
+			return err
+		}
+	}
 }
 
 func (c *MysqlCache) Tx(ctx context.Context, k interface{}, fn func(*cache.Entry) error) error {
 	if fn == nil {
 		return errors.New("mysql cache: tx fn is nil")
 	}
+	if c.isClosed() {
+		return cache.ErrClosed
+	}
 	key := keyToString(k)
 	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -363,11 +864,11 @@ func (c *MysqlCache) Tx(ctx context.Context, k interface{}, fn func(*cache.Entry
 		}
 		return err
 	}
-	if entryTTL(expiredAt) == 0 {
+	if c.entryTTL(expiredAt) == 0 {
 		tx.Rollback()
 		return cache.ErrNoKey
 	}
-	e := &cache.Entry{Value: v, CreatedAt: createdAt, ExpiredAt: expiredAt}
+	e := cache.NewEntry(c.clock, v, createdAt, expiredAt)
 	err = fn(e)
 	if err != nil {
 		tx.Rollback()
@@ -378,7 +879,7 @@ func (c *MysqlCache) Tx(ctx context.Context, k interface{}, fn func(*cache.Entry
 		tx.Rollback()
 		return fmt.Errorf("mysql cache: tx resolve value: %w", err)
 	}
-	_, err = tx.ExecContext(ctx, c.sql.putSQL, key, resolved, e.CreatedAt, e.ExpiredAt)
+	_, err = c.execValue(ctx, tx, []interface{}{key}, c.sql.putSQL, key, resolved, e.CreatedAt, e.ExpiredAt)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -390,12 +891,19 @@ func (c *MysqlCache) ExpireHandler(h func(k interface{}, v interface{})) {
 	c.expireHandler = h
 }
 
-func (c *MysqlCache) expireLoop(ctx context.Context) {
-	ticker := time.NewTicker(c.checkInterval)
+// ExpireEventHandler sets a callback invoked for every row leaving the cache, with the reason.
+// Registering it makes PutTTL load the previous row in a transaction and Clear delete in
+// batches, so that overwrites and cleared rows can be reported.
+func (c *MysqlCache) ExpireEventHandler(h func(ev cache.ExpireEvent)) {
+	c.eventHandler = h
+}
+
+func (c *MysqlCache) expireLoop(ctx context.Context, ticker cache.Ticker) {
 	defer ticker.Stop()
+	defer close(c.loopDone)
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 		case <-ctx.Done():
 			return
 		}
@@ -422,46 +930,57 @@ func (c *MysqlCache) cleanupExpired(ctx context.Context) {
 }
 
 func (c *MysqlCache) deleteExpiredBatch(ctx context.Context) (bool, error) {
-	rows, err := c.db.QueryContext(ctx, c.sql.expiredScanSQL, now(), c.batchSize)
+	query := c.sql.expiredScanSQL
+	if c.eventHandler != nil {
+		query = c.sql.expiredRowsSQL // Events carry value and timestamps
+	}
+	return c.deleteBatch(ctx, cache.ReasonExpired, query, c.now(), c.batchSize)
+}
+
+// deleteBatch deletes the rows selected by query and reports them with reason.
+// query selects either k alone or k, v, createdAtNs, expiredAtNs, and its last argument
+// must be the batch size. Returns true once fewer rows than a full batch were found.
+func (c *MysqlCache) deleteBatch(ctx context.Context, reason cache.ExpireReason, query string, args ...interface{}) (bool, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	cols, err := rows.Columns()
 	if err != nil {
+		rows.Close()
 		return false, err
 	}
-	var keys []string
+	var found []*row
 	for rows.Next() {
-		var k string
-		if err := rows.Scan(&k); err != nil {
+		r := &row{}
+		if len(cols) == 1 {
+			err = rows.Scan(&r.k)
+		} else {
+			err = rows.Scan(&r.k, &r.v, &r.createdAt, &r.expiredAt)
+		}
+		if err != nil {
 			rows.Close()
 			return false, err
 		}
-		keys = append(keys, k)
+		found = append(found, r)
 	}
 	rows.Close()
 	if err := rows.Err(); err != nil {
 		return false, err
 	}
-	if len(keys) == 0 {
+	if len(found) == 0 {
 		return true, nil
 	}
-	var sb strings.Builder
-	sb.WriteString(c.sql.deleteByKeysSQL)
-	sb.WriteString(" (")
-	args := make([]interface{}, len(keys))
-	for i, k := range keys {
-		if i > 0 {
-			sb.WriteByte(',')
-		}
-		sb.WriteByte('?')
-		args[i] = k
+	keys := make([]interface{}, len(found))
+	for i, r := range found {
+		keys[i] = r.k
 	}
-	sb.WriteByte(')')
-	_, err = c.db.ExecContext(ctx, sb.String(), args...)
+	_, err = c.db.ExecContext(ctx, c.sql.deleteByKeysSQL+placeholders(len(keys)), keys...)
 	if err != nil {
 		return false, err
 	}
-	if c.expireHandler != nil {
-		for _, k := range keys {
-			go c.expireHandler(k, nil)
-		}
+	for _, r := range found {
+		c.notify(r.event(r.k, reason))
 	}
-	return len(keys) < c.batchSize, nil
+	return len(found) < args[len(args)-1].(int), nil
 }
@@ -3,7 +3,12 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"math"
 	"os"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,7 +43,7 @@ func newTestCache(t *testing.T) (*MysqlCache, func()) {
 	}
 	cleanup := func() {
 		c.Clear(context.Background())
-		c.Close()
+		c.Close(context.Background())
 		db.Close()
 	}
 	return c, cleanup
@@ -73,7 +78,7 @@ func TestNewWithAutoCreate(t *testing.T) {
 		t.Fatalf("New with auto create: %v", err)
 	}
 	defer func() {
-		c.Close()
+		c.Close(context.Background())
 		db.Exec("DROP TABLE IF EXISTS " + tbl)
 		db.Close()
 	}()
@@ -358,6 +363,42 @@ func TestMysqlExpireHandler(t *testing.T) {
 	}
 }
 
+func TestMysqlExpireEventHandler(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	events := make(chan cache.ExpireEvent, 10)
+	c.ExpireEventHandler(func(ev cache.ExpireEvent) { events <- ev })
+	next := func() cache.ExpireEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("expire event not delivered")
+		}
+		return cache.ExpireEvent{}
+	}
+
+	c.PutEx(ctx, "ev_k", []byte("v1"), 60)
+	c.Put(ctx, "ev_k", []byte("v2"))
+	ev := next()
+	if ev.Reason != cache.ReasonReplaced || string(ev.Value.([]byte)) != "v1" || ev.ExpiredAt < 0 {
+		t.Fatalf("expected v1 replaced, got %+v", ev)
+	}
+
+	c.Del(ctx, "ev_k")
+	if ev := next(); ev.Reason != cache.ReasonDeleted || string(ev.Value.([]byte)) != "v2" {
+		t.Fatalf("expected v2 deleted, got %+v", ev)
+	}
+
+	c.Put(ctx, "ev_c", []byte("v3"))
+	c.Clear(ctx)
+	if ev := next(); ev.Reason != cache.ReasonCleared || ev.Key != "ev_c" {
+		t.Fatalf("expected ev_c cleared, got %+v", ev)
+	}
+}
+
 // ============================================================================
 // Key Types
 // ============================================================================
@@ -433,7 +474,7 @@ func newRangeTestCache(t *testing.T) (*MysqlCache, func()) {
 		t.Fatalf("New: %v", err)
 	}
 	cleanup := func() {
-		c.Close()
+		c.Close(context.Background())
 		db.Exec("DROP TABLE IF EXISTS " + tbl)
 		db.Close()
 	}
@@ -558,3 +599,824 @@ func TestMysqlRangeCursorPagination(t *testing.T) {
 		t.Fatalf("expected %d entries, got %d", n, count)
 	}
 }
+
+// ============================================================================
+// Close
+// ============================================================================
+
+func TestMysqlCloseRejectsWrites(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	c, err := New(db, testTable, WithAutoCreateTable(), WithCheckInterval(minCheckInterval))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.loopDone:
+	default:
+		t.Fatal("expected expireLoop to have exited")
+	}
+	if err := c.Put(ctx, "k", []byte("v")); err != cache.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal("second Close failed:", err)
+	}
+}
+
+// ============================================================================
+// Sub-second TTL / schema upgrade
+// ============================================================================
+
+func TestMysqlPutTTLSubSecond(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := c.PutTTL(ctx, "ms_k", []byte("v"), 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := c.TTLDuration(ctx, "ms_k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 300*time.Millisecond {
+		t.Fatalf("expected ttl in (0,300ms], got %v", ttl)
+	}
+	// second-based TTL rounds up
+	if sec, _ := c.TTL(ctx, "ms_k"); sec != 1 {
+		t.Fatalf("expected TTL 1s, got %d", sec)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if _, err := c.Get(ctx, "ms_k"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey after expiry, got %v", err)
+	}
+}
+
+func TestMysqlExpireIn(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	c.Put(ctx, "ei_k", []byte("v"))
+	if err := c.ExpireIn(ctx, "ei_k", 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	ttl, _ := c.TTLDuration(ctx, "ei_k")
+	if ttl <= time.Second || ttl > 1500*time.Millisecond {
+		t.Fatalf("expected ttl in (1s,1.5s], got %v", ttl)
+	}
+}
+
+func TestMysqlUpgradeSchema(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+	tbl := "cache_upgrade_" + time.Now().Format("150405")
+	defer func() {
+		for _, t := range []string{tbl + "_hash", tbl + "_list", tbl + "_zset", tbl} {
+			db.Exec("DROP TABLE IF EXISTS " + t)
+		}
+	}()
+
+	// Pre-nanosecond schema
+	_, err := db.Exec(`CREATE TABLE ` + tbl + ` (
+		k varchar(127) NOT NULL DEFAULT '',
+		v blob,
+		createdAt bigint NOT NULL DEFAULT 0,
+		expiredAt bigint NOT NULL DEFAULT 0,
+		PRIMARY KEY (k),
+		KEY idx_expiredAt (expiredAt)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := time.Now().Unix()
+	db.Exec(`INSERT INTO `+tbl+` (k, v, createdAt, expiredAt) VALUES (?, ?, ?, ?), (?, ?, ?, ?)`,
+		"ttl", []byte("a"), sec, sec+60, "forever", []byte("b"), sec, -1)
+
+	c, err := New(db, tbl, WithSchemaUpgrade(), WithNoExpireCheck())
+	if err != nil {
+		t.Fatalf("New with upgrade: %v", err)
+	}
+	defer c.Close(ctx)
+	// re-running is a no-op
+	if err := UpgradeSchema(ctx, db, tbl); err != nil {
+		t.Fatal(err)
+	}
+
+	ttl, err := c.TTL(ctx, "ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 60 {
+		t.Fatalf("expected ttl in (0,60], got %d", ttl)
+	}
+	ttl, err = c.TTL(ctx, "forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != -1 {
+		t.Fatalf("expected -1, got %d", ttl)
+	}
+	if _, version, err := c.GetVersion(ctx, "forever"); err != nil || version != 0 {
+		t.Fatalf("expected version 0 for an upgraded row, got %d, %v", version, err)
+	}
+	if ok, err := c.CompareAndSwap(ctx, "forever", 0, "c", cache.NoExpiration); !ok || err != nil {
+		t.Fatalf("expected the swap to succeed, got %v, %v", ok, err)
+	}
+	if n, err := c.RPush(ctx, "list", "x"); err != nil || n != 1 {
+		t.Fatalf("expected lists to work on an upgraded table, got %d, %v", n, err)
+	}
+}
+
+func TestMysqlWithFakeClock(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	c.PutTTL(ctx, "fc_k", []byte("v"), time.Minute)
+	if ttl, _ := c.TTLDuration(ctx, "fc_k"); ttl != time.Minute {
+		t.Fatalf("expected ttl 1m, got %v", ttl)
+	}
+	clk.Advance(time.Minute)
+	if _, err := c.Get(ctx, "fc_k"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey after advancing, got %v", err)
+	}
+}
+
+// ============================================================================
+// PutBatch / WriteBehind
+// ============================================================================
+
+func TestMysqlPutBatch(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	c, err := New(db, testTable, WithAutoCreateTable(), WithNoExpireCheck(), WithBatchSize(2))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	c.Put(ctx, "pb_k1", "old")
+	items := []cache.BatchItem{
+		{Key: "pb_k1", Value: "v1", TTL: cache.NoExpiration},
+		{Key: "pb_k2", Value: map[string]int{"a": 1}, TTL: time.Minute},
+		{Key: 3, Value: []byte("v3"), TTL: time.Hour},
+	}
+	if err := c.PutBatch(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "pb_k1"); string(v.([]byte)) != "v1" {
+		t.Fatalf("expected the upsert to replace pb_k1, got %s", v)
+	}
+	if v, _ := c.Get(ctx, "pb_k2"); string(v.([]byte)) != `{"a":1}` {
+		t.Fatalf("expected a JSON value, got %s", v)
+	}
+	if ttl, _ := c.TTL(ctx, 3); ttl != 3600 {
+		t.Fatalf("expected ttl 3600 for the third batch, got %d", ttl)
+	}
+}
+
+func TestMysqlWriteBehind(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	w := cache.NewWriteBehind(c, cache.WriteBehindConfig{Interval: time.Hour})
+	for i := 0; i < 10; i++ {
+		w.PutEx(ctx, "wb_k", i, 60)
+	}
+	if v, _ := w.Get(ctx, "wb_k"); string(v.([]byte)) != "9" {
+		t.Fatalf("expected the pending write, got %s", v)
+	}
+	if _, err := c.Get(ctx, "wb_k"); err != cache.ErrNoKey {
+		t.Fatalf("expected nothing written before Flush, got %v", err)
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "wb_k"); string(v.([]byte)) != "9" {
+		t.Fatalf("expected the last write after Flush, got %s", v)
+	}
+}
+
+func TestMysqlMulti(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	c, err := New(db, testTable, WithAutoCreateTable(), WithNoExpireCheck(), WithBatchSize(2))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	kvs := []cache.KeyValue{{Key: "m_a", Value: "1"}, {Key: "m_b", Value: "2"}, {Key: "m_c", Value: "3"}}
+	if err := c.PutMultiEx(ctx, kvs, 60); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.GetMulti(ctx, []interface{}{"m_a", "missing", "m_c", "m_a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res[0].Found || string(res[0].Value.([]byte)) != "1" || res[1].Found || !res[2].Found || !res[3].Found {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if ttl, _ := c.TTL(ctx, "m_b"); ttl != 60 {
+		t.Fatalf("expected ttl 60, got %d", ttl)
+	}
+	n, err := c.DelMulti(ctx, []interface{}{"m_a", "m_b", "m_c", "missing"})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 deleted, got %d, %v", n, err)
+	}
+}
+
+// ============================================================================
+// Conditional writes
+// ============================================================================
+
+func TestMysqlConditional(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+	var events []cache.ExpireEvent
+	var mu sync.Mutex
+	c.ExpireEventHandler(func(ev cache.ExpireEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+
+	if ok, err := c.PutIfPresent(ctx, "cw_k", "a", cache.NoExpiration); ok || err != nil {
+		t.Fatalf("expected no write to a missing key, got %v, %v", ok, err)
+	}
+	if ok, err := c.PutIfAbsent(ctx, "cw_k", "a", time.Second); !ok || err != nil {
+		t.Fatalf("expected the first PutIfAbsent to win, got %v, %v", ok, err)
+	}
+	if ok, _ := c.PutIfAbsent(ctx, "cw_k", "b", cache.NoExpiration); ok {
+		t.Fatal("expected PutIfAbsent to keep the existing value")
+	}
+	v, v1, err := c.GetVersion(ctx, "cw_k")
+	if err != nil || string(v.([]byte)) != "a" || v1 != 1 {
+		t.Fatalf("expected a at version 1, got %v, %d, %v", v, v1, err)
+	}
+	if ok, err := c.CompareAndSwap(ctx, "cw_k", v1, "c", time.Second); !ok || err != nil {
+		t.Fatalf("expected the swap to succeed, got %v, %v", ok, err)
+	}
+	if ok, err := c.CompareAndSwap(ctx, "cw_k", v1, "d", time.Second); ok || err != nil {
+		t.Fatalf("expected a stale version to fail, got %v, %v", ok, err)
+	}
+	if ok, _ := c.PutIfPresent(ctx, "cw_k", "e", time.Second); !ok {
+		t.Fatal("expected PutIfPresent to replace the value")
+	}
+
+	clk.Advance(2 * time.Second)
+	if _, err := c.CompareAndSwap(ctx, "cw_k", 3, "f", cache.NoExpiration); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey for an expired key, got %v", err)
+	}
+	if ok, _ := c.PutIfPresent(ctx, "cw_k", "f", cache.NoExpiration); ok {
+		t.Fatal("expected no write to an expired key")
+	}
+	if ok, _ := c.PutIfAbsent(ctx, "cw_k", "g", cache.NoExpiration); !ok {
+		t.Fatal("expected PutIfAbsent to replace an expired key")
+	}
+	if v, _ := c.Get(ctx, "cw_k"); string(v.([]byte)) != "g" {
+		t.Fatalf("expected g, got %v", v)
+	}
+
+	c.Del(ctx, "cw_k")
+	c.Close(ctx) // Drain the dispatcher
+	mu.Lock()
+	defer mu.Unlock()
+	var reasons []cache.ExpireReason
+	for _, ev := range events {
+		reasons = append(reasons, ev.Reason)
+	}
+	if len(reasons) != 4 || reasons[0] != cache.ReasonReplaced || reasons[1] != cache.ReasonReplaced ||
+		reasons[2] != cache.ReasonExpired || reasons[3] != cache.ReasonDeleted {
+		t.Fatalf("unexpected events %v", reasons)
+	}
+}
+
+// ============================================================================
+// Counters
+// ============================================================================
+
+func TestMysqlCounter(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	if n, err := cache.Incr(ctx, c, "ctr_n", time.Minute); err != nil || n != 1 {
+		t.Fatalf("expected a missing counter to start at 1, got %d, %v", n, err)
+	}
+	if n, _ := c.IncrBy(ctx, "ctr_n", 10, time.Hour); n != 11 {
+		t.Fatalf("expected 11, got %d", n)
+	}
+	if ttl, _ := c.TTLDuration(ctx, "ctr_n"); ttl != time.Minute {
+		t.Fatalf("expected the counter to keep its expiration, got %v", ttl)
+	}
+	var n int64
+	if err := c.Scan(ctx, "ctr_n", cache.Int64Scanner(&n)); err != nil || n != 11 {
+		t.Fatalf("expected to scan 11, got %d, %v", n, err)
+	}
+	clk.Advance(2 * time.Minute)
+	if n, _ := cache.Decr(ctx, c, "ctr_n", cache.NoExpiration); n != -1 {
+		t.Fatalf("expected an expired counter to restart, got %d", n)
+	}
+
+	c.Put(ctx, "ctr_i", 41)
+	if n, err := cache.Incr(ctx, c, "ctr_i", cache.NoExpiration); err != nil || n != 42 {
+		t.Fatalf("expected 42, got %d, %v", n, err)
+	}
+	if f, err := c.IncrByFloat(ctx, "ctr_i", 0.5, cache.NoExpiration); err != nil || f != 42.5 {
+		t.Fatalf("expected 42.5, got %v, %v", f, err)
+	}
+	c.Put(ctx, "ctr_s", "abc")
+	if _, err := c.IncrBy(ctx, "ctr_s", 1, cache.NoExpiration); !errors.Is(err, cache.ErrNotNumber) {
+		t.Fatalf("expected ErrNotNumber, got %v", err)
+	}
+	c.Put(ctx, "ctr_neg", "-5")
+	if n, err := c.IncrBy(ctx, "ctr_neg", -3, cache.NoExpiration); err != nil || n != -8 {
+		t.Fatalf("expected -8, got %d, %v", n, err)
+	}
+	c.Put(ctx, "ctr_big", int64(math.MaxInt64-1))
+	if n, err := c.IncrBy(ctx, "ctr_big", 1, cache.NoExpiration); err != nil || n != math.MaxInt64 {
+		t.Fatalf("expected MaxInt64, got %d, %v", n, err)
+	}
+	if _, err := c.IncrBy(ctx, "ctr_big", 1, cache.NoExpiration); !errors.Is(err, cache.ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, err := cache.Incr(ctx, c, "ctr_c", cache.NoExpiration); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.Scan(ctx, "ctr_c", cache.Int64Scanner(&n)); err != nil || n != 100 {
+		t.Fatalf("expected 100, got %d, %v", n, err)
+	}
+}
+
+// ============================================================================
+// Hashes
+// ============================================================================
+
+func TestMysqlHash(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	if n, err := c.HSet(ctx, "h_u", map[string]interface{}{"name": "ann", "age": 30}); err != nil || n != 2 {
+		t.Fatalf("expected 2 fields added, got %d, %v", n, err)
+	}
+	if n, _ := c.HSet(ctx, "h_u", map[string]interface{}{"name": "bob", "city": "x"}); n != 1 {
+		t.Fatalf("expected 1 field added, got %d", n)
+	}
+	if v, err := c.HGet(ctx, "h_u", "name"); err != nil || string(v.([]byte)) != "bob" {
+		t.Fatalf("expected bob, got %v, %v", v, err)
+	}
+	if _, err := c.HGet(ctx, "h_u", "missing"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey for a missing field, got %v", err)
+	}
+	if n, err := c.HIncrBy(ctx, "h_u", "age", 2); err != nil || n != 32 {
+		t.Fatalf("expected 32, got %d, %v", n, err)
+	}
+	if n, _ := c.HLen(ctx, "h_u"); n != 3 {
+		t.Fatalf("expected 3 fields, got %d", n)
+	}
+	all, err := c.HGetAll(ctx, "h_u")
+	if err != nil || len(all) != 3 || string(all["age"].([]byte)) != "32" {
+		t.Fatalf("unexpected fields %v, %v", all, err)
+	}
+	if n, _ := c.HDel(ctx, "h_u", "name", "age", "city", "missing"); n != 3 {
+		t.Fatalf("expected 3 fields deleted, got %d", n)
+	}
+	if _, err := c.HGetAll(ctx, "h_u"); err != cache.ErrNoKey {
+		t.Fatalf("expected deleting the last field to delete the key, got %v", err)
+	}
+
+	// Key-level TTL; expired hashes start over.
+	c.HSet(ctx, "h_e", map[string]interface{}{"a": 1, "b": 2})
+	c.Expire(ctx, "h_e", 1)
+	clk.Advance(2 * time.Second)
+	if n, _ := c.HLen(ctx, "h_e"); n != 0 {
+		t.Fatalf("expected an expired hash to be empty, got %d", n)
+	}
+	if n, _ := c.HSet(ctx, "h_e", map[string]interface{}{"a": 1}); n != 1 {
+		t.Fatalf("expected the expired fields to be gone, got %d added", n)
+	}
+	if n, _ := c.HLen(ctx, "h_e"); n != 1 {
+		t.Fatalf("expected 1 field, got %d", n)
+	}
+
+	checkKindWrites(t, c, db, kindHash, "hash", func(k string) error {
+		_, err := c.HSet(ctx, k, map[string]interface{}{"a": 1, "b": 2})
+		return err
+	})
+
+	c.Put(ctx, "h_s", "value")
+	if _, err := c.HSet(ctx, "h_s", map[string]interface{}{"f": 1}); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.HGet(ctx, "h_s", "f"); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+// checkKindWrites checks that the value writes over a key of kind, made by
+// create, delete its rows in <testTable>_<child>, and that a failed write
+// creating such a key leaves no empty key behind.
+func checkKindWrites(t *testing.T, c *MysqlCache, db *sql.DB, kind int, child string, create func(k string) error) {
+	t.Helper()
+	ctx := context.Background()
+	childRows := func(k string) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+testTable+"_"+child+" WHERE k=?", k).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	writes := map[string]func(k string) error{
+		"Put": func(k string) error { return c.Put(ctx, k, "v") },
+		"PutBatch": func(k string) error {
+			return c.PutBatch(ctx, []cache.BatchItem{{Key: k, Value: "v", TTL: -1}, {Key: k + "_other", Value: "v", TTL: -1}})
+		},
+		"PutIfPresent": func(k string) error {
+			_, err := c.PutIfPresent(ctx, k, "v", -1)
+			return err
+		},
+		"Tx": func(k string) error {
+			return c.Tx(ctx, k, func(e *cache.Entry) error {
+				e.Value = "v"
+				return nil
+			})
+		},
+	}
+	for name, write := range writes {
+		k := "kw_" + child + "_" + name
+		if err := create(k); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := write(k); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n := childRows(k); n != 0 {
+			t.Fatalf("%s: expected the %s rows to be deleted, got %d", name, child, n)
+		}
+		if v, err := c.Get(ctx, k); err != nil || string(v.([]byte)) != "v" {
+			t.Fatalf("%s: expected v, got %v, %v", name, v, err)
+		}
+		if err := create(k); err != cache.ErrWrongType {
+			t.Fatalf("%s: expected a plain value, got %v", name, err)
+		}
+	}
+
+	boom := errors.New("boom")
+	err := c.kindTx(ctx, "kw_failed", kind, true, func(tx *sql.Tx, r *row, exists bool) error { return boom })
+	if err != boom {
+		t.Fatalf("expected boom, got %v", err)
+	}
+	if _, err := c.TTL(ctx, "kw_failed"); err != cache.ErrNoKey {
+		t.Fatalf("expected a failed write not to create the key, got %v", err)
+	}
+}
+
+func TestMysqlList(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	if n, err := c.RPush(ctx, "l_q", "b", "c"); err != nil || n != 2 {
+		t.Fatalf("expected 2 elements, got %d, %v", n, err)
+	}
+	if n, _ := c.LPush(ctx, "l_q", "a", "z"); n != 4 {
+		t.Fatalf("expected 4 elements, got %d", n)
+	}
+	if v, err := c.LPop(ctx, "l_q"); err != nil || string(v.([]byte)) != "z" {
+		t.Fatalf("expected z, got %v, %v", v, err)
+	}
+	if v, _ := c.RPop(ctx, "l_q"); string(v.([]byte)) != "c" {
+		t.Fatalf("expected c, got %v", v)
+	}
+	c.RPush(ctx, "l_q", "c", "d")
+	vals, err := c.LRange(ctx, "l_q", 1, -2)
+	if err != nil || len(vals) != 2 || string(vals[0].([]byte)) != "b" || string(vals[1].([]byte)) != "c" {
+		t.Fatalf("unexpected elements %v, %v", vals, err)
+	}
+	if err := c.LTrim(ctx, "l_q", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.LLen(ctx, "l_q"); n != 2 {
+		t.Fatalf("expected 2 elements, got %d", n)
+	}
+	c.LPop(ctx, "l_q")
+	c.LPop(ctx, "l_q")
+	if _, err := c.Get(ctx, "l_q"); err != cache.ErrNoKey {
+		t.Fatalf("expected popping the last element to delete the key, got %v", err)
+	}
+
+	// Expired lists start over.
+	c.RPush(ctx, "l_e", "old")
+	c.Expire(ctx, "l_e", 1)
+	clk.Advance(2 * time.Second)
+	if _, err := c.LPop(ctx, "l_e"); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	if n, _ := c.RPush(ctx, "l_e", "new"); n != 1 {
+		t.Fatalf("expected the expired elements to be gone, got %d", n)
+	}
+
+	checkKindWrites(t, c, db, kindList, "list", func(k string) error {
+		_, err := c.RPush(ctx, k, "a", "b")
+		return err
+	})
+
+	c.Put(ctx, "l_s", "value")
+	if _, err := c.RPush(ctx, "l_s", 1); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.LPop(ctx, "l_s"); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestMysqlBLPop(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// A second cache stands for another process: its pushes are only seen by polling.
+	other, err := New(db, testTable, WithNoExpireCheck())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		other.Clear(ctx)
+		c.Close(ctx)
+		other.Close(ctx)
+	}()
+
+	const n = 20
+	got := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, err := c.BLPop(ctx, "l_b")
+				if err != nil {
+					if err != cache.ErrClosed {
+						t.Error(err)
+					}
+					return
+				}
+				got <- string(v.([]byte))
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		p := c
+		if i%2 == 1 {
+			p = other
+		}
+		if _, err := p.RPush(ctx, "l_b", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		seen[<-got] = true
+	}
+	if len(seen) != n {
+		t.Fatalf("expected each element to be received once, got %v", seen)
+	}
+	c.Close(ctx)
+	wg.Wait()
+
+	cctx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	if _, err := other.BLPop(cctx, "l_b"); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMysqlZSet(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	if n, err := c.ZAdd(ctx, "z_s", map[string]float64{"a": 3, "b": 1, "c": 2, "d": 2}); err != nil || n != 4 {
+		t.Fatalf("expected 4 members added, got %d, %v", n, err)
+	}
+	if n, _ := c.ZAdd(ctx, "z_s", map[string]float64{"a": 0, "e": 5}); n != 1 {
+		t.Fatalf("expected 1 member added, got %d", n)
+	}
+	if s, err := c.ZScore(ctx, "z_s", "a"); err != nil || s != 0 {
+		t.Fatalf("expected 0, got %v, %v", s, err)
+	}
+	if r, err := c.ZRank(ctx, "z_s", "d"); err != nil || r != 3 {
+		t.Fatalf("expected d after a, b and c, got rank %d, %v", r, err)
+	}
+	got, err := c.ZRangeByScore(ctx, "z_s", 1, 2, 0)
+	if want := []cache.ZMember{{Member: "b", Score: 1}, {Member: "c", Score: 2}, {Member: "d", Score: 2}}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v, %v", want, got, err)
+	}
+	got, _ = c.ZRangeByScore(ctx, "z_s", math.Inf(-1), math.Inf(1), 2)
+	if want := []cache.ZMember{{Member: "a", Score: 0}, {Member: "b", Score: 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if n, _ := c.ZRem(ctx, "z_s", "c", "missing"); n != 1 {
+		t.Fatalf("expected 1 member removed, got %d", n)
+	}
+	got, _ = c.ZPopMin(ctx, "z_s", 2)
+	if want := []cache.ZMember{{Member: "a", Score: 0}, {Member: "b", Score: 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if n, _ := c.ZCard(ctx, "z_s"); n != 2 {
+		t.Fatalf("expected 2 members, got %d", n)
+	}
+	c.ZPopMin(ctx, "z_s", 10)
+	if _, err := c.Get(ctx, "z_s"); err != cache.ErrNoKey {
+		t.Fatalf("expected popping the last member to delete the key, got %v", err)
+	}
+
+	// Key-level TTL; expired sets start over.
+	c.ZAdd(ctx, "z_e", map[string]float64{"old": 1})
+	c.Expire(ctx, "z_e", 1)
+	clk.Advance(2 * time.Second)
+	if _, err := c.ZPopMin(ctx, "z_e", 1); err != cache.ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	if n, _ := c.ZAdd(ctx, "z_e", map[string]float64{"new": 1}); n != 1 {
+		t.Fatalf("expected the expired members to be gone, got %d added", n)
+	}
+	if n, _ := c.ZCard(ctx, "z_e"); n != 1 {
+		t.Fatalf("expected 1 member, got %d", n)
+	}
+
+	if _, err := c.ZAdd(ctx, "z_e", map[string]float64{"x": math.Inf(1)}); err != cache.ErrNotNumber {
+		t.Fatalf("expected ErrNotNumber, got %v", err)
+	}
+	checkKindWrites(t, c, db, kindZSet, "zset", func(k string) error {
+		_, err := c.ZAdd(ctx, k, map[string]float64{"a": 1, "b": 2})
+		return err
+	})
+
+	c.Put(ctx, "z_v", "value")
+	if _, err := c.ZAdd(ctx, "z_v", map[string]float64{"x": 1}); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.ZScore(ctx, "z_v", "x"); err != cache.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestMysqlScanKeys(t *testing.T) {
+	db := getTestDB(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	clk := cache.NewFakeClock(time.Now())
+	c, err := New(db, testTable, WithAutoCreateTable(), WithSchemaUpgrade(), WithNoExpireCheck(), WithClock(clk), WithBatchSize(2))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer func() {
+		c.Clear(ctx)
+		c.Close(ctx)
+	}()
+
+	for i := 0; i < 5; i++ {
+		c.Put(ctx, "user:"+strconv.Itoa(i), "v")
+	}
+	c.Put(ctx, "user_x", "v") // '_' is not a LIKE wildcard here
+	c.Put(ctx, "order:1", "v")
+	c.PutEx(ctx, "user:9", "v", 1)
+	clk.Advance(2 * time.Second)
+
+	var pages int
+	var keys []interface{}
+	opts := cache.ScanOptions{Prefix: "user:", Count: 2}
+	for {
+		page, cursor, err := c.ScanKeys(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		keys = append(keys, page...)
+		if cursor == "" {
+			break
+		}
+		opts.Cursor = cursor
+	}
+	if want := []interface{}{"user:0", "user:1", "user:2", "user:3", "user:4"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v", want, keys)
+	}
+	if pages != 3 {
+		t.Fatalf("expected 3 pages, got %d", pages)
+	}
+
+	keys = nil
+	err = cache.EachKey(ctx, c, cache.ScanOptions{Match: "user?[13]"}, func(k interface{}) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if want := []interface{}{"user:1", "user:3"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expected %v, got %v, %v", want, keys, err)
+	}
+	if _, _, err := c.ScanKeys(ctx, cache.ScanOptions{Match: "user["}); err != cache.ErrBadPattern {
+		t.Fatalf("expected ErrBadPattern, got %v", err)
+	}
+	if _, _, err := c.ScanKeys(ctx, cache.ScanOptions{Cursor: "1:zz"}); err != cache.ErrBadCursor {
+		t.Fatalf("expected ErrBadCursor, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.KeyScanner = (*MysqlCache)(nil)
+
+// ScanKeys pages through the primary key like Range, restricted to the keys
+// starting with opts.SearchPrefix by a LIKE range. Keys are filtered again with
+// opts.Matches, as the LIKE follows the collation of the table and may be case
+// insensitive. The cursor encodes the last key read.
+func (c *MysqlCache) ScanKeys(ctx context.Context, opts cache.ScanOptions) ([]interface{}, string, error) {
+	if _, err := cache.MatchGlob(opts.Match, ""); err != nil {
+		return nil, "", err
+	}
+	var after string
+	if opts.Cursor != "" {
+		b, err := hex.DecodeString(opts.Cursor[1:])
+		if err != nil || opts.Cursor[0] != 'k' {
+			return nil, "", cache.ErrBadCursor
+		}
+		after = string(b)
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	pageSize := count
+	if pageSize < c.batchSize {
+		pageSize = c.batchSize
+	}
+	like := escapeLike(opts.SearchPrefix()) + "%"
+
+	keys := make([]interface{}, 0, count)
+	resume := opts.Cursor != ""
+	for {
+		q, args := c.sql.scanStartSQL, []interface{}{like, pageSize}
+		if resume {
+			q, args = c.sql.scanNextSQL, []interface{}{after, like, pageSize}
+		}
+		rows, err := c.db.QueryContext(ctx, q, args...)
+		if err != nil {
+			return nil, "", err
+		}
+		n := 0
+		for rows.Next() {
+			var k string
+			var expiredAt int64
+			if err := rows.Scan(&k, &expiredAt); err != nil {
+				rows.Close()
+				return nil, "", err
+			}
+			n++
+			after, resume = k, true // Advance past expired and filtered rows too
+			if c.entryTTL(expiredAt) == 0 || (opts.Filtered() && !opts.Matches(k)) {
+				continue
+			}
+			if keys = append(keys, k); len(keys) == count {
+				break
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, "", err
+		}
+		if len(keys) == count {
+			return keys, "k" + hex.EncodeToString([]byte(after)), nil
+		}
+		if n < pageSize {
+			return keys, "", nil
+		}
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s, for ESCAPE '!'.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
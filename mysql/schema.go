@@ -0,0 +1,101 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpgradeSchema migrates a cache table created by earlier versions to the current schema:
+//
+//   - Tables storing createdAt/expiredAt as Unix seconds get the nanosecond columns
+//     createdAtNs/expiredAtNs. The migration adds the new columns, converts every row,
+//     then drops the old columns (and with them idx_expiredAt). Old binaries cannot
+//     read the upgraded table; stop them before upgrading.
+//   - Tables without the version column used by conditional writes (see
+//     MysqlCache.CompareAndSwap) get it, with version 0 for existing rows. Binaries
+//     that predate it keep working, but their writes do not change versions.
+//   - Tables without the kind column get it. It marks hash, list and sorted set
+//     keys, whose elements live in the child tables <tableName>_hash,
+//     <tableName>_list and <tableName>_zset (see MysqlCache.HSet, MysqlCache.RPush
+//     and MysqlCache.ZAdd), which are created if missing.
+//
+// Each step is safe to re-run, so an interrupted upgrade can simply be retried.
+// Tables already on the current schema are left untouched.
+func UpgradeSchema(ctx context.Context, db *sql.DB, tableName string) error {
+	cols, err := tableColumns(ctx, db, tableName)
+	if err != nil {
+		return fmt.Errorf("mysql cache: upgrade schema: %w", err)
+	}
+	if cols["createdAt"] {
+		if err := upgradeTimestamps(ctx, db, tableName, cols); err != nil {
+			return err
+		}
+	}
+	if !cols["version"] {
+		_, err = db.ExecContext(ctx, fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN version bigint unsigned NOT NULL DEFAULT 0`, tableName))
+		if err != nil {
+			return fmt.Errorf("mysql cache: upgrade schema: add version: %w", err)
+		}
+	}
+	if !cols["kind"] {
+		_, err = db.ExecContext(ctx, fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN kind tinyint NOT NULL DEFAULT 0`, tableName))
+		if err != nil {
+			return fmt.Errorf("mysql cache: upgrade schema: add kind: %w", err)
+		}
+	}
+	for _, q := range childTablesSQL {
+		if _, err = db.ExecContext(ctx, fmt.Sprintf(q, tableName)); err != nil {
+			return fmt.Errorf("mysql cache: upgrade schema: create child table: %w", err)
+		}
+	}
+	return nil
+}
+
+// upgradeTimestamps replaces the second-resolution createdAt/expiredAt columns.
+func upgradeTimestamps(ctx context.Context, db *sql.DB, tableName string, cols map[string]bool) error {
+	if !cols["createdAtNs"] {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN createdAtNs bigint NOT NULL DEFAULT 0,
+			ADD COLUMN expiredAtNs bigint NOT NULL DEFAULT 0`, tableName))
+		if err != nil {
+			return fmt.Errorf("mysql cache: upgrade schema: add columns: %w", err)
+		}
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET createdAtNs=createdAt*1000000000,
+		expiredAtNs=IF(expiredAt<0, -1, expiredAt*1000000000)`, tableName))
+	if err != nil {
+		return fmt.Errorf("mysql cache: upgrade schema: convert rows: %w", err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(
+		`ALTER TABLE %s DROP COLUMN createdAt, DROP COLUMN expiredAt,
+		ADD KEY idx_expiredAtNs (expiredAtNs)`, tableName))
+	if err != nil {
+		return fmt.Errorf("mysql cache: upgrade schema: drop old columns: %w", err)
+	}
+	return nil
+}
+
+// tableColumns returns the column names of tableName in the current database.
+func tableColumns(ctx context.Context, db *sql.DB, tableName string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?`,
+		tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
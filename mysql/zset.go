@@ -0,0 +1,264 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-comm/cache"
+)
+
+var _ cache.ZSetCache = (*MysqlCache)(nil)
+
+// A sorted set key is a row of the main table of kindZSet, which carries the
+// TTL of the set, and one row per member in <tableName>_zset, indexed by
+// (k, score, member). ZPopMin skips the members locked by concurrent pops like
+// LPop, so several processes can consume a schedule of delayed jobs at once.
+
+// ZAdd upserts members in one statement, creating the set if needed.
+func (c *MysqlCache) ZAdd(ctx context.Context, k interface{}, members map[string]float64) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	names := make([]string, 0, len(members))
+	for member, score := range members {
+		if math.IsNaN(score) || math.IsInf(score, 0) {
+			return 0, cache.ErrNotNumber
+		}
+		names = append(names, member)
+	}
+	sort.Strings(names) // Stable lock order for concurrent writers
+
+	added := 0
+	err := c.kindTx(ctx, k, kindZSet, true, func(tx *sql.Tx, r *row, exists bool) error {
+		var sb strings.Builder
+		sb.WriteString(c.sql.zPutSQL)
+		args := make([]interface{}, 0, 3*len(names))
+		keys := make([]interface{}, 0, len(names)+1)
+		keys = append(keys, r.k)
+		for i, member := range names {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(" (?, ?, ?)")
+			args = append(args, r.k, member, members[member])
+			keys = append(keys, member)
+		}
+		sb.WriteString(` ON DUPLICATE KEY UPDATE score=VALUES(score)`)
+
+		existing := 0
+		if exists {
+			q := c.sql.zCountSQL + ` AND member IN` + placeholders(len(names))
+			if err := tx.QueryRowContext(ctx, q, keys...).Scan(&existing); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+		added = len(names) - existing
+		return nil
+	})
+	return added, err
+}
+
+// ZRem deletes members, and the key with its last member.
+func (c *MysqlCache) ZRem(ctx context.Context, k interface{}, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	removed := 0
+	var emptied *row
+	err := c.kindTx(ctx, k, kindZSet, false, func(tx *sql.Tx, r *row, exists bool) error {
+		if !exists {
+			return nil
+		}
+		args := make([]interface{}, 0, len(members)+1)
+		args = append(args, r.k)
+		for _, member := range members {
+			args = append(args, member)
+		}
+		rs, err := tx.ExecContext(ctx, c.sql.zDelSQL+placeholders(len(members)), args...)
+		if err != nil {
+			return err
+		}
+		n, _ := rs.RowsAffected()
+		removed = int(n)
+		var left int
+		if err := tx.QueryRowContext(ctx, c.sql.zCountSQL, r.k).Scan(&left); err != nil {
+			return err
+		}
+		if left == 0 {
+			_, err = tx.ExecContext(ctx, c.sql.delSQL, r.k)
+			emptied = r
+		}
+		return err
+	})
+	if err == nil && emptied != nil {
+		c.notify(emptied.event(k, cache.ReasonDeleted))
+	}
+	return removed, err
+}
+
+func (c *MysqlCache) ZScore(ctx context.Context, k interface{}, member string) (float64, error) {
+	return c.zscore(ctx, c.db, keyToString(k), member)
+}
+
+// ZRank counts the members before member, in a read-only transaction.
+func (c *MysqlCache) ZRank(ctx context.Context, k interface{}, member string) (int, error) {
+	key := keyToString(k)
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	score, err := c.zscore(ctx, tx, key, member)
+	if err != nil {
+		return 0, err
+	}
+	var rank int
+	if err := tx.QueryRowContext(ctx, c.sql.zRankSQL, key, score, score, member).Scan(&rank); err != nil {
+		return 0, err
+	}
+	return rank, nil
+}
+
+// zscore reads the score of member through q.
+func (c *MysqlCache) zscore(ctx context.Context, q rowQuerier, key, member string) (float64, error) {
+	var kind int
+	var expiredAt int64
+	var score sql.NullFloat64
+	err := q.QueryRowContext(ctx, c.sql.zScoreSQL, member, key).Scan(&kind, &expiredAt, &score)
+	if err := c.checkKind(err, kind, kindZSet, expiredAt); err != nil {
+		return 0, err
+	}
+	if !score.Valid {
+		return 0, cache.ErrNoKey
+	}
+	return score.Float64, nil
+}
+
+// ZRangeByScore reads the members in one query. Infinite bounds are clamped to
+// the largest finite doubles, which MySQL can bind.
+func (c *MysqlCache) ZRangeByScore(ctx context.Context, k interface{}, min, max float64, limit int) ([]cache.ZMember, error) {
+	min = math.Max(min, -math.MaxFloat64)
+	max = math.Min(max, math.MaxFloat64)
+	n := int64(limit)
+	if limit <= 0 {
+		n = math.MaxInt64
+	}
+	rows, err := c.db.QueryContext(ctx, c.sql.zRangeSQL, min, max, keyToString(k), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var kind int
+	var expiredAt int64
+	members := []cache.ZMember{}
+	found := false
+	for rows.Next() {
+		var member sql.NullString
+		var score sql.NullFloat64
+		if err := rows.Scan(&kind, &expiredAt, &member, &score); err != nil {
+			return nil, err
+		}
+		found = true
+		if member.Valid {
+			members = append(members, cache.ZMember{Member: member.String, Score: score.Float64})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, cache.ErrNoKey
+	}
+	if err := c.checkKind(nil, kind, kindZSet, expiredAt); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ZPopMin deletes the members it returns in a transaction. Members locked by a
+// concurrent pop are skipped, so fewer than count may be returned while others
+// remain; ErrNoKey is returned if none could be taken.
+func (c *MysqlCache) ZPopMin(ctx context.Context, k interface{}, count int) ([]cache.ZMember, error) {
+	if c.isClosed() {
+		return nil, cache.ErrClosed
+	}
+	key := keyToString(k)
+	if count < 1 {
+		if _, err := c.zcard(ctx, key); err != nil {
+			return nil, err
+		}
+		return []cache.ZMember{}, nil
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, c.sql.zPopSQL, key, c.now(), count)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	r := &row{k: key}
+	popped := []cache.ZMember{}
+	args := []interface{}{key}
+	for rows.Next() {
+		var zm cache.ZMember
+		if err = rows.Scan(&zm.Member, &zm.Score, &r.createdAt, &r.expiredAt); err != nil {
+			break
+		}
+		popped = append(popped, zm)
+		args = append(args, zm.Member)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(popped) == 0 {
+		tx.Rollback()
+		if _, err := c.zcard(ctx, key); err != nil {
+			return nil, err
+		}
+		return nil, cache.ErrNoKey
+	}
+	if _, err = tx.ExecContext(ctx, c.sql.zDelSQL+placeholders(len(popped)), args...); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	c.dropIfEmpty(ctx, k, r, c.sql.zExistsSQL, c.sql.delEmptyZSetSQL)
+	return popped, nil
+}
+
+func (c *MysqlCache) ZCard(ctx context.Context, k interface{}) (int, error) {
+	n, err := c.zcard(ctx, keyToString(k))
+	if err == cache.ErrNoKey {
+		return 0, nil
+	}
+	return n, err
+}
+
+// zcard returns the number of members of the live sorted set under key.
+func (c *MysqlCache) zcard(ctx context.Context, key string) (int, error) {
+	var kind, n int
+	var expiredAt int64
+	err := c.db.QueryRowContext(ctx, c.sql.zCardSQL, key, key).Scan(&kind, &expiredAt, &n)
+	if err := c.checkKind(err, kind, kindZSet, expiredAt); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
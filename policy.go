@@ -0,0 +1,45 @@
+package cache
+
+// Eviction policy names accepted by the "policy" field of the NewMemory config.
+const (
+	PolicyLRU     = "lru"     // Least recently used (default)
+	PolicyTinyLFU = "tinylfu" // W-TinyLFU: frequency-based admission, scan resistant
+)
+
+// evictionPolicy orders the entries of a bounded bucket and picks eviction victims.
+// All methods are called with the bucket lock held.
+type evictionPolicy interface {
+	add(e *Entry)    // e was inserted into the bucket
+	access(e *Entry) // e was read or updated
+	remove(e *Entry) // e left the bucket (deleted, expired or evicted)
+	victim() *Entry  // next entry to evict, nil if the bucket is empty
+	reset()          // all entries were dropped (Clear)
+
+	resized(e *Entry, oldSize int64) // e.size changed in place from oldSize
+}
+
+// newPolicy returns the eviction policy for a bounded bucket.
+// Unknown names fall back to LRU.
+func newPolicy(name string, maxEntries int, maxBytes int64) evictionPolicy {
+	switch name {
+	case PolicyTinyLFU:
+		return newTinyLFU(maxEntries, maxBytes)
+	default:
+		p := &lruPolicy{}
+		p.reset()
+		return p
+	}
+}
+
+// lruPolicy evicts the least recently used entry.
+type lruPolicy struct {
+	list lruList
+}
+
+func (p *lruPolicy) add(e *Entry)    { p.list.pushFront(e) }
+func (p *lruPolicy) access(e *Entry) { p.list.moveToFront(e) }
+func (p *lruPolicy) remove(e *Entry) { p.list.remove(e) }
+func (p *lruPolicy) victim() *Entry  { return p.list.back() }
+func (p *lruPolicy) reset()          { p.list.init() }
+
+func (p *lruPolicy) resized(e *Entry, oldSize int64) {}
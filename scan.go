@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrBadPattern is returned by MatchGlob and ScanKeys for a malformed Match pattern.
+	ErrBadPattern = errors.New("cache: malformed glob pattern")
+
+	// ErrBadCursor is returned by ScanKeys for a cursor it did not return.
+	ErrBadCursor = errors.New("cache: malformed scan cursor")
+)
+
+// KeyScanner is implemented by caches listing their keys a page at a time, like
+// Redis SCAN: Memory walks its buckets one at a time, mysql.MysqlCache pages
+// through the primary key.
+//
+// Each call returns up to Count keys and a cursor to pass back as
+// ScanOptions.Cursor for the next page; the scan is over when the cursor
+// returned is empty. A key present for the whole scan is returned exactly once;
+// keys written or deleted meanwhile may or may not be. Expired keys are skipped.
+type KeyScanner interface {
+	ScanKeys(ctx context.Context, opts ScanOptions) (keys []interface{}, cursor string, err error)
+}
+
+// ScanOptions selects the keys returned by KeyScanner.ScanKeys. Prefix and Match
+// only select string keys; other keys (integers in Memory, for instance) are
+// only returned when both are empty.
+type ScanOptions struct {
+	Prefix string // Keys starting with Prefix
+	Match  string // Keys matching this glob, see MatchGlob
+	Count  int    // Maximum number of keys per call; 0 means 100
+	Cursor string // "" to start, else the cursor returned by the previous call
+}
+
+const defaultScanCount = 100
+
+// count returns the number of keys to return per call.
+func (o ScanOptions) count() int {
+	if o.Count > 0 {
+		return o.Count
+	}
+	return defaultScanCount
+}
+
+// Filtered reports whether o selects keys by Prefix or Match.
+func (o ScanOptions) Filtered() bool {
+	return o.Prefix != "" || o.Match != ""
+}
+
+// Matches reports whether key satisfies Prefix and Match.
+// It returns false for every key if Match is malformed.
+func (o ScanOptions) Matches(key string) bool {
+	if !strings.HasPrefix(key, o.Prefix) {
+		return false
+	}
+	if o.Match == "" {
+		return true
+	}
+	ok, err := MatchGlob(o.Match, key)
+	return ok && err == nil
+}
+
+// SearchPrefix returns a prefix of every key o matches: the longer of Prefix and
+// the literal start of Match. Backends with ordered keys can restrict the scan
+// to it, then filter with Matches.
+func (o ScanOptions) SearchPrefix() string {
+	lit := o.Match
+	if i := strings.IndexAny(lit, `*?[\`); i >= 0 {
+		lit = lit[:i]
+	}
+	if len(lit) > len(o.Prefix) && strings.HasPrefix(lit, o.Prefix) {
+		return lit
+	}
+	return o.Prefix
+}
+
+// MatchGlob reports whether s matches pattern, with the syntax of Redis SCAN
+// MATCH patterns: '*' matches any sequence of characters, '?' a single
+// character, '[abc]', '[a-z]' and '[^a]' a character class, and '\' escapes the
+// next character. Unlike path.Match, '/' is not special. The whole pattern is
+// checked, so ErrBadPattern is returned for a malformed one whatever s is.
+func MatchGlob(pattern, s string) (bool, error) {
+	if err := checkGlob(pattern); err != nil {
+		return false, err
+	}
+	return matchGlob(pattern, s), nil
+}
+
+// checkGlob returns ErrBadPattern if pattern has a dangling '\' or an unclosed class.
+func checkGlob(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i == len(pattern) {
+				return ErrBadPattern
+			}
+		case '[':
+			end := classEnd(pattern, i+1)
+			if end < 0 {
+				return ErrBadPattern
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// classEnd returns the index of the ']' closing the class starting at i, or -1.
+func classEnd(pattern string, i int) int {
+	if i < len(pattern) && (pattern[i] == '^' || pattern[i] == '!') {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++ // A leading ']' is literal
+	}
+	for ; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// matchGlob matches a checked pattern, backtracking to the last '*' on a mismatch.
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	star, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				star, starI = p, i
+				p++
+				continue
+			case '?':
+				_, n := utf8.DecodeRuneInString(s[i:])
+				p, i = p+1, i+n
+				continue
+			case '[':
+				r, n := utf8.DecodeRuneInString(s[i:])
+				end := classEnd(pattern, p+1)
+				if matchClass(pattern[p+1:end], r) {
+					p, i = end+1, i+n
+					continue
+				}
+			default:
+				if c == '\\' {
+					p++
+					c = pattern[p]
+				}
+				if s[i] == c {
+					p, i = p+1, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// Let the last '*' swallow one more character.
+		_, n := utf8.DecodeRuneInString(s[starI:])
+		starI += n
+		p, i = star+1, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass reports whether r is in class, the text between '[' and ']'.
+func matchClass(class string, r rune) bool {
+	negate := false
+	if len(class) > 0 && (class[0] == '^' || class[0] == '!') {
+		negate, class = true, class[1:]
+	}
+	matched := false
+	for len(class) > 0 {
+		lo, n := classRune(class)
+		class = class[n:]
+		hi := lo
+		if len(class) > 1 && class[0] == '-' {
+			hi, n = classRune(class[1:])
+			class = class[1+n:]
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// classRune decodes the possibly escaped rune at the start of class.
+func classRune(class string) (rune, int) {
+	if class[0] == '\\' && len(class) > 1 {
+		r, n := utf8.DecodeRuneInString(class[1:])
+		return r, n + 1
+	}
+	return utf8.DecodeRuneInString(class)
+}
+
+// EachKey calls fn for every key s returns for opts, following the cursors
+// from opts.Cursor. It stops at the first error, and returns it.
+func EachKey(ctx context.Context, s KeyScanner, opts ScanOptions, fn func(k interface{}) error) error {
+	for {
+		keys, cursor, err := s.ScanKeys(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := fn(k); err != nil {
+				return err
+			}
+		}
+		if cursor == "" {
+			return nil
+		}
+		opts.Cursor = cursor
+	}
+}
+
+var _ KeyScanner = (*Memory)(nil)
+
+// ScanKeys walks the buckets in order, locking one at a time. Keys are taken
+// from a bucket in byte order, so the cursor is the index of a bucket and the
+// last key returned from it.
+func (m *Memory) ScanKeys(ctx context.Context, opts ScanOptions) ([]interface{}, string, error) {
+	if err := checkGlob(opts.Match); err != nil {
+		return nil, "", err
+	}
+	idx, after, resume, err := parseScanCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if !m.initialized() {
+		return []interface{}{}, "", nil
+	}
+	if idx >= len(m.buckets) {
+		return nil, "", ErrBadCursor
+	}
+
+	count := opts.count()
+	keys := make([]interface{}, 0, count)
+	for ; idx < len(m.buckets); idx, resume = idx+1, false {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		found := m.buckets[idx].scanKeys(opts, after, resume)
+		sort.Strings(found)
+		if need := count - len(keys); len(found) > need {
+			found = found[:need]
+			for _, k := range found {
+				keys = append(keys, decodeKey(k))
+			}
+			return keys, formatScanCursor(idx, found[len(found)-1]), nil
+		}
+		for _, k := range found {
+			keys = append(keys, decodeKey(k))
+		}
+		if len(keys) == count && idx+1 < len(m.buckets) {
+			return keys, strconv.Itoa(idx + 1), nil
+		}
+	}
+	return keys, "", nil
+}
+
+// scanKeys returns the store keys of the live entries of b selected by opts,
+// only those after after if resume is set.
+func (b *bucket) scanKeys(opts ScanOptions, after string, resume bool) []string {
+	var found []string
+	add := func(k string, e *Entry) {
+		if (resume && k <= after) || e.Expired() {
+			return
+		}
+		if opts.Filtered() {
+			s, ok := decodeKey(k).(string)
+			if !ok || !opts.Matches(s) {
+				return
+			}
+		}
+		found = append(found, k)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.arena != nil {
+		b.arenaEach(func(e *Entry) { add(e.key, e) })
+		return found
+	}
+	for k, e := range b.store {
+		add(k, e)
+	}
+	return found
+}
+
+// formatScanCursor returns the cursor resuming after key in bucket idx. A
+// cursor starting at bucket idx is just the index.
+func formatScanCursor(idx int, key string) string {
+	return strconv.Itoa(idx) + ":" + hex.EncodeToString([]byte(key))
+}
+
+// parseScanCursor returns the bucket a scan resumes at and, if resume is set,
+// the key after which it resumes.
+func parseScanCursor(cursor string) (idx int, after string, resume bool, err error) {
+	if cursor == "" {
+		return 0, "", false, nil
+	}
+	num := cursor
+	if i := strings.IndexByte(cursor, ':'); i >= 0 {
+		key, err := hex.DecodeString(cursor[i+1:])
+		if err != nil {
+			return 0, "", false, ErrBadCursor
+		}
+		num, after, resume = cursor[:i], string(key), true
+	}
+	idx, err = strconv.Atoi(num)
+	if err != nil || idx < 0 {
+		return 0, "", false, ErrBadCursor
+	}
+	return idx, after, resume, nil
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"user:*", "user:1", true},
+		{"user:*", "user:", true},
+		{"user:*", "users:1", false},
+		{"*:name", "user:1/a:name", true},
+		{"user:?", "user:é", true},
+		{"user:?", "user:12", false},
+		{"*a*b*", "xxaxxbxx", true},
+		{"*a*b", "xxaxxbxxa", false},
+		{"h[ae]llo", "hello", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[]]llo", "h]llo", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"", "", true},
+		{"", "a", false},
+	} {
+		if ok, err := MatchGlob(tc.pattern, tc.s); ok != tc.ok || err != nil {
+			t.Errorf("MatchGlob(%q, %q) = %v, %v, want %v", tc.pattern, tc.s, ok, err, tc.ok)
+		}
+	}
+	for _, bad := range []string{"[abc", `abc\`, "a[^"} {
+		if _, err := MatchGlob(bad, "x"); err != ErrBadPattern {
+			t.Errorf("MatchGlob(%q) = %v, want ErrBadPattern", bad, err)
+		}
+	}
+}
+
+func TestScanOptionsSearchPrefix(t *testing.T) {
+	for _, tc := range []struct{ prefix, match, want string }{
+		{"", "", ""},
+		{"user:", "", "user:"},
+		{"", "user:*:name", "user:"},
+		{"user:", "user:1?", "user:1"},
+		{"user:12", "user:*", "user:12"},
+		{"a", "b*", "a"},
+	} {
+		if got := (ScanOptions{Prefix: tc.prefix, Match: tc.match}).SearchPrefix(); got != tc.want {
+			t.Errorf("SearchPrefix(%q, %q) = %q, want %q", tc.prefix, tc.match, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryScanKeys(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 10000}`},
+		"lockfree": {WithLockFreeReads()},
+		"arena":    {WithArena(1 << 20)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := NewFakeClock(time.Unix(1000, 0))
+			c := NewMemory(append(opts, WithClock(clk), WithShards(4))...).(*Memory)
+			defer c.Close(ctx)
+
+			if keys, cursor, err := c.ScanKeys(ctx, ScanOptions{}); err != nil || len(keys) != 0 || cursor != "" {
+				t.Fatalf("expected an empty scan, got %v, %q, %v", keys, cursor, err)
+			}
+			for i := 0; i < 50; i++ {
+				c.Put(ctx, fmt.Sprintf("user:%d", i), "v")
+				c.Put(ctx, fmt.Sprintf("order:%d", i), "v")
+			}
+			c.Put(ctx, 7, "v")
+			c.PutEx(ctx, "user:expired", "v", 1)
+			clk.Advance(2 * time.Second)
+
+			var got []string
+			o := ScanOptions{Prefix: "user:", Count: 7}
+			for pages := 0; ; pages++ {
+				keys, cursor, err := c.ScanKeys(ctx, o)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(keys) > 7 {
+					t.Fatalf("expected at most 7 keys, got %d", len(keys))
+				}
+				for _, k := range keys {
+					got = append(got, k.(string))
+				}
+				if cursor == "" {
+					break
+				}
+				if pages > 100 {
+					t.Fatal("scan does not end")
+				}
+				o.Cursor = cursor
+			}
+			sort.Strings(got)
+			if len(got) != 50 || got[0] != "user:0" || got[49] != "user:9" {
+				t.Fatalf("expected the 50 user keys once, got %d: %v", len(got), got)
+			}
+
+			n := 0
+			EachKey(ctx, c, ScanOptions{Match: "*:4?"}, func(k interface{}) error {
+				n++
+				return nil
+			})
+			if n != 20 {
+				t.Fatalf("expected 20 keys matching *:4?, got %d", n)
+			}
+			var all []interface{}
+			EachKey(ctx, c, ScanOptions{Count: 1}, func(k interface{}) error {
+				all = append(all, k)
+				return nil
+			})
+			if len(all) != 101 {
+				t.Fatalf("expected every live key, got %d", len(all))
+			}
+
+			c.Put(ctx, "", "empty")
+			n = 0
+			EachKey(ctx, c, ScanOptions{Count: 1}, func(k interface{}) error {
+				n++
+				return nil
+			})
+			if n != 102 {
+				t.Fatalf("expected the empty key to be scanned once, got %d keys", n)
+			}
+
+			if _, _, err := c.ScanKeys(ctx, ScanOptions{Cursor: "x"}); err != ErrBadCursor {
+				t.Fatalf("expected ErrBadCursor, got %v", err)
+			}
+			if _, _, err := c.ScanKeys(ctx, ScanOptions{Match: "[a"}); err != ErrBadPattern {
+				t.Fatalf("expected ErrBadPattern, got %v", err)
+			}
+		})
+	}
+}
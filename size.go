@@ -0,0 +1,46 @@
+package cache
+
+import "reflect"
+
+// Sizer estimates the size in bytes of a cached value.
+// Memory only consults it for values that are neither []byte nor string,
+// whose sizes are always taken as their exact length.
+//
+// The estimate drives the maxBytes budget, so it should be cheap and
+// reasonably proportional to the real footprint (e.g. len of an encoded form).
+type Sizer func(v interface{}) int64
+
+// entrySize returns the approximate footprint of an entry: key bytes plus value size.
+func (m *Memory) entrySize(key string, v interface{}) int64 {
+	return int64(len(key)) + m.valueSize(v)
+}
+
+func (m *Memory) valueSize(v interface{}) int64 {
+	switch d := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(d))
+	case []byte:
+		return int64(len(d))
+	case *Hash:
+		return d.measure(m.valueSize)
+	case List:
+		if d.size < 0 {
+			return d.measure(m.valueSize).size
+		}
+		return d.size
+	case *ZSet:
+		return d.size // Under the bucket lock, which updates hold too
+	}
+	if m.sizer != nil {
+		return m.sizer(v)
+	}
+	return shallowSize(v)
+}
+
+// shallowSize is the default Sizer: the in-memory size of the value itself,
+// without following pointers, slices or maps.
+func shallowSize(v interface{}) int64 {
+	return int64(reflect.TypeOf(v).Size())
+}
@@ -0,0 +1,451 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// Snapshot format, version 1. All integers are little-endian or varints.
+//
+//	magic "GCMS", version byte
+//	records: 1, key (uvarint length + bytes), CreatedAt (varint), ExpiredAt (varint),
+//	         value kind (0 []byte, 1 string, 2 codec), [codec name], value (uvarint length + bytes)
+//	end:     0, record count (uvarint), CRC-32 (IEEE) of everything before it (4 bytes)
+//
+// Keys are written in Memory's internal encoding, so every key type is restored as-is.
+const (
+	snapshotMagic   = "GCMS"
+	snapshotVersion = 1
+
+	snapshotEnd   = 0
+	snapshotEntry = 1
+
+	snapshotBytes  = 0
+	snapshotString = 1
+	snapshotCodec  = 2
+
+	maxSnapshotField = 1 << 30 // Upper bound for a single key or value, guards against corrupt lengths
+)
+
+// ErrSnapshotCorrupt is returned by LoadSnapshot when the data is truncated,
+// malformed or fails its checksum. Nothing is loaded in that case.
+var ErrSnapshotCorrupt = errors.New("cache: corrupt snapshot")
+
+// ErrNoCodec is returned when a snapshot contains a value whose type (on save)
+// or codec name (on load) has no registered SnapshotCodec.
+var ErrNoCodec = errors.New("cache: no snapshot codec")
+
+// SnapshotCodec serializes values of one type in snapshots. []byte and string
+// values are written as-is and need no codec.
+type SnapshotCodec struct {
+	Name   string // Identifies the codec in snapshot files; must stay stable across releases
+	Encode func(v interface{}) ([]byte, error)
+	Decode func(data []byte) (interface{}, error)
+}
+
+var snapshotCodecs = struct {
+	sync.RWMutex
+	byType map[reflect.Type]SnapshotCodec
+	byName map[string]SnapshotCodec
+}{byType: make(map[reflect.Type]SnapshotCodec), byName: make(map[string]SnapshotCodec)}
+
+// RegisterSnapshotCodec registers c for values of the same type as sample.
+// It panics if the name is empty, the functions are nil, or the name or type
+// is already registered. Call it from an init function.
+func RegisterSnapshotCodec(sample interface{}, c SnapshotCodec) {
+	if c.Name == "" || c.Encode == nil || c.Decode == nil {
+		panic("cache: RegisterSnapshotCodec needs a name, Encode and Decode")
+	}
+	t := reflect.TypeOf(sample)
+	snapshotCodecs.Lock()
+	defer snapshotCodecs.Unlock()
+	if _, dup := snapshotCodecs.byName[c.Name]; dup {
+		panic("cache: RegisterSnapshotCodec called twice for codec " + c.Name)
+	}
+	if _, dup := snapshotCodecs.byType[t]; dup {
+		panic(fmt.Sprintf("cache: RegisterSnapshotCodec called twice for type %v", t))
+	}
+	snapshotCodecs.byType[t] = c
+	snapshotCodecs.byName[c.Name] = c
+}
+
+// JSONSnapshotCodec returns a codec named name that stores values of sample's
+// type as JSON. Decoded values have the same type as sample, pointer or not.
+//
+//	cache.RegisterSnapshotCodec(&User{}, cache.JSONSnapshotCodec("user", &User{}))
+func JSONSnapshotCodec(name string, sample interface{}) SnapshotCodec {
+	t := reflect.TypeOf(sample)
+	return SnapshotCodec{
+		Name:   name,
+		Encode: json.Marshal,
+		Decode: func(data []byte) (interface{}, error) {
+			if t.Kind() == reflect.Ptr {
+				v := reflect.New(t.Elem())
+				err := json.Unmarshal(data, v.Interface())
+				return v.Interface(), err
+			}
+			v := reflect.New(t)
+			err := json.Unmarshal(data, v.Interface())
+			return v.Elem().Interface(), err
+		},
+	}
+}
+
+func codecForValue(v interface{}) (SnapshotCodec, bool) {
+	snapshotCodecs.RLock()
+	c, ok := snapshotCodecs.byType[reflect.TypeOf(v)]
+	snapshotCodecs.RUnlock()
+	return c, ok
+}
+
+func codecByName(name string) (SnapshotCodec, bool) {
+	snapshotCodecs.RLock()
+	c, ok := snapshotCodecs.byName[name]
+	snapshotCodecs.RUnlock()
+	return c, ok
+}
+
+// snapshotRecord is one entry as it is written to or read from a snapshot.
+type snapshotRecord struct {
+	key       string
+	createdAt int64
+	expiredAt int64
+	value     interface{}
+}
+
+// SaveSnapshot writes all live entries to w, with their CreatedAt and ExpiredAt.
+// Buckets are copied one at a time under their lock, so the snapshot is not an
+// atomic view of the whole cache. Values other than []byte and string need a
+// registered SnapshotCodec; otherwise SaveSnapshot fails with ErrNoCodec.
+func (m *Memory) SaveSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	sw := &snapshotWriter{w: io.MultiWriter(bw, crc)}
+
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion})
+	var count uint64
+	if m.initialized() {
+		for _, b := range m.buckets {
+			for _, rec := range b.snapshot(m.now()) {
+				if err := sw.record(rec); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+	}
+	sw.write([]byte{snapshotEnd})
+	sw.uvarint(count)
+	if sw.err != nil {
+		return sw.err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := bw.Write(sum[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot and stores its entries,
+// replacing existing entries with the same keys. Entries that have expired in
+// the meantime are skipped. The whole snapshot is verified before anything is
+// stored: on error, including ErrSnapshotCorrupt and ErrNoCodec, the cache is unchanged.
+func (m *Memory) LoadSnapshot(r io.Reader) error {
+	recs, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+
+	type pending struct {
+		b   *bucket
+		rec snapshotRecord
+	}
+	now := m.now()
+	valid := make([]pending, 0, len(recs))
+	for _, rec := range recs {
+		if rec.expiredAt >= 0 && rec.expiredAt <= now {
+			continue
+		}
+		_, b := m.bucketFor(RawKey(rec.key))
+		if b.arena != nil {
+			if err := b.checkArenaValue(rec.key, rec.value); err != nil {
+				return err
+			}
+		}
+		valid = append(valid, pending{b, rec})
+	}
+	for _, p := range valid {
+		e := &Entry{CreatedAt: p.rec.createdAt, ExpiredAt: p.rec.expiredAt, Value: p.rec.value, clock: m.clock}
+		p.b.mu.Lock()
+		p.b.set(p.rec.key, e)
+		p.b.unlock()
+	}
+	return nil
+}
+
+// snapshot copies the live entries of the bucket.
+func (b *bucket) snapshot(now int64) []snapshotRecord {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	recs := make([]snapshotRecord, 0, b.len())
+	add := func(e *Entry) {
+		if e.ExpiredAt < 0 || e.ExpiredAt > now {
+			recs = append(recs, snapshotRecord{e.key, e.CreatedAt, e.ExpiredAt, e.Value})
+		}
+	}
+	if b.arena != nil {
+		b.arenaEach(add)
+	}
+	for _, e := range b.store {
+		add(e)
+	}
+	return recs
+}
+
+// snapshotWriter writes snapshot fields, keeping the first error.
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(p)
+	}
+}
+
+func (sw *snapshotWriter) uvarint(v uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], v)])
+}
+
+func (sw *snapshotWriter) varint(v int64) {
+	sw.write(sw.buf[:binary.PutVarint(sw.buf[:], v)])
+}
+
+func (sw *snapshotWriter) field(p []byte) {
+	sw.uvarint(uint64(len(p)))
+	sw.write(p)
+}
+
+func (sw *snapshotWriter) record(rec snapshotRecord) error {
+	sw.write([]byte{snapshotEntry})
+	sw.field(StrToBytes(rec.key))
+	sw.varint(rec.createdAt)
+	sw.varint(rec.expiredAt)
+	switch v := rec.value.(type) {
+	case []byte:
+		sw.write([]byte{snapshotBytes})
+		sw.field(v)
+	case string:
+		sw.write([]byte{snapshotString})
+		sw.field(StrToBytes(v))
+	default:
+		c, ok := codecForValue(v)
+		if !ok {
+			return fmt.Errorf("%w for %T (key %v)", ErrNoCodec, v, decodeKey(rec.key))
+		}
+		data, err := c.Encode(v)
+		if err != nil {
+			return fmt.Errorf("cache: snapshot codec %s: %w", c.Name, err)
+		}
+		sw.write([]byte{snapshotCodec})
+		sw.field([]byte(c.Name))
+		sw.field(data)
+	}
+	return sw.err
+}
+
+// snapshotReader reads snapshot fields and checksums everything it reads.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	c, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{c})
+	}
+	return c, err
+}
+
+func (sr *snapshotReader) read(p []byte) error {
+	if _, err := io.ReadFull(sr.r, p); err != nil {
+		return err
+	}
+	sr.crc.Write(p)
+	return nil
+}
+
+func (sr *snapshotReader) field() ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSnapshotField {
+		return nil, ErrSnapshotCorrupt
+	}
+	p := make([]byte, n)
+	return p, sr.read(p)
+}
+
+// readSnapshot parses and verifies a whole snapshot.
+func readSnapshot(r io.Reader) ([]snapshotRecord, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	var head [len(snapshotMagic) + 1]byte
+	if err := sr.read(head[:]); err != nil || string(head[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrSnapshotCorrupt
+	}
+	if v := head[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, fmt.Errorf("cache: unsupported snapshot version %d", v)
+	}
+
+	var recs []snapshotRecord
+	for {
+		kind, err := sr.ReadByte()
+		if err != nil {
+			return nil, ErrSnapshotCorrupt
+		}
+		if kind == snapshotEnd {
+			break
+		}
+		if kind != snapshotEntry {
+			return nil, ErrSnapshotCorrupt
+		}
+		rec, err := sr.record()
+		if err != nil {
+			if errors.Is(err, ErrNoCodec) {
+				return nil, err
+			}
+			return nil, ErrSnapshotCorrupt
+		}
+		recs = append(recs, rec)
+	}
+
+	count, err := binary.ReadUvarint(sr)
+	if err != nil || count != uint64(len(recs)) {
+		return nil, ErrSnapshotCorrupt
+	}
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil || binary.LittleEndian.Uint32(sum[:]) != want {
+		return nil, ErrSnapshotCorrupt
+	}
+	return recs, nil
+}
+
+func (sr *snapshotReader) record() (snapshotRecord, error) {
+	var rec snapshotRecord
+	key, err := sr.field()
+	if err != nil {
+		return rec, err
+	}
+	rec.key = BytesToStr(key)
+	if rec.createdAt, err = binary.ReadVarint(sr); err != nil {
+		return rec, err
+	}
+	if rec.expiredAt, err = binary.ReadVarint(sr); err != nil {
+		return rec, err
+	}
+	kind, err := sr.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	switch kind {
+	case snapshotBytes:
+		rec.value, err = sr.field()
+	case snapshotString:
+		var v []byte
+		v, err = sr.field()
+		rec.value = BytesToStr(v)
+	case snapshotCodec:
+		var name, data []byte
+		if name, err = sr.field(); err != nil {
+			return rec, err
+		}
+		if data, err = sr.field(); err != nil {
+			return rec, err
+		}
+		c, ok := codecByName(string(name))
+		if !ok {
+			return rec, fmt.Errorf("%w named %q", ErrNoCodec, name)
+		}
+		rec.value, err = c.Decode(data)
+	default:
+		err = ErrSnapshotCorrupt
+	}
+	return rec, err
+}
+
+// ==================== Snapshot files ====================
+
+// saveSnapshotFile writes a snapshot to a temporary file next to path and
+// renames it over path, so a crash never leaves a partial snapshot behind.
+func (m *Memory) saveSnapshotFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err = m.SaveSnapshot(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// loadSnapshotFile loads the snapshot at path. A missing file is not an error.
+func (m *Memory) loadSnapshotFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.LoadSnapshot(f)
+}
+
+// snapshotLoop saves a snapshot to m.snapshotPath every tick until Close.
+func (m *Memory) snapshotLoop(ticker Ticker) {
+	defer ticker.Stop()
+	defer close(m.snapDone)
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-m.done:
+			return
+		}
+		if err := m.saveSnapshotFile(m.snapshotPath); err != nil {
+			m.log("cache: save snapshot:", err)
+		}
+	}
+}
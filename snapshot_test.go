@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type snapshotUser struct {
+	Name string
+	Age  int
+}
+
+func init() {
+	RegisterSnapshotCodec(&snapshotUser{}, JSONSnapshotCodec("test.user", &snapshotUser{}))
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	src := NewMemory(WithClock(clk)).(*Memory)
+	src.Put(ctx, "bytes", []byte{0, 1, 2})
+	src.PutEx(ctx, 42, "string", 60)
+	src.Put(ctx, [2]byte{1, 2}, &snapshotUser{Name: "alice", Age: 30})
+	src.PutEx(ctx, "short", "gone", 1)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(2 * time.Second) // "short" expires between save and load
+	dst := NewMemory(WithClock(clk)).(*Memory)
+	if err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get(ctx, "bytes"); !bytes.Equal(v.([]byte), []byte{0, 1, 2}) {
+		t.Fatalf("unexpected bytes value %#v", v)
+	}
+	if v, ttl, _ := dst.GetAndTTL(ctx, 42); v != "string" || ttl != 58 {
+		t.Fatalf("expected string with ttl 58, got %#v, %d", v, ttl)
+	}
+	if v, _ := dst.Get(ctx, [2]byte{1, 2}); *v.(*snapshotUser) != (snapshotUser{Name: "alice", Age: 30}) {
+		t.Fatalf("unexpected codec value %#v", v)
+	}
+	if _, err := dst.Get(ctx, "short"); err != ErrNoKey {
+		t.Fatalf("expected the expired entry to be skipped, got %v", err)
+	}
+	if st := dst.Stats(); st.Entries != 3 {
+		t.Fatalf("expected 3 entries, got %d", st.Entries)
+	}
+
+	err := dst.Tx(ctx, 42, func(e *Entry) error {
+		if e.CreatedAt != time.Unix(1000, 0).UnixNano() {
+			t.Errorf("expected CreatedAt to be restored, got %d", e.CreatedAt)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotArena(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemory(WithArena(1 << 20))
+	src.Put(ctx, "a", "1")
+	src.Put(ctx, "b", []byte("2"))
+
+	var buf bytes.Buffer
+	if err := src.(*Memory).SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemory(WithArena(1 << 20))
+	if err := dst.(*Memory).LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get(ctx, "a"); v != "1" {
+		t.Fatalf("expected 1, got %#v", v)
+	}
+
+	// Heap snapshots with codec values cannot be loaded into an arena cache.
+	heap := NewMemory()
+	heap.Put(ctx, "u", &snapshotUser{})
+	buf.Reset()
+	heap.(*Memory).SaveSnapshot(&buf)
+	if err := dst.(*Memory).LoadSnapshot(&buf); err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	c.Put(ctx, "n", 1)
+	if err := c.SaveSnapshot(ioutil.Discard); !errors.Is(err, ErrNoCodec) {
+		t.Fatalf("expected ErrNoCodec, got %v", err)
+	}
+	c.Put(ctx, "n", "1")
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	dst := NewMemory().(*Memory)
+	dst.Put(ctx, "n", "old")
+	for i := range data {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0x40
+		if err := dst.LoadSnapshot(bytes.NewReader(corrupt)); err == nil {
+			t.Fatalf("expected an error with byte %d flipped", i)
+		}
+		if err := dst.LoadSnapshot(bytes.NewReader(data[:i])); err != ErrSnapshotCorrupt {
+			t.Fatalf("expected ErrSnapshotCorrupt when truncated to %d bytes, got %v", i, err)
+		}
+	}
+	if v, _ := dst.Get(ctx, "n"); v != "old" {
+		t.Fatalf("expected failed loads to leave the cache unchanged, got %v", v)
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cache-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.snap")
+
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk), WithSnapshotFile(path, time.Minute))
+	c.Put(ctx, "k", "v1")
+
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a periodic snapshot")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Put(ctx, "k", "v2")
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMemory(WithClock(clk), `{"snapshotFile": "`+path+`"}`)
+	if v, _ := restored.Get(ctx, "k"); v != "v2" {
+		t.Fatalf("expected the snapshot saved on Close, got %v", v)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Fatalf("expected no temporary files left, got %v", matches)
+	}
+
+	var logged []interface{}
+	ioutil.WriteFile(path, []byte("garbage"), 0644)
+	c = NewMemory(WithSnapshotFile(path, 0), WithLogger(func(v ...interface{}) { logged = v }))
+	if len(logged) != 2 || logged[1] != ErrSnapshotCorrupt {
+		t.Fatalf("expected the corrupt snapshot to be logged, got %v", logged)
+	}
+	if _, err := c.Get(ctx, "k"); err != ErrNoKey {
+		t.Fatal("expected a cold start after a corrupt snapshot")
+	}
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultL1TTL caps how long Tiered keeps an entry in L1, see WithL1TTL.
+const DefaultL1TTL = time.Minute
+
+type TieredOption func(*Tiered)
+
+// WithL1TTL caps the TTL of entries written to L1, bounding how long L1 may serve
+// a value after another process changed it in L2. ttl <= 0 keeps DefaultL1TTL.
+func WithL1TTL(ttl time.Duration) TieredOption {
+	return func(t *Tiered) {
+		if ttl > 0 {
+			t.l1TTL = ttl
+		}
+	}
+}
+
+// Tiered is a two-level cache: a fast local L1, usually a Memory, in front of an
+// authoritative L2 such as mysql.MysqlCache.
+//
+// Reads try L1 first; an L2 hit fills L1 with the exact L2 TTL (see
+// DurationGetter) capped at the L1 TTL.
+// Writes go to L2 first, then to L1; Del, Expire and Clear reach both levels.
+// L1 keys entries the way L2 does, see KeyNormalizer.
+// TTL, GetAndTTL, ScanAndTTL, Tx and Range always go to L2, so they report
+// authoritative values.
+//
+// If L2 does not preserve values (see ValuePreserver), L1 stores values the way
+// L2 returns them: []byte and string as []byte, anything else JSON-encoded, so
+// Get returns the same type whichever level hits. Use a Scanner such as
+// DecodeScanner to read them back.
+type Tiered struct {
+	l1, l2    Cache
+	l1TTL     time.Duration
+	preserves bool // L2 returns stored values as-is
+}
+
+var _ Cache = (*Tiered)(nil)
+
+// NewTiered returns a Cache reading from l1, then l2. Tiered owns both levels:
+// Close closes them.
+func NewTiered(l1, l2 Cache, opts ...TieredOption) *Tiered {
+	t := &Tiered{l1: l1, l2: l2, l1TTL: DefaultL1TTL}
+	if p, ok := l2.(ValuePreserver); ok {
+		t.preserves = p.PreservesValues()
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// L1 returns the local level.
+func (t *Tiered) L1() Cache { return t.l1 }
+
+// L2 returns the authoritative level.
+func (t *Tiered) L2() Cache { return t.l2 }
+
+// PreservesValues reports whether L2 does. See ValuePreserver.
+func (t *Tiered) PreservesValues() bool {
+	return t.preserves
+}
+
+// l1Key returns the key of k in L1: its form in L2 (see KeyNormalizer), so that
+// keys L2 stores in the same entry, such as 1 and "1" in MySQL, share an L1 entry.
+func (t *Tiered) l1Key(k interface{}) interface{} {
+	return normalizeKey(t.l2, k)
+}
+
+// NormalizeKey returns k in the key form of L2. See KeyNormalizer.
+func (t *Tiered) NormalizeKey(k interface{}) interface{} {
+	return normalizeKey(t.l2, k)
+}
+
+// capTTL returns the TTL for an L1 entry whose L2 entry expires in ttl.
+func (t *Tiered) capTTL(ttl time.Duration) time.Duration {
+	if ttl < 0 || ttl > t.l1TTL {
+		return t.l1TTL
+	}
+	return ttl
+}
+
+// l1Value converts a resolved value to the form L2 returns it in.
+func (t *Tiered) l1Value(v interface{}) (interface{}, error) {
+	if t.preserves {
+		return v, nil
+	}
+	return storedBytes(v)
+}
+
+// fill stores a value read from L2 in L1. Failures only cost a later L2 read.
+func (t *Tiered) fill(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) {
+	t.l1.PutTTL(ctx, t.l1Key(k), v, t.capTTL(ttl))
+}
+
+func (t *Tiered) Get(ctx context.Context, k interface{}) (interface{}, error) {
+	if v, err := t.l1.Get(ctx, t.l1Key(k)); err == nil {
+		return v, nil
+	}
+	v, ttl, err := GetAndTTLDuration(ctx, t.l2, k)
+	if err != nil {
+		return nil, err
+	}
+	t.fill(ctx, k, v, ttl)
+	return v, nil
+}
+
+// GetAndTTL reads from L2, so the TTL is authoritative, and refreshes L1.
+func (t *Tiered) GetAndTTL(ctx context.Context, k interface{}) (interface{}, int64, error) {
+	v, ttl, err := t.GetAndTTLDuration(ctx, k)
+	return v, TTLSeconds(ttl), err
+}
+
+// GetAndTTLDuration is GetAndTTL with the exact TTL. See DurationGetter.
+func (t *Tiered) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	v, ttl, err := GetAndTTLDuration(ctx, t.l2, k)
+	if err != nil {
+		return nil, 0, err
+	}
+	t.fill(ctx, k, v, ttl)
+	return v, ttl, nil
+}
+
+func (t *Tiered) Scan(ctx context.Context, k interface{}, scan Scanner) error {
+	v, err := t.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return scan.Scan(v)
+}
+
+func (t *Tiered) ScanAndTTL(ctx context.Context, k interface{}, scan Scanner) (int64, error) {
+	v, sec, err := t.GetAndTTL(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return sec, scan.Scan(v)
+}
+
+func (t *Tiered) Put(ctx context.Context, k interface{}, v interface{}) error {
+	return t.PutTTL(ctx, k, v, NoExpiration)
+}
+
+func (t *Tiered) PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error {
+	return t.PutTTL(ctx, k, v, SecondsToTTL(sec))
+}
+
+// PutTTL writes to L2, then to L1. A Valuer is resolved once for both levels.
+// If the L2 write fails, the key is dropped from L1 so that it cannot serve a
+// value L2 does not have.
+func (t *Tiered) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if vv, ok := v.(Valuer); ok {
+		var err error
+		if v, err = vv.Value(); err != nil {
+			return err
+		}
+	}
+	if err := t.l2.PutTTL(ctx, k, v, ttl); err != nil {
+		t.l1.Del(ctx, t.l1Key(k))
+		return err
+	}
+	lv, err := t.l1Value(v)
+	if err != nil {
+		t.l1.Del(ctx, t.l1Key(k))
+		return nil // L2 has the value; the next read fills L1
+	}
+	t.l1.PutTTL(ctx, t.l1Key(k), lv, t.capTTL(ttl))
+	return nil
+}
+
+// Del removes the key from both levels and returns the L2 result.
+func (t *Tiered) Del(ctx context.Context, k interface{}) error {
+	err := t.l2.Del(ctx, k)
+	t.l1.Del(ctx, t.l1Key(k))
+	return err
+}
+
+func (t *Tiered) TTL(ctx context.Context, k interface{}) (int64, error) {
+	return t.l2.TTL(ctx, k)
+}
+
+func (t *Tiered) TTLDuration(ctx context.Context, k interface{}) (time.Duration, error) {
+	return t.l2.TTLDuration(ctx, k)
+}
+
+func (t *Tiered) Expire(ctx context.Context, k interface{}, sec int64) error {
+	return t.ExpireIn(ctx, k, SecondsToTTL(sec))
+}
+
+// ExpireIn updates the expiration in L2, then in L1 (capped at the L1 TTL).
+// If L2 fails, the key is dropped from L1.
+func (t *Tiered) ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error {
+	if err := t.l2.ExpireIn(ctx, k, ttl); err != nil {
+		t.l1.Del(ctx, t.l1Key(k))
+		return err
+	}
+	t.l1.ExpireIn(ctx, t.l1Key(k), t.capTTL(ttl))
+	return nil
+}
+
+// Tx runs fn on the L2 entry, then stores the result in L1. Transactions are only
+// atomic with respect to L2.
+func (t *Tiered) Tx(ctx context.Context, k interface{}, fn func(*Entry) error) error {
+	if fn == nil {
+		return errors.New("cache: tx fn is nil")
+	}
+	var v interface{}
+	var ttl time.Duration
+	err := t.l2.Tx(ctx, k, func(e *Entry) error {
+		if err := fn(e); err != nil {
+			return err
+		}
+		v, ttl = e.Value, e.TTLDuration()
+		return nil
+	})
+	if err != nil {
+		t.l1.Del(ctx, t.l1Key(k))
+		return err
+	}
+	if vv, ok := v.(Valuer); ok {
+		if v, err = vv.Value(); err != nil {
+			t.l1.Del(ctx, t.l1Key(k))
+			return nil
+		}
+	}
+	lv, err := t.l1Value(v)
+	if err != nil || ttl == 0 {
+		t.l1.Del(ctx, t.l1Key(k))
+		return nil
+	}
+	t.l1.PutTTL(ctx, t.l1Key(k), lv, t.capTTL(ttl))
+	return nil
+}
+
+// ExpireHandler sets the callback on L2; evictions from L1 are not reported.
+func (t *Tiered) ExpireHandler(h func(k interface{}, v interface{})) {
+	t.l2.ExpireHandler(h)
+}
+
+// ExpireEventHandler sets the callback on L2; evictions from L1 are not reported.
+func (t *Tiered) ExpireEventHandler(h func(ev ExpireEvent)) {
+	t.l2.ExpireEventHandler(h)
+}
+
+// Range iterates over L2.
+func (t *Tiered) Range(ctx context.Context, fn func(k interface{}, v interface{}) error) error {
+	return t.l2.Range(ctx, fn)
+}
+
+// Clear clears both levels and returns the first error.
+func (t *Tiered) Clear(ctx context.Context) error {
+	err := t.l2.Clear(ctx)
+	if err1 := t.l1.Clear(ctx); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// Close closes both levels and returns the first error.
+func (t *Tiered) Close(ctx context.Context) error {
+	err := t.l1.Close(ctx)
+	if err2 := t.l2.Close(ctx); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// bytesCache stores values as bytes like mysql.MysqlCache: Get returns []byte.
+type bytesCache struct {
+	*Memory
+}
+
+func (c bytesCache) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if vv, ok := v.(Valuer); ok {
+		var err error
+		if v, err = vv.Value(); err != nil {
+			return err
+		}
+	}
+	switch d := v.(type) {
+	case []byte:
+	case string:
+		v = []byte(d)
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		v = b
+	}
+	return c.Memory.PutTTL(ctx, k, v, ttl)
+}
+
+func (bytesCache) PreservesValues() bool {
+	return false
+}
+
+func newTestTiered(clk Clock, l1TTL time.Duration) (*Tiered, *Memory, *Memory) {
+	l1 := NewMemory(WithClock(clk)).(*Memory)
+	l2 := NewMemory(WithClock(clk)).(*Memory)
+	return NewTiered(l1, bytesCache{l2}, WithL1TTL(l1TTL)), l1, l2
+}
+
+func TestTieredReadThrough(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c, l1, l2 := newTestTiered(clk, 10*time.Second)
+	defer c.Close(ctx)
+
+	if _, err := c.Get(ctx, "k"); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+	l2.PutEx(ctx, "k", []byte("v"), 60)
+	l2.Put(ctx, "forever", []byte("f"))
+	if v, _ := c.Get(ctx, "k"); string(v.([]byte)) != "v" {
+		t.Fatalf("expected v from L2, got %v", v)
+	}
+	c.Get(ctx, "forever")
+	if ttl, _ := l1.TTL(ctx, "k"); ttl != 10 {
+		t.Fatalf("expected the L1 TTL capped at 10s, got %d", ttl)
+	}
+	if ttl, _ := l1.TTL(ctx, "forever"); ttl != 10 {
+		t.Fatalf("expected non-expiring entries capped at 10s in L1, got %d", ttl)
+	}
+	if ttl, _ := c.TTL(ctx, "k"); ttl != 60 {
+		t.Fatalf("expected the L2 TTL, got %d", ttl)
+	}
+
+	// L1 serves the value until its own TTL runs out.
+	l2.Put(ctx, "k", []byte("changed"))
+	if v, _ := c.Get(ctx, "k"); string(v.([]byte)) != "v" {
+		t.Fatalf("expected the L1 value, got %s", v)
+	}
+	if v, sec, _ := c.GetAndTTL(ctx, "k"); string(v.([]byte)) != "changed" || sec != -1 {
+		t.Fatalf("expected GetAndTTL to read L2, got %s, %d", v, sec)
+	}
+	if v, _ := l1.Get(ctx, "k"); string(v.([]byte)) != "changed" {
+		t.Fatalf("expected GetAndTTL to refresh L1, got %s", v)
+	}
+
+	l2.PutEx(ctx, "short", []byte("s"), 3)
+	c.Get(ctx, "short")
+	if ttl, _ := l1.TTL(ctx, "short"); ttl != 3 {
+		t.Fatalf("expected L1 to keep the shorter L2 TTL, got %d", ttl)
+	}
+}
+
+func TestTieredFillExactTTL(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	l2 := NewMemory(WithClock(clk)).(*Memory)
+	for name, backend := range map[string]Cache{
+		"DurationGetter": l2,
+		"fallback":       struct{ Cache }{l2}, // Hides GetAndTTLDuration
+	} {
+		l1 := NewMemory(WithClock(clk)).(*Memory)
+		c := NewTiered(l1, backend, WithL1TTL(time.Minute))
+		l2.PutTTL(ctx, "k", "v", 1500*time.Millisecond)
+
+		if _, err := c.Get(ctx, "k"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if ttl, _ := l1.TTLDuration(ctx, "k"); ttl != 1500*time.Millisecond {
+			t.Fatalf("%s: expected L1 to expire with L2 after 1.5s, got %v", name, ttl)
+		}
+		l1.Del(ctx, "k")
+		if _, sec, _ := c.GetAndTTL(ctx, "k"); sec != 2 {
+			t.Fatalf("%s: expected GetAndTTL to round up to 2s, got %d", name, sec)
+		}
+		if ttl, _ := l1.TTLDuration(ctx, "k"); ttl != 1500*time.Millisecond {
+			t.Fatalf("%s: expected GetAndTTL to fill L1 with 1.5s, got %v", name, ttl)
+		}
+		l1.Close(ctx)
+	}
+	l2.Close(ctx)
+}
+
+// stringKeyL2 stores keys by their string form, like mysql.MysqlCache.
+type stringKeyL2 struct {
+	*Memory
+}
+
+func (stringKeyL2) NormalizeKey(k interface{}) interface{} { return fmt.Sprint(k) }
+
+func (c stringKeyL2) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	return c.Memory.GetAndTTLDuration(ctx, fmt.Sprint(k))
+}
+
+func (c stringKeyL2) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	return c.Memory.PutTTL(ctx, fmt.Sprint(k), v, ttl)
+}
+
+func (c stringKeyL2) Del(ctx context.Context, k interface{}) error {
+	return c.Memory.Del(ctx, fmt.Sprint(k))
+}
+
+func TestTieredNormalizedKeys(t *testing.T) {
+	ctx := context.Background()
+	c := NewTiered(NewMemory(), stringKeyL2{NewMemory().(*Memory)})
+	defer c.Close(ctx)
+
+	c.Put(ctx, 1, "a")
+	c.Put(ctx, "1", "b") // The same L2 entry: L1 must not keep serving a for 1
+	if v, _ := c.Get(ctx, 1); v != "b" {
+		t.Fatalf("expected b through key 1, got %v", v)
+	}
+	c.Del(ctx, "1")
+	if _, err := c.Get(ctx, 1); err != ErrNoKey {
+		t.Fatalf("expected the delete through \"1\" to reach key 1, got %v", err)
+	}
+}
+
+func TestTieredWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	c, l1, l2 := newTestTiered(clk, time.Minute)
+	defer c.Close(ctx)
+
+	type user struct{ Name string }
+	if err := c.PutEx(ctx, "u", &user{Name: "alice"}, 3600); err != nil {
+		t.Fatal(err)
+	}
+	for _, lvl := range []Cache{l1, l2} {
+		var u user
+		if err := lvl.Scan(ctx, "u", DecodeScanner(&u)); err != nil || u.Name != "alice" {
+			t.Fatalf("expected the JSON value in both levels, got %+v, %v", u, err)
+		}
+	}
+	if ttl, _ := l1.TTL(ctx, "u"); ttl != 60 {
+		t.Fatalf("expected the L1 TTL capped, got %d", ttl)
+	}
+	c.Put(ctx, "s", "str")
+	if v, _ := c.Get(ctx, "s"); string(v.([]byte)) != "str" {
+		t.Fatalf("expected L1 to mirror the L2 representation, got %#v", v)
+	}
+	c.Put(ctx, "ev", EncodeValuer(user{Name: "bob"}))
+	var u user
+	if _, err := c.ScanAndTTL(ctx, "ev", DecodeScanner(&u)); err != nil || u.Name != "bob" {
+		t.Fatalf("expected bob, got %+v, %v", u, err)
+	}
+
+	if err := c.Expire(ctx, "u", 5); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := l1.TTL(ctx, "u"); ttl != 5 {
+		t.Fatalf("expected Expire to reach L1, got %d", ttl)
+	}
+	if ttl, _ := l2.TTL(ctx, "u"); ttl != 5 {
+		t.Fatalf("expected Expire to reach L2, got %d", ttl)
+	}
+
+	err := c.Tx(ctx, "s", func(e *Entry) error {
+		e.Value = append(e.Value.([]byte), '!')
+		return nil
+	})
+	if v, _ := l1.Get(ctx, "s"); err != nil || string(v.([]byte)) != "str!" {
+		t.Fatalf("expected Tx to update L1, got %s, %v", v, err)
+	}
+	errFn := errors.New("fn")
+	if err := c.Tx(ctx, "s", func(*Entry) error { return errFn }); err != errFn {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+
+	if err := c.Del(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l1.Get(ctx, "s"); err != ErrNoKey {
+		t.Fatal("expected Del to reach L1")
+	}
+	if err := c.Del(ctx, "s"); err != ErrNoKey {
+		t.Fatalf("expected the L2 result, got %v", err)
+	}
+
+	// A key that only exists in L1 is dropped when L2 rejects the update.
+	l1.Put(ctx, "stale", "x")
+	if err := c.Expire(ctx, "stale", 10); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey from L2, got %v", err)
+	}
+	if _, err := l1.Get(ctx, "stale"); err != ErrNoKey {
+		t.Fatal("expected the stale L1 entry to be dropped")
+	}
+
+	n := 0
+	c.Range(ctx, func(k, v interface{}) error {
+		n++
+		return nil
+	})
+	if n != 2 { // "u" and "ev"
+		t.Fatalf("expected Range over L2 to see 2 entries, got %d", n)
+	}
+	c.Clear(ctx)
+	if l1.Stats().Entries != 0 || l2.Stats().Entries != 0 {
+		t.Fatal("expected Clear to reach both levels")
+	}
+}
+
+func TestTieredPreservingL2(t *testing.T) {
+	ctx := context.Background()
+	c := NewTiered(NewMemory(), NewMemory())
+	defer c.Close(ctx)
+	if !c.PreservesValues() {
+		t.Fatal("expected Tiered over Memory to preserve values")
+	}
+	c.Put(ctx, "n", 42)
+	if v, _ := c.Get(ctx, "n"); v != 42 {
+		t.Fatalf("expected 42, got %#v", v)
+	}
+}
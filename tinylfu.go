@@ -0,0 +1,279 @@
+package cache
+
+// W-TinyLFU (Einziger, Friedman, Manes: "TinyLFU: A Highly Efficient Cache Admission Policy").
+//
+// New entries enter a small window LRU (1% of capacity). Entries pushed out of the
+// window are candidates for the main region, a segmented LRU split into probation (20%)
+// and protected (80%). When the bucket is full, a candidate is only admitted if a
+// count-min sketch estimates it was accessed more often than main's victim;
+// otherwise the candidate itself is evicted. One-hit wonders from a scan therefore
+// churn through the window instead of flushing the hot set.
+
+// Segments an entry can live in, stored in Entry.seg.
+const (
+	segWindow uint8 = iota
+	segProbation
+	segProtected
+)
+
+type tinyLFU struct {
+	byBytes bool  // Regions are weighted by entry size instead of count
+	window  int64 // Window capacity
+	main    int64 // Main capacity (probation + protected)
+	prot    int64 // Protected capacity
+
+	windowList, probation, protected lruList
+	windowW, probationW, protectedW  int64 // Current weight per segment
+
+	sketch cmSketch
+}
+
+// newTinyLFU sizes the regions from the bucket entry limit, or from the byte
+// budget when only maxBytes is set.
+func newTinyLFU(maxEntries int, maxBytes int64) *tinyLFU {
+	capacity := int64(maxEntries)
+	byBytes := false
+	if capacity <= 0 {
+		capacity = maxBytes
+		byBytes = true
+	}
+	window := capacity / 100
+	if window < 1 {
+		window = 1
+	}
+	p := &tinyLFU{
+		byBytes: byBytes,
+		window:  window,
+		main:    capacity - window,
+		prot:    (capacity - window) * 8 / 10,
+	}
+	// The sketch tracks keys, so with a byte budget assume ~64 byte entries.
+	counters := capacity
+	if byBytes {
+		counters = capacity / 64
+	}
+	p.sketch.init(counters)
+	p.reset()
+	return p
+}
+
+func (p *tinyLFU) weight(e *Entry) int64 {
+	if p.byBytes {
+		return e.size
+	}
+	return 1
+}
+
+func (p *tinyLFU) add(e *Entry) {
+	p.sketch.increment(hashFNV(e.key))
+	e.seg = segWindow
+	p.windowList.pushFront(e)
+	p.windowW += p.weight(e)
+
+	// While main still has room, overflowing window entries move in for free.
+	for p.windowW > p.window && p.windowList.len > 1 {
+		cand := p.windowList.back()
+		if p.probationW+p.protectedW+p.weight(cand) > p.main {
+			break
+		}
+		p.toProbation(cand)
+	}
+}
+
+func (p *tinyLFU) access(e *Entry) {
+	p.sketch.increment(hashFNV(e.key))
+	switch e.seg {
+	case segWindow:
+		p.windowList.moveToFront(e)
+	case segProbation:
+		// A second hit promotes to protected, demoting protected's LRU entries if needed.
+		w := p.weight(e)
+		p.probation.remove(e)
+		p.probationW -= w
+		e.seg = segProtected
+		p.protected.pushFront(e)
+		p.protectedW += w
+		for p.protectedW > p.prot && p.protected.len > 1 {
+			d := p.protected.back()
+			dw := p.weight(d)
+			p.protected.remove(d)
+			p.protectedW -= dw
+			d.seg = segProbation
+			p.probation.pushFront(d)
+			p.probationW += dw
+		}
+	case segProtected:
+		p.protected.moveToFront(e)
+	}
+}
+
+func (p *tinyLFU) remove(e *Entry) {
+	w := p.weight(e)
+	switch e.seg {
+	case segWindow:
+		if e.prev != nil {
+			p.windowW -= w
+		}
+		p.windowList.remove(e)
+	case segProbation:
+		if e.prev != nil {
+			p.probationW -= w
+		}
+		p.probation.remove(e)
+	case segProtected:
+		if e.prev != nil {
+			p.protectedW -= w
+		}
+		p.protected.remove(e)
+	}
+}
+
+// resized moves the weight of e in its segment from oldSize to its new size.
+func (p *tinyLFU) resized(e *Entry, oldSize int64) {
+	if !p.byBytes || e.prev == nil {
+		return
+	}
+	d := e.size - oldSize
+	switch e.seg {
+	case segWindow:
+		p.windowW += d
+	case segProbation:
+		p.probationW += d
+	case segProtected:
+		p.protectedW += d
+	}
+}
+
+// victim resolves pending window overflow first: the window's LRU entry competes
+// with main's victim and the less frequent of the two is returned.
+func (p *tinyLFU) victim() *Entry {
+	for p.windowW > p.window && p.windowList.len > 1 {
+		cand := p.windowList.back()
+		mv := p.mainVictim()
+		if mv == nil || p.probationW+p.protectedW+p.weight(cand) <= p.main {
+			p.toProbation(cand)
+			continue
+		}
+		if p.sketch.estimate(hashFNV(cand.key)) > p.sketch.estimate(hashFNV(mv.key)) {
+			p.toProbation(cand) // Admitted: main's victim makes room
+			return mv
+		}
+		return cand
+	}
+	if mv := p.mainVictim(); mv != nil {
+		return mv
+	}
+	return p.windowList.back()
+}
+
+func (p *tinyLFU) mainVictim() *Entry {
+	if e := p.probation.back(); e != nil {
+		return e
+	}
+	return p.protected.back()
+}
+
+func (p *tinyLFU) toProbation(e *Entry) {
+	w := p.weight(e)
+	p.windowList.remove(e)
+	p.windowW -= w
+	e.seg = segProbation
+	p.probation.pushFront(e)
+	p.probationW += w
+}
+
+func (p *tinyLFU) reset() {
+	p.windowList.init()
+	p.probation.init()
+	p.protected.init()
+	p.windowW, p.probationW, p.protectedW = 0, 0, 0
+	p.sketch.clear()
+}
+
+// cmSketch is a count-min sketch with 4 rows of 4-bit counters, two per byte.
+// Counters are halved after sampleSize increments so old popularity fades out.
+type cmSketch struct {
+	rows       [4][]byte
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func (s *cmSketch) init(counters int64) {
+	width := uint64(16)
+	for int64(width) < counters {
+		width <<= 1
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]byte, width/2)
+	}
+	s.mask = width - 1
+	s.sampleSize = 10 * int(width)
+}
+
+// index returns the counter position of h in row i, derived via double hashing.
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+func (s *cmSketch) get(i int, pos uint64) byte {
+	return (s.rows[i][pos/2] >> ((pos & 1) * 4)) & 0x0f
+}
+
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		pos := s.index(h, i)
+		if s.get(i, pos) < 15 {
+			s.rows[i][pos/2] += 1 << ((pos & 1) * 4)
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.age()
+	}
+}
+
+func (s *cmSketch) estimate(h uint64) byte {
+	min := byte(15)
+	for i := range s.rows {
+		if c := s.get(i, s.index(h, i)); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// age halves every counter (both nibbles of each byte at once).
+func (s *cmSketch) age() {
+	for i := range s.rows {
+		row := s.rows[i]
+		for j := range row {
+			row[j] = (row[j] >> 1) & 0x77
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *cmSketch) clear() {
+	for i := range s.rows {
+		row := s.rows[i]
+		for j := range row {
+			row[j] = 0
+		}
+	}
+	s.additions = 0
+}
+
+// hashFNV computes 64-bit FNV-1a of s with a final avalanche mix,
+// so that the high and low halves are usable as independent hashes.
+func hashFNV(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
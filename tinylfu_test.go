@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCMSketchEstimate(t *testing.T) {
+	var s cmSketch
+	s.init(64)
+
+	h := hashFNV("hot")
+	for i := 0; i < 5; i++ {
+		s.increment(h)
+	}
+	if got := s.estimate(h); got != 5 {
+		t.Fatalf("expected estimate 5, got %d", got)
+	}
+	if got := s.estimate(hashFNV("cold")); got > 1 {
+		t.Fatalf("expected estimate <= 1 for unseen key, got %d", got)
+	}
+
+	// counters saturate at 15
+	for i := 0; i < 20; i++ {
+		s.increment(h)
+	}
+	if got := s.estimate(h); got != 15 {
+		t.Fatalf("expected saturated estimate 15, got %d", got)
+	}
+}
+
+func TestCMSketchAging(t *testing.T) {
+	var s cmSketch
+	s.init(16)
+
+	h := hashFNV("k")
+	for i := 0; i < 8; i++ {
+		s.increment(h)
+	}
+	s.age()
+	if got := s.estimate(h); got != 4 {
+		t.Fatalf("expected estimate 4 after aging, got %d", got)
+	}
+}
+
+func TestTinyLFUBasic(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 2560, "policy": "tinylfu"}`).(*Memory)
+	ctx := context.Background()
+
+	for i := 0; i < 10000; i++ {
+		c.Put(ctx, i, i)
+	}
+	if st := c.Stats(); st.Entries > 2560 {
+		t.Fatalf("expected at most 2560 entries, got %+v", st)
+	}
+
+	c.Put(ctx, "k", "v")
+	v, err := c.Get(ctx, "k")
+	if err != nil || v != "v" {
+		t.Fatalf("expected v, got %v, %v", v, err)
+	}
+	if err := c.Del(ctx, "k"); err != nil {
+		t.Fatal("Del failed:", err)
+	}
+	c.Clear(ctx)
+	if st := c.Stats(); st.Entries != 0 {
+		t.Fatalf("expected empty cache after Clear, got %+v", st)
+	}
+}
+
+func TestTinyLFUByteWeightsFollowResize(t *testing.T) {
+	c := NewMemory(`{"maxBytes": 1000000, "policy": "tinylfu"}`, WithShards(1)).(*Memory)
+	ctx := context.Background()
+
+	c.Put(ctx, "a", "v")
+	c.Put(ctx, "b", "v")
+	c.Get(ctx, "b")
+	c.Tx(ctx, "a", func(e *Entry) error {
+		e.Value = strings.Repeat("x", 1000)
+		return nil
+	})
+	c.Tx(ctx, "b", func(e *Entry) error {
+		e.Value = ""
+		return nil
+	})
+	c.Del(ctx, "a")
+	c.Del(ctx, "b")
+
+	p := c.buckets[0].policy.(*tinyLFU)
+	if p.windowW != 0 || p.probationW != 0 || p.protectedW != 0 {
+		t.Fatalf("expected segment weights back to 0, got %d, %d, %d", p.windowW, p.probationW, p.protectedW)
+	}
+}
+
+func TestTinyLFUKeepsHotKeysDuringScan(t *testing.T) {
+	c := NewMemory(`{"maxEntries": 25600, "policy": "tinylfu"}`) // 100 per bucket
+	ctx := context.Background()
+
+	hot := make([]string, 1000)
+	for i := range hot {
+		hot[i] = "hot_" + strconv.Itoa(i)
+	}
+	for round := 0; round < 5; round++ {
+		for _, k := range hot {
+			if _, err := c.Get(ctx, k); err != nil {
+				c.Put(ctx, k, k)
+			}
+		}
+	}
+	// One-hit-wonder scan, 2x the cache size.
+	for i := 0; i < 51200; i++ {
+		c.Put(ctx, "scan_"+strconv.Itoa(i), i)
+	}
+
+	survived := 0
+	for _, k := range hot {
+		if _, err := c.Get(ctx, k); err == nil {
+			survived++
+		}
+	}
+	if survived < len(hot)*9/10 {
+		t.Fatalf("expected most hot keys to survive the scan, got %d/%d", survived, len(hot))
+	}
+}
+
+func TestTinyLFUHitRatioBeatsLRUOnScans(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping trace replay in short mode")
+	}
+	trace := zipfScanTrace(7, 1.01, 20000, 100000)
+	lru := hitRatio(NewMemory(`{"maxEntries": 1024}`), trace)
+	lfu := hitRatio(NewMemory(`{"maxEntries": 1024, "policy": "tinylfu"}`), trace)
+	if lfu <= lru {
+		t.Fatalf("expected W-TinyLFU hit ratio above LRU, got %.3f <= %.3f", lfu, lru)
+	}
+}
@@ -0,0 +1,213 @@
+//go:build go1.18
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Codec converts typed values to and from the form a backend stores.
+type Codec[V any] interface {
+	Encode(v V) (interface{}, error)
+	Decode(stored interface{}) (V, error)
+}
+
+// PassthroughCodec stores values as-is. Suitable for backends that return
+// stored values unchanged, such as Memory.
+type PassthroughCodec[V any] struct{}
+
+func (PassthroughCodec[V]) Encode(v V) (interface{}, error) {
+	return v, nil
+}
+
+func (PassthroughCodec[V]) Decode(stored interface{}) (V, error) {
+	var zero V
+	if stored == nil {
+		return zero, nil
+	}
+	v, ok := stored.(V)
+	if !ok {
+		return zero, fmt.Errorf("cache: stored value of type %T is not %T", stored, zero)
+	}
+	return v, nil
+}
+
+// JSONCodec stores values as bytes: []byte and string as raw bytes, anything else
+// JSON-encoded. Suitable for byte-oriented backends such as mysql.MysqlCache.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(v V) (interface{}, error) {
+	switch d := any(v).(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	}
+	return json.Marshal(v)
+}
+
+func (JSONCodec[V]) Decode(stored interface{}) (V, error) {
+	var v V
+	var b []byte
+	switch d := stored.(type) {
+	case nil:
+		return v, nil
+	case []byte:
+		b = d
+	case string:
+		b = StrToBytes(d)
+	default:
+		return PassthroughCodec[V]{}.Decode(stored)
+	}
+	switch p := any(&v).(type) {
+	case *[]byte:
+		*p = append([]byte(nil), b...)
+		return v, nil
+	case *string:
+		*p = string(b)
+		return v, nil
+	}
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// TypedCache is a type-safe view of a Cache with keys of type K and values of type V.
+//
+// Usage:
+//
+//	users := cache.NewTypedCache[int64, *User](cache.NewMemory())
+//	u, err := users.Get(ctx, 42)
+type TypedCache[K comparable, V any] struct {
+	c     Cache
+	codec Codec[V]
+}
+
+// NewTypedCache wraps c, choosing the codec automatically: values pass through
+// as-is if c preserves them (see ValuePreserver), otherwise JSONCodec is used.
+func NewTypedCache[K comparable, V any](c Cache) *TypedCache[K, V] {
+	var codec Codec[V] = JSONCodec[V]{}
+	if p, ok := c.(ValuePreserver); ok && p.PreservesValues() {
+		codec = PassthroughCodec[V]{}
+	}
+	return &TypedCache[K, V]{c: c, codec: codec}
+}
+
+// NewTypedCacheWithCodec wraps c using the given codec.
+func NewTypedCacheWithCodec[K comparable, V any](c Cache, codec Codec[V]) *TypedCache[K, V] {
+	return &TypedCache[K, V]{c: c, codec: codec}
+}
+
+// Cache returns the wrapped Cache.
+func (t *TypedCache[K, V]) Cache() Cache {
+	return t.c
+}
+
+// Get retrieves the value stored under k.
+// Returns ErrNoKey if not found or expired.
+func (t *TypedCache[K, V]) Get(ctx context.Context, k K) (V, error) {
+	stored, err := t.c.Get(ctx, k)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return t.codec.Decode(stored)
+}
+
+// Put stores v under k with no expiration.
+func (t *TypedCache[K, V]) Put(ctx context.Context, k K, v V) error {
+	return t.PutTTL(ctx, k, v, NoExpiration)
+}
+
+// PutEx stores v under k with a TTL in seconds; sec < 0 means never expire.
+func (t *TypedCache[K, V]) PutEx(ctx context.Context, k K, v V, sec int64) error {
+	return t.PutTTL(ctx, k, v, SecondsToTTL(sec))
+}
+
+// PutTTL stores v under k with a TTL; ttl < 0 means never expire.
+func (t *TypedCache[K, V]) PutTTL(ctx context.Context, k K, v V, ttl time.Duration) error {
+	stored, err := t.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return t.c.PutTTL(ctx, k, stored, ttl)
+}
+
+// Del removes k. Returns ErrNoKey if not found.
+func (t *TypedCache[K, V]) Del(ctx context.Context, k K) error {
+	return t.c.Del(ctx, k)
+}
+
+// Tx runs fn with exclusive access to the value stored under k.
+// fn may modify *v, which is stored back when fn returns nil, and e to change the TTL.
+func (t *TypedCache[K, V]) Tx(ctx context.Context, k K, fn func(v *V, e *Entry) error) error {
+	return t.c.Tx(ctx, k, func(e *Entry) error {
+		v, err := t.codec.Decode(e.Value)
+		if err != nil {
+			return err
+		}
+		if err := fn(&v, e); err != nil {
+			return err
+		}
+		e.Value, err = t.codec.Encode(v)
+		return err
+	})
+}
+
+// Range iterates over all non-expired entries with typed keys and values.
+// Backends that store keys as strings (Memory, MySQL) have them parsed back into K.
+// The iteration stops at the first error from fn or from decoding.
+func (t *TypedCache[K, V]) Range(ctx context.Context, fn func(k K, v V) error) error {
+	return t.c.Range(ctx, func(k interface{}, stored interface{}) error {
+		key, err := typedKey[K](k)
+		if err != nil {
+			return err
+		}
+		v, err := t.codec.Decode(stored)
+		if err != nil {
+			return err
+		}
+		return fn(key, v)
+	})
+}
+
+// typedKey converts a key returned by Cache.Range back to K: Memory reports integer
+// keys as int64 / uint64, and backends storing keys as strings have them parsed.
+func typedKey[K comparable](k interface{}) (K, error) {
+	if key, ok := k.(K); ok {
+		return key, nil
+	}
+	var key K
+	rv := reflect.ValueOf(&key).Elem()
+	switch d := k.(type) {
+	case int64:
+		if !rv.CanInt() || rv.OverflowInt(d) {
+			if !rv.CanUint() || d < 0 || rv.OverflowUint(uint64(d)) {
+				return key, fmt.Errorf("cache: key %d does not fit %T", d, key)
+			}
+			rv.SetUint(uint64(d))
+			return key, nil
+		}
+		rv.SetInt(d)
+		return key, nil
+	case uint64:
+		if !rv.CanUint() || rv.OverflowUint(d) {
+			return key, fmt.Errorf("cache: key %d does not fit %T", d, key)
+		}
+		rv.SetUint(d)
+		return key, nil
+	case string:
+		if rv.Kind() == reflect.String {
+			rv.SetString(d) // Named string types; fmt.Sscan would stop at whitespace
+			return key, nil
+		}
+		if _, err := fmt.Sscan(d, &key); err != nil {
+			return key, fmt.Errorf("cache: cannot parse key %q as %T: %w", d, key, err)
+		}
+		return key, nil
+	}
+	return key, fmt.Errorf("cache: key of type %T is not %T", k, key)
+}
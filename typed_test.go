@@ -0,0 +1,131 @@
+//go:build go1.18
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+// byteBackend hides Memory's ValuePreserver, standing in for a byte-oriented backend.
+type byteBackend struct {
+	Cache
+}
+
+func TestTypedCacheMemoryPassthrough(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	users := NewTypedCache[int64, *typedUser](mem)
+
+	u := &typedUser{Name: "alice", Age: 30}
+	if err := users.Put(ctx, 1, u); err != nil {
+		t.Fatal(err)
+	}
+	got, err := users.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != u {
+		t.Fatal("expected the stored pointer to pass through unchanged")
+	}
+	if _, err := users.Get(ctx, 2); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	mem.Put(ctx, int64(3), "not a user")
+	if _, err := users.Get(ctx, 3); err == nil {
+		t.Fatal("expected a type mismatch error")
+	}
+}
+
+func TestTypedCacheByteBackend(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	users := NewTypedCache[string, typedUser](byteBackend{mem})
+
+	if err := users.PutEx(ctx, "bob", typedUser{Name: "bob", Age: 40}, 60); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := mem.Get(ctx, "bob")
+	if string(raw.([]byte)) != `{"Name":"bob","Age":40}` {
+		t.Fatalf("expected JSON bytes in the backend, got %v", raw)
+	}
+	got, err := users.Get(ctx, "bob")
+	if err != nil || got.Name != "bob" || got.Age != 40 {
+		t.Fatalf("unexpected value %+v, err %v", got, err)
+	}
+
+	strs := NewTypedCache[string, string](byteBackend{mem})
+	strs.Put(ctx, "s", "hello")
+	if raw, _ := mem.Get(ctx, "s"); string(raw.([]byte)) != "hello" {
+		t.Fatalf("expected raw string bytes, got %v", raw)
+	}
+	if s, err := strs.Get(ctx, "s"); err != nil || s != "hello" {
+		t.Fatalf("expected hello, got %q, err %v", s, err)
+	}
+}
+
+func TestTypedCacheTx(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range map[string]Cache{"memory": NewMemory(), "bytes": byteBackend{NewMemory()}} {
+		counters := NewTypedCache[string, int](c)
+		counters.Put(ctx, "n", 1)
+		err := counters.Tx(ctx, "n", func(v *int, e *Entry) error {
+			*v += 41
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n, _ := counters.Get(ctx, "n"); n != 42 {
+			t.Fatalf("%s: expected 42, got %d", name, n)
+		}
+
+		boom := errors.New("boom")
+		if err := counters.Tx(ctx, "n", func(v *int, e *Entry) error { return boom }); err != boom {
+			t.Fatalf("%s: expected fn error, got %v", name, err)
+		}
+	}
+}
+
+func TestTypedCacheRange(t *testing.T) {
+	ctx := context.Background()
+	scores := NewTypedCache[int, float64](NewMemory())
+	for i := 1; i <= 3; i++ {
+		scores.Put(ctx, i, float64(i)/2)
+	}
+
+	sum := 0.0
+	err := scores.Range(ctx, func(k int, v float64) error {
+		if v != float64(k)/2 {
+			t.Fatalf("key %d: unexpected value %v", k, v)
+		}
+		sum += v
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("expected sum 3, got %v", sum)
+	}
+}
+
+func TestTypedKey(t *testing.T) {
+	type userID string
+	if k, err := typedKey[userID]("a b"); err != nil || k != "a b" {
+		t.Fatalf("expected named string key, got %q, err %v", k, err)
+	}
+	if k, err := typedKey[uint32]("42"); err != nil || k != 42 {
+		t.Fatalf("expected 42, got %d, err %v", k, err)
+	}
+	if _, err := typedKey[int]("x"); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
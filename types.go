@@ -203,6 +203,47 @@ func (s *uint64Scanner) Scan(v interface{}) error {
 	return nil
 }
 
+// Float64Scanner returns a Scanner that scans the cached value into a *float64.
+//
+// Usage: cache.Float64Scanner(&score)
+func Float64Scanner(ptr *float64) Scanner {
+	return &float64Scanner{Ptr: ptr}
+}
+
+type float64Scanner struct {
+	Ptr *float64
+}
+
+func (s *float64Scanner) Scan(v interface{}) error {
+	switch d := v.(type) {
+	case float64:
+		*s.Ptr = d
+	case float32:
+		*s.Ptr = float64(d)
+	case int:
+		*s.Ptr = float64(d)
+	case int64:
+		*s.Ptr = float64(d)
+	case uint64:
+		*s.Ptr = float64(d)
+	case string:
+		f, err := strconv.ParseFloat(d, 64)
+		if err != nil {
+			return err
+		}
+		*s.Ptr = f
+	case []byte:
+		f, err := strconv.ParseFloat(string(d), 64)
+		if err != nil {
+			return err
+		}
+		*s.Ptr = f
+	default:
+		return fmt.Errorf("cache: unsupported type %T for float64Scanner", v)
+	}
+	return nil
+}
+
 // BoolScanner returns a Scanner that scans the cached value into a *bool.
 //
 // Usage: cache.BoolScanner(&enabled)
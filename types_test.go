@@ -99,6 +99,19 @@ func TestInt64ScannerInvalidString(t *testing.T) {
 	}
 }
 
+func TestFloat64Scanner(t *testing.T) {
+	var f float64
+	if err := Float64Scanner(&f).Scan([]byte("2.5")); err != nil || f != 2.5 {
+		t.Fatalf("expected 2.5, got %v, %v", f, err)
+	}
+	if err := Float64Scanner(&f).Scan(int64(3)); err != nil || f != 3 {
+		t.Fatalf("expected 3, got %v, %v", f, err)
+	}
+	if err := Float64Scanner(&f).Scan("x"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
 func TestUint64Scanner(t *testing.T) {
 	c := newCache()
 	ctx := context.Background()
@@ -0,0 +1,166 @@
+package cache
+
+import "sync"
+
+// Hierarchical timing wheel used as an optional expiry index for Memory.
+//
+// Each level has wheelSlots slots; a slot at level L spans wheelSlots^L ticks.
+// Timers due within wheelSlots ticks sit in level 0; farther ones sit in higher
+// levels and cascade down as the wheel turns, so advancing costs O(1) per tick plus
+// O(1) per timer that moves or fires, independent of the total number of entries.
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4 // With a 100ms tick, the wheel covers ~19 days before clamping
+)
+
+// wheelTimer links an entry with a TTL into the wheel.
+type wheelTimer struct {
+	at         int64 // Expiration tick
+	e          *Entry
+	b          *bucket // Owning bucket, needed to take its lock when the timer fires
+	prev, next *wheelTimer
+	list       *timerList
+}
+
+// timerList is an intrusive doubly linked list of timers (one per slot).
+type timerList struct {
+	head *wheelTimer
+}
+
+func (l *timerList) push(t *wheelTimer) {
+	t.list = l
+	t.prev = nil
+	t.next = l.head
+	if l.head != nil {
+		l.head.prev = t
+	}
+	l.head = t
+}
+
+func (l *timerList) remove(t *wheelTimer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		l.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next, t.list = nil, nil, nil
+}
+
+// take detaches and returns all timers of the list.
+func (l *timerList) take() *wheelTimer {
+	h := l.head
+	l.head = nil
+	return h
+}
+
+type timingWheel struct {
+	mu      sync.Mutex
+	tick    int64 // Resolution in nanoseconds
+	current int64 // Last processed tick
+	levels  [wheelLevels][wheelSlots]timerList
+}
+
+func newTimingWheel(tick int64, nowNano int64) *timingWheel {
+	return &timingWheel{tick: tick, current: nowNano / tick}
+}
+
+// schedule (re)registers e to fire at its ExpiredAt, replacing any previous timer.
+// Entries that never expire are just unscheduled.
+// Must be called with the owning bucket lock held.
+func (w *timingWheel) schedule(b *bucket, e *Entry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e.timer != nil && e.timer.list != nil {
+		e.timer.list.remove(e.timer)
+	}
+	if e.ExpiredAt < 0 {
+		e.timer = nil
+		return
+	}
+	t := e.timer
+	if t == nil {
+		t = &wheelTimer{e: e, b: b}
+		e.timer = t
+	}
+	// Round up so that a timer never fires before its deadline.
+	t.at = (e.ExpiredAt + w.tick - 1) / w.tick
+	w.add(t)
+}
+
+// unschedule removes e's timer, if any.
+// Must be called with the owning bucket lock held.
+func (w *timingWheel) unschedule(e *Entry) {
+	if e.timer == nil {
+		return
+	}
+	w.mu.Lock()
+	if e.timer.list != nil {
+		e.timer.list.remove(e.timer)
+	}
+	w.mu.Unlock()
+	e.timer = nil
+}
+
+// add places t in the level whose span covers its remaining ticks.
+// Must be called with w.mu held.
+func (w *timingWheel) add(t *wheelTimer) {
+	at := t.at
+	if at <= w.current {
+		at = w.current + 1 // Already due: fire on the next tick
+	}
+	delta := at - w.current
+	for lvl := 0; lvl < wheelLevels; lvl++ {
+		if delta < int64(1)<<(wheelBits*(lvl+1)) {
+			w.levels[lvl][(at>>(wheelBits*lvl))&wheelMask].push(t)
+			return
+		}
+	}
+	// Beyond the wheel's range: park at the farthest top-level slot,
+	// from where it will cascade and be placed again by its real deadline.
+	lvl := wheelLevels - 1
+	far := w.current + int64(1)<<(wheelBits*wheelLevels) - 1
+	w.levels[lvl][(far>>(wheelBits*lvl))&wheelMask].push(t)
+}
+
+// advance turns the wheel up to nowNano and returns the timers that became due.
+// Returned timers are detached from the wheel.
+func (w *timingWheel) advance(nowNano int64) []*wheelTimer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	target := nowNano / w.tick
+	var due []*wheelTimer
+	for w.current < target {
+		w.current++
+		// Cascade higher levels whose slot boundary was just reached.
+		for lvl := 1; lvl < wheelLevels; lvl++ {
+			if w.current&(int64(1)<<(wheelBits*lvl)-1) != 0 {
+				break
+			}
+			slot := &w.levels[lvl][(w.current>>(wheelBits*lvl))&wheelMask]
+			for t := slot.take(); t != nil; {
+				next := t.next
+				t.prev, t.next, t.list = nil, nil, nil
+				if t.at <= w.current {
+					w.levels[0][w.current&wheelMask].push(t) // Due now: collected below
+				} else {
+					w.add(t)
+				}
+				t = next
+			}
+		}
+		slot := &w.levels[0][w.current&wheelMask]
+		for t := slot.take(); t != nil; {
+			next := t.next
+			t.prev, t.next, t.list = nil, nil, nil
+			due = append(due, t)
+			t = next
+		}
+	}
+	return due
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimingWheelFiresAtDeadline(t *testing.T) {
+	const tick = int64(time.Millisecond)
+	w := newTimingWheel(tick, 0)
+	b := &bucket{}
+
+	// Deadlines spread across all levels, including one beyond the wheel's range.
+	deadlines := []int64{1, 5, 63, 64, 65, 4095, 4096, 300000, 1 << 25}
+	for _, d := range deadlines {
+		w.schedule(b, &Entry{ExpiredAt: d * tick})
+	}
+
+	for _, d := range deadlines {
+		if due := w.advance((d - 1) * tick); len(due) != 0 {
+			t.Fatalf("timer for tick %d fired early at tick %d", due[0].e.ExpiredAt/tick, d-1)
+		}
+		due := w.advance(d * tick)
+		if len(due) != 1 || due[0].e.ExpiredAt != d*tick {
+			t.Fatalf("expected the timer for tick %d to fire, got %d timers", d, len(due))
+		}
+	}
+}
+
+func TestTimingWheelUnschedule(t *testing.T) {
+	w := newTimingWheel(int64(time.Millisecond), 0)
+	b := &bucket{}
+	e := &Entry{ExpiredAt: int64(10 * time.Millisecond)}
+	w.schedule(b, e)
+	w.unschedule(e)
+	if due := w.advance(int64(time.Second)); len(due) != 0 {
+		t.Fatalf("expected no timers, got %d", len(due))
+	}
+}
+
+func TestMemoryWheelExpiration(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk), WithTimingWheel(10*time.Millisecond))
+	ctx := context.Background()
+	defer c.Close(ctx)
+
+	expired := make(chan interface{}, 10)
+	c.ExpireHandler(func(k interface{}, v interface{}) { expired <- k })
+
+	c.PutTTL(ctx, "short", 1, 50*time.Millisecond)
+	c.PutTTL(ctx, "long", 2, time.Hour)
+	c.Put(ctx, "forever", 3)
+
+	clk.Advance(60 * time.Millisecond)
+	select {
+	case k := <-expired:
+		if k != "short" {
+			t.Fatalf("expected short to expire, got %v", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("entry was not expired by the timing wheel")
+	}
+	if st := c.(*Memory).Stats(); st.Entries != 2 {
+		t.Fatalf("expected 2 entries left, got %d", st.Entries)
+	}
+
+	clk.Advance(time.Hour)
+	select {
+	case k := <-expired:
+		if k != "long" {
+			t.Fatalf("expected long to expire, got %v", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("entry was not expired by the timing wheel")
+	}
+	if _, err := c.Get(ctx, "forever"); err != nil {
+		t.Fatalf("expected forever to survive, got %v", err)
+	}
+}
+
+func TestMemoryWheelReschedule(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk), `{"expiry": "wheel", "wheelTick": "10ms"}`)
+	ctx := context.Background()
+	defer c.Close(ctx)
+
+	expired := make(chan interface{}, 10)
+	c.ExpireHandler(func(k interface{}, v interface{}) { expired <- k })
+
+	c.PutTTL(ctx, "extended", 1, 50*time.Millisecond)
+	c.PutTTL(ctx, "deleted", 2, 50*time.Millisecond)
+	c.PutTTL(ctx, "persisted", 3, 50*time.Millisecond)
+	c.ExpireIn(ctx, "extended", 200*time.Millisecond)
+	c.Tx(ctx, "persisted", func(e *Entry) error {
+		e.ExpireIn(NoExpiration)
+		return nil
+	})
+	c.Del(ctx, "deleted")
+	if k := <-expired; k != "deleted" {
+		t.Fatalf("expected delete notification, got %v", k)
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	select {
+	case k := <-expired:
+		t.Fatalf("unexpected expiration of %v", k)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(150 * time.Millisecond)
+	select {
+	case k := <-expired:
+		if k != "extended" {
+			t.Fatalf("expected extended to expire, got %v", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("entry was not expired by the timing wheel")
+	}
+	if _, err := c.Get(ctx, "persisted"); err != nil {
+		t.Fatalf("expected persisted to survive, got %v", err)
+	}
+}
+
+func TestMemoryWheelClear(t *testing.T) {
+	clk := NewFakeClock(time.Now())
+	c := NewMemory(WithClock(clk), WithTimingWheel(10*time.Millisecond))
+	ctx := context.Background()
+	defer c.Close(ctx)
+
+	for i := 0; i < 100; i++ {
+		c.PutTTL(ctx, strconv.Itoa(i), i, time.Second)
+	}
+	c.Clear(ctx)
+	m := c.(*Memory)
+	if due := m.wheel.advance(clk.Now().Add(time.Hour).UnixNano()); len(due) != 0 {
+		t.Fatalf("expected Clear to drop all timers, got %d", len(due))
+	}
+}
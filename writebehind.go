@@ -0,0 +1,552 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by WriteBehind writes dropped under OverflowDrop.
+var ErrQueueFull = errors.New("cache: write-behind queue full")
+
+// BatchItem is one write handed to a BatchWriter.
+type BatchItem struct {
+	Key   interface{}
+	Value interface{}
+	TTL   time.Duration // Negative = never expire
+}
+
+// BatchWriter is implemented by backends that can store many entries in one
+// round trip, such as mysql.MysqlCache with a multi-row upsert. WriteBehind uses
+// it to flush; otherwise it calls PutTTL for each entry.
+type BatchWriter interface {
+	PutBatch(ctx context.Context, items []BatchItem) error
+}
+
+const (
+	defaultWriteBehindBatch    = 100
+	defaultWriteBehindInterval = time.Second
+	defaultWriteBehindPending  = 10000
+)
+
+// WriteBehindConfig configures how a WriteBehind buffers and flushes writes.
+type WriteBehindConfig struct {
+	BatchSize  int                    // Pending keys that trigger a flush, <= 0 uses 100
+	Interval   time.Duration          // Longest time a write stays pending, <= 0 uses 1s
+	MaxPending int                    // Pending keys before Overflow applies, <= 0 uses 10000
+	Overflow   string                 // OverflowBlock (default) or OverflowDrop
+	Clock      Clock                  // Time source for expiration and the flush ticker, nil uses SystemClock
+	Logger     func(v ...interface{}) // Reports background flush errors, nil uses log.Println
+}
+
+// pendingWrite is a buffered write to a key: a put, or a delete if del is set.
+type pendingWrite struct {
+	k         interface{}
+	v         interface{}
+	expiredAt int64
+	del       bool
+}
+
+// live reports whether the write holds a value that has not expired at now.
+func (p *pendingWrite) live(now int64) bool {
+	return !p.del && (p.expiredAt < 0 || p.expiredAt > now)
+}
+
+// WriteBehind buffers writes to a slow Cache and flushes them in the background.
+//
+// Puts are kept in memory, coalescing repeated writes to the same key as the
+// backend sees it (see KeyNormalizer), and are flushed once BatchSize keys are
+// pending or Interval has passed, through BatchWriter when the backend
+// implements it. Reads see pending writes. A full queue blocks writers (OverflowBlock) or drops the write with ErrQueueFull
+// (OverflowDrop). A failed flush keeps the writes pending and is retried; the
+// error is reported to the logger, or returned by Flush.
+//
+// Del and Expire of a key with a pending write are buffered as well; otherwise
+// they go straight to the backend, as do Tx (after flushing the key), Range and
+// Clear (after flushing or dropping everything). Expire events come from the
+// backend when the writes are flushed, so coalesced writes are not reported.
+type WriteBehind struct {
+	dropped   uint64 // Writes dropped on overflow (atomic, first for 64-bit alignment)
+	closed    int32  // Set by Close (atomic)
+	c         Cache
+	cfg       WriteBehindConfig
+	preserves bool // The backend returns stored values as-is
+	mu        sync.Mutex
+	pending   map[string]*pendingWrite
+	flushing  map[string]*pendingWrite // Writes being flushed, still visible to reads
+	space     chan struct{}            // Closed when pending writes move to flushing
+	flushMu   sync.Mutex               // Serializes flushes
+	kick      chan struct{}            // Asks the loop to flush early
+	cancel    context.CancelFunc
+	loopDone  chan struct{} // Closed when loop returns
+}
+
+var _ Cache = (*WriteBehind)(nil)
+
+// NewWriteBehind wraps c and starts the flush loop. WriteBehind owns c: Close
+// flushes pending writes and closes it.
+func NewWriteBehind(c Cache, cfg WriteBehindConfig) *WriteBehind {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultWriteBehindBatch
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultWriteBehindInterval
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultWriteBehindPending
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Println
+	}
+	w := &WriteBehind{
+		c: c, cfg: cfg,
+		pending: make(map[string]*pendingWrite),
+		space:   make(chan struct{}),
+		kick:    make(chan struct{}, 1),
+	}
+	if p, ok := c.(ValuePreserver); ok {
+		w.preserves = p.PreservesValues()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.loopDone = make(chan struct{})
+	go w.loop(ctx, cfg.Clock.NewTicker(cfg.Interval))
+	return w
+}
+
+// Pending returns the number of keys with writes not yet flushed.
+func (w *WriteBehind) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending) + len(w.flushing)
+}
+
+// Dropped returns the number of writes dropped under OverflowDrop.
+func (w *WriteBehind) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// PreservesValues reports whether the backend does. See ValuePreserver.
+func (w *WriteBehind) PreservesValues() bool {
+	return w.preserves
+}
+
+func (w *WriteBehind) isClosed() bool {
+	return atomic.LoadInt32(&w.closed) != 0
+}
+
+func (w *WriteBehind) now() int64 {
+	return w.cfg.Clock.Now().UnixNano()
+}
+
+// key returns the key of buffered writes to k: writes the backend stores under
+// the same key share it, see KeyNormalizer.
+func (w *WriteBehind) key(k interface{}) string {
+	return encodeKey(normalizeKey(w.c, k))
+}
+
+// NormalizeKey returns k in the key form of the backend. See KeyNormalizer.
+func (w *WriteBehind) NormalizeKey(k interface{}) interface{} {
+	return normalizeKey(w.c, k)
+}
+
+// lookup returns the buffered write to key, if any.
+// Must be called with w.mu held.
+func (w *WriteBehind) lookup(key string) (*pendingWrite, bool) {
+	if p, ok := w.pending[key]; ok {
+		return p, true
+	}
+	p, ok := w.flushing[key]
+	return p, ok
+}
+
+// enqueue buffers p under key, replacing any pending write to the key. If the
+// queue is full it waits for a flush, or drops p under OverflowDrop.
+func (w *WriteBehind) enqueue(ctx context.Context, key string, p *pendingWrite) error {
+	for {
+		w.mu.Lock()
+		if _, ok := w.pending[key]; ok || len(w.pending) < w.cfg.MaxPending {
+			w.pending[key] = p
+			n := len(w.pending)
+			w.mu.Unlock()
+			if n >= w.cfg.BatchSize {
+				w.flushSoon()
+			}
+			return nil
+		}
+		space := w.space
+		w.mu.Unlock()
+		if w.cfg.Overflow == OverflowDrop {
+			atomic.AddUint64(&w.dropped, 1)
+			return ErrQueueFull
+		}
+		w.flushSoon()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *WriteBehind) flushSoon() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// buffered returns the value and remaining TTL of a buffered write to k.
+// ok is false if no write to k is buffered.
+func (w *WriteBehind) buffered(k interface{}) (v interface{}, ttl time.Duration, ok bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock() // ExpireIn updates pending writes in place
+	p, ok := w.lookup(w.key(k))
+	if !ok {
+		return nil, 0, false, nil
+	}
+	now := w.now()
+	if !p.live(now) {
+		return nil, 0, true, ErrNoKey
+	}
+	if p.expiredAt < 0 {
+		return p.v, NoExpiration, true, nil
+	}
+	return p.v, time.Duration(p.expiredAt - now), true, nil
+}
+
+func (w *WriteBehind) Get(ctx context.Context, k interface{}) (interface{}, error) {
+	v, _, ok, err := w.buffered(k)
+	if !ok {
+		return w.c.Get(ctx, k)
+	}
+	return v, err
+}
+
+func (w *WriteBehind) GetAndTTL(ctx context.Context, k interface{}) (interface{}, int64, error) {
+	v, ttl, ok, err := w.buffered(k)
+	if !ok {
+		return w.c.GetAndTTL(ctx, k)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, TTLSeconds(ttl), nil
+}
+
+// GetAndTTLDuration returns a buffered write with its exact TTL, or reads
+// through to the backend. See DurationGetter.
+func (w *WriteBehind) GetAndTTLDuration(ctx context.Context, k interface{}) (interface{}, time.Duration, error) {
+	v, ttl, ok, err := w.buffered(k)
+	if !ok {
+		return GetAndTTLDuration(ctx, w.c, k)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, ttl, nil
+}
+
+func (w *WriteBehind) Scan(ctx context.Context, k interface{}, scan Scanner) error {
+	v, err := w.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return scan.Scan(v)
+}
+
+func (w *WriteBehind) ScanAndTTL(ctx context.Context, k interface{}, scan Scanner) (int64, error) {
+	v, ttl, err := w.GetAndTTL(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return ttl, scan.Scan(v)
+}
+
+func (w *WriteBehind) TTL(ctx context.Context, k interface{}) (int64, error) {
+	ttl, err := w.TTLDuration(ctx, k)
+	if err != nil {
+		return 0, err
+	}
+	return TTLSeconds(ttl), nil
+}
+
+func (w *WriteBehind) TTLDuration(ctx context.Context, k interface{}) (time.Duration, error) {
+	_, ttl, ok, err := w.buffered(k)
+	if !ok {
+		return w.c.TTLDuration(ctx, k)
+	}
+	return ttl, err
+}
+
+func (w *WriteBehind) Put(ctx context.Context, k interface{}, v interface{}) error {
+	return w.PutTTL(ctx, k, v, NoExpiration)
+}
+
+func (w *WriteBehind) PutEx(ctx context.Context, k interface{}, v interface{}, sec int64) error {
+	return w.PutTTL(ctx, k, v, SecondsToTTL(sec))
+}
+
+// PutTTL buffers the write. A Valuer is resolved right away; if the backend does
+// not preserve values, the value is kept the way the backend will return it, so
+// reads of pending and flushed writes return the same type.
+func (w *WriteBehind) PutTTL(ctx context.Context, k interface{}, v interface{}, ttl time.Duration) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	var err error
+	if vv, ok := v.(Valuer); ok {
+		if v, err = vv.Value(); err != nil {
+			return err
+		}
+	}
+	if !w.preserves {
+		if v, err = storedBytes(v); err != nil {
+			return err
+		}
+	}
+	now := w.now()
+	return w.enqueue(ctx, w.key(k), &pendingWrite{k: k, v: v, expiredAt: ExpiredAt(now, ttl)})
+}
+
+// Del buffers the delete if the key has a pending write, otherwise deletes it
+// from the backend right away.
+func (w *WriteBehind) Del(ctx context.Context, k interface{}) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	key := w.key(k)
+	w.mu.Lock()
+	p, ok := w.lookup(key)
+	live := ok && p.live(w.now())
+	w.mu.Unlock()
+	if !ok {
+		return w.c.Del(ctx, k)
+	}
+	if err := w.enqueue(ctx, key, &pendingWrite{k: k, del: true}); err != nil {
+		return err
+	}
+	if !live {
+		return ErrNoKey
+	}
+	return nil
+}
+
+func (w *WriteBehind) Expire(ctx context.Context, k interface{}, sec int64) error {
+	return w.ExpireIn(ctx, k, SecondsToTTL(sec))
+}
+
+// ExpireIn updates a pending write to the key, or the backend if there is none.
+func (w *WriteBehind) ExpireIn(ctx context.Context, k interface{}, ttl time.Duration) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	key := w.key(k)
+	w.mu.Lock()
+	p, ok := w.lookup(key)
+	if !ok {
+		w.mu.Unlock()
+		return w.c.ExpireIn(ctx, k, ttl)
+	}
+	now := w.now()
+	if !p.live(now) {
+		w.mu.Unlock()
+		return ErrNoKey
+	}
+	if w.pending[key] == p {
+		p.expiredAt = ExpiredAt(now, ttl) // Not being flushed: update in place
+		w.mu.Unlock()
+		return nil
+	}
+	// The write is being flushed: queue it again with the new deadline.
+	q := *p
+	q.expiredAt = ExpiredAt(now, ttl)
+	w.mu.Unlock()
+	return w.enqueue(ctx, key, &q)
+}
+
+// Tx flushes any pending write to the key, then runs fn on the backend.
+func (w *WriteBehind) Tx(ctx context.Context, k interface{}, fn func(*Entry) error) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	key := w.key(k)
+	w.flushMu.Lock()
+	w.mu.Lock()
+	p, ok := w.pending[key]
+	delete(w.pending, key)
+	w.mu.Unlock()
+	if ok {
+		if failed, err := w.write(ctx, map[string]*pendingWrite{key: p}); len(failed) > 0 {
+			w.requeue(failed)
+			w.flushMu.Unlock()
+			return err
+		}
+	}
+	w.flushMu.Unlock()
+	return w.c.Tx(ctx, k, fn)
+}
+
+func (w *WriteBehind) ExpireHandler(h func(k interface{}, v interface{})) {
+	w.c.ExpireHandler(h)
+}
+
+func (w *WriteBehind) ExpireEventHandler(h func(ev ExpireEvent)) {
+	w.c.ExpireEventHandler(h)
+}
+
+// Range flushes pending writes, then iterates over the backend.
+func (w *WriteBehind) Range(ctx context.Context, fn func(k interface{}, v interface{}) error) error {
+	if err := w.Flush(ctx); err != nil {
+		return err
+	}
+	return w.c.Range(ctx, fn)
+}
+
+// Clear drops pending writes and clears the backend.
+func (w *WriteBehind) Clear(ctx context.Context) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	w.pending = make(map[string]*pendingWrite)
+	close(w.space)
+	w.space = make(chan struct{})
+	w.mu.Unlock()
+	return w.c.Clear(ctx)
+}
+
+// Flush writes all pending writes to the backend and returns the first error.
+// Writes that failed stay pending.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	if len(batch) == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	w.pending = make(map[string]*pendingWrite, len(batch))
+	w.flushing = batch
+	close(w.space) // Wake up writers blocked on a full queue
+	w.space = make(chan struct{})
+	w.mu.Unlock()
+
+	failed, err := w.write(ctx, batch)
+
+	w.mu.Lock()
+	w.flushing = nil
+	w.mu.Unlock()
+	w.requeue(failed)
+	return err
+}
+
+// requeue puts failed writes back unless the key was written again meanwhile.
+func (w *WriteBehind) requeue(failed map[string]*pendingWrite) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, p := range failed {
+		if _, ok := w.pending[key]; !ok {
+			w.pending[key] = p
+		}
+	}
+}
+
+// write stores batch in the backend and returns the writes that failed with the first error.
+// Puts that expired while pending are written as deletes, so that an older value
+// in the backend does not resurface.
+func (w *WriteBehind) write(ctx context.Context, batch map[string]*pendingWrite) (map[string]*pendingWrite, error) {
+	var failed map[string]*pendingWrite
+	var firstErr error
+	fail := func(key string, p *pendingWrite, err error) {
+		if failed == nil {
+			failed = make(map[string]*pendingWrite)
+			firstErr = err
+		}
+		failed[key] = p
+	}
+
+	now := w.now()
+	keys := make([]string, 0, len(batch))
+	items := make([]BatchItem, 0, len(batch))
+	for key, p := range batch {
+		if !p.live(now) {
+			if err := w.c.Del(ctx, p.k); err != nil && err != ErrNoKey {
+				fail(key, p, err)
+			}
+			continue
+		}
+		ttl := NoExpiration
+		if p.expiredAt >= 0 {
+			ttl = time.Duration(p.expiredAt - now)
+		}
+		keys = append(keys, key)
+		items = append(items, BatchItem{Key: p.k, Value: p.v, TTL: ttl})
+	}
+	if bw, ok := w.c.(BatchWriter); ok {
+		for i := 0; i < len(items); i += w.cfg.BatchSize {
+			j := i + w.cfg.BatchSize
+			if j > len(items) {
+				j = len(items)
+			}
+			if err := bw.PutBatch(ctx, items[i:j]); err != nil {
+				for n := i; n < j; n++ {
+					fail(keys[n], batch[keys[n]], err)
+				}
+			}
+		}
+		return failed, firstErr
+	}
+	for i, it := range items {
+		if err := w.c.PutTTL(ctx, it.Key, it.Value, it.TTL); err != nil {
+			fail(keys[i], batch[keys[i]], err)
+		}
+	}
+	return failed, firstErr
+}
+
+func (w *WriteBehind) loop(ctx context.Context, ticker Ticker) {
+	defer ticker.Stop()
+	defer close(w.loopDone)
+	for {
+		select {
+		case <-ticker.C():
+		case <-w.kick:
+		case <-ctx.Done():
+			return
+		}
+		if err := w.Flush(ctx); err != nil && ctx.Err() == nil {
+			w.cfg.Logger("cache: write-behind flush:", err)
+		}
+	}
+}
+
+// Close stops the flush loop, flushes pending writes and closes the backend.
+// If the final flush fails, the backend is still closed and the flush error returned.
+// After Close, writes return ErrClosed. Closing an already closed cache is a no-op.
+func (w *WriteBehind) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		return nil
+	}
+	w.cancel()
+	select {
+	case <-w.loopDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	err := w.Flush(ctx)
+	if cerr := w.c.Close(ctx); err == nil {
+		err = cerr
+	}
+	return err
+}
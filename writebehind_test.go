@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchCache records the batches written to it and can be made to fail.
+type batchCache struct {
+	*Memory
+	mu      sync.Mutex
+	batches [][]BatchItem
+	err     error
+}
+
+func (c *batchCache) PutBatch(ctx context.Context, items []BatchItem) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.batches = append(c.batches, append([]BatchItem(nil), items...))
+	for _, it := range items {
+		c.Memory.PutTTL(ctx, it.Key, it.Value, it.TTL)
+	}
+	return nil
+}
+
+func (c *batchCache) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+}
+
+func (c *batchCache) numBatches() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.batches)
+}
+
+func TestWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	backend := &batchCache{Memory: NewMemory(WithClock(clk)).(*Memory)}
+	w := NewWriteBehind(backend, WriteBehindConfig{Clock: clk, Interval: time.Minute})
+	defer w.Close(ctx)
+
+	for i := 0; i < 5; i++ {
+		w.PutEx(ctx, "k", i, 60)
+	}
+	w.Put(ctx, 1, "one")
+	if v, _ := w.Get(ctx, "k"); v != 4 {
+		t.Fatalf("expected the pending write, got %v", v)
+	}
+	if ttl, _ := w.TTL(ctx, "k"); ttl != 60 {
+		t.Fatalf("expected ttl 60, got %d", ttl)
+	}
+	if _, err := backend.Get(ctx, "k"); err != ErrNoKey {
+		t.Fatal("expected the write to be buffered")
+	}
+	if w.Pending() != 2 {
+		t.Fatalf("expected writes to coalesce into 2 keys, got %d", w.Pending())
+	}
+
+	// Expire and Del of pending keys are buffered too.
+	w.Expire(ctx, "k", 10)
+	if ttl, _ := w.TTL(ctx, "k"); ttl != 10 {
+		t.Fatalf("expected ttl 10, got %d", ttl)
+	}
+	if err := w.Del(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Get(ctx, 1); err != ErrNoKey {
+		t.Fatalf("expected the pending delete to hide the key, got %v", err)
+	}
+	if err := w.Del(ctx, 1); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.numBatches(); n != 1 || len(backend.batches[0]) != 1 {
+		t.Fatalf("expected one batch with one put, got %v", backend.batches)
+	}
+	if v, ttl, _ := backend.GetAndTTL(ctx, "k"); v != 4 || ttl != 10 {
+		t.Fatalf("expected the coalesced write, got %v, %d", v, ttl)
+	}
+	if w.Pending() != 0 {
+		t.Fatalf("expected no pending writes, got %d", w.Pending())
+	}
+
+	// Keys without pending writes go straight to the backend.
+	if err := w.Expire(ctx, "k", 20); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := backend.TTL(ctx, "k"); ttl != 20 {
+		t.Fatalf("expected Expire to reach the backend, got %d", ttl)
+	}
+	w.Put(ctx, "tx", 1)
+	err := w.Tx(ctx, "tx", func(e *Entry) error {
+		e.Value = e.Value.(int) + 1
+		return nil
+	})
+	if v, _ := backend.Get(ctx, "tx"); err != nil || v != 2 {
+		t.Fatalf("expected Tx to see the flushed write, got %v, %v", v, err)
+	}
+
+	// A write that expires while pending deletes the older backend value.
+	w.PutEx(ctx, "k", "short", 1)
+	clk.Advance(2 * time.Second)
+	w.Flush(ctx)
+	if _, err := backend.Get(ctx, "k"); err != ErrNoKey {
+		t.Fatalf("expected the expired write to delete the key, got %v", err)
+	}
+
+	w.Put(ctx, "r", 1)
+	n := 0
+	w.Range(ctx, func(k, v interface{}) error {
+		n++
+		return nil
+	})
+	if n != 2 { // "tx" and "r"
+		t.Fatalf("expected Range to see flushed writes, got %d entries", n)
+	}
+}
+
+// stringKeyCache stores keys by their string form, like mysql.MysqlCache.
+type stringKeyCache struct {
+	*batchCache
+}
+
+func (stringKeyCache) NormalizeKey(k interface{}) interface{} {
+	return fmt.Sprint(k)
+}
+
+func TestWriteBehindNormalizedKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := stringKeyCache{&batchCache{Memory: NewMemory().(*Memory)}}
+	w := NewWriteBehind(backend, WriteBehindConfig{Interval: time.Hour})
+	defer w.Close(ctx)
+
+	w.Put(ctx, 1, "int")
+	w.Put(ctx, "1", "string") // The same row in the backend: replaces the write to 1
+	if n := w.Pending(); n != 1 {
+		t.Fatalf("expected the writes to 1 and \"1\" to coalesce, got %d pending", n)
+	}
+	if v, _ := w.Get(ctx, 1); v != "string" {
+		t.Fatalf("expected the last write through either key, got %v", v)
+	}
+	if err := w.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b := backend.batches; len(b) != 1 || len(b[0]) != 1 || b[0][0].Value != "string" {
+		t.Fatalf("expected one batch with the last write only, got %v", b)
+	}
+}
+
+func TestWriteBehindBytesBackend(t *testing.T) {
+	ctx := context.Background()
+	w := NewWriteBehind(bytesCache{NewMemory().(*Memory)}, WriteBehindConfig{})
+	defer w.Close(ctx)
+
+	w.Put(ctx, "n", 42)
+	if v, _ := w.Get(ctx, "n"); string(v.([]byte)) != "42" {
+		t.Fatalf("expected pending writes in the backend representation, got %#v", v)
+	}
+	var n int
+	if err := w.Scan(ctx, "n", DecodeScanner(&n)); err != nil || n != 42 {
+		t.Fatalf("expected 42, got %d, %v", n, err)
+	}
+}
+
+func TestWriteBehindTriggers(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	backend := &batchCache{Memory: NewMemory(WithClock(clk)).(*Memory)}
+	w := NewWriteBehind(backend, WriteBehindConfig{Clock: clk, Interval: time.Minute, BatchSize: 3})
+	defer w.Close(ctx)
+
+	waitBatches := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for backend.numBatches() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d batches, got %d", n, backend.numBatches())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		w.Put(ctx, i, i)
+	}
+	waitBatches(1) // Size trigger
+	w.Put(ctx, "late", 1)
+	clk.Advance(time.Minute)
+	waitBatches(2) // Interval trigger
+	if v, _ := backend.Get(ctx, "late"); v != 1 {
+		t.Fatalf("expected the interval flush to write late, got %v", v)
+	}
+}
+
+func TestWriteBehindOverflow(t *testing.T) {
+	ctx := context.Background()
+	clk := NewFakeClock(time.Unix(1000, 0))
+	errDown := errors.New("backend down")
+	backend := &batchCache{Memory: NewMemory(WithClock(clk)).(*Memory)}
+	backend.fail(errDown)
+	w := NewWriteBehind(backend, WriteBehindConfig{
+		Clock: clk, Interval: time.Minute, BatchSize: 10, MaxPending: 2, Overflow: OverflowDrop,
+	})
+
+	w.Put(ctx, "a", 1)
+	w.Put(ctx, "b", 1)
+	if err := w.Put(ctx, "a", 2); err != nil {
+		t.Fatalf("expected a write to a pending key to fit, got %v", err)
+	}
+	if err := w.Put(ctx, "c", 1); err != ErrQueueFull || w.Dropped() != 1 {
+		t.Fatalf("expected ErrQueueFull, got %v (dropped %d)", err, w.Dropped())
+	}
+
+	// Failed flushes keep the writes pending.
+	if err := w.Flush(ctx); err != errDown {
+		t.Fatalf("expected the backend error, got %v", err)
+	}
+	if v, _ := w.Get(ctx, "a"); v != 2 || w.Pending() != 2 {
+		t.Fatalf("expected failed writes to stay pending, got %v (%d pending)", v, w.Pending())
+	}
+	backend.fail(nil)
+	if err := w.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := backend.Get(ctx, "a"); v != 2 {
+		t.Fatalf("expected Close to flush, got %v", v)
+	}
+	if err := w.Put(ctx, "a", 3); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestWriteBehindBlock(t *testing.T) {
+	ctx := context.Background()
+	backend := &batchCache{Memory: NewMemory().(*Memory)}
+	w := NewWriteBehind(backend, WriteBehindConfig{Interval: time.Hour, BatchSize: 100, MaxPending: 1})
+	defer w.Close(ctx)
+
+	w.Put(ctx, "a", 1)
+	done := make(chan error)
+	go func() { done <- w.Put(ctx, "b", 1) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a blocked writer to trigger a flush")
+	}
+	w.Flush(ctx)
+	if v, _ := backend.Get(ctx, "a"); v != 1 {
+		t.Fatalf("expected a to be flushed, got %v", v)
+	}
+	if v, _ := backend.Get(ctx, "b"); v != 1 {
+		t.Fatalf("expected b to be flushed, got %v", v)
+	}
+}
@@ -0,0 +1,456 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+)
+
+// ZSetCache is implemented by backends storing a set of members ordered by
+// score under a key, like Redis sorted sets: Memory stores a *ZSet value,
+// mysql.MysqlCache one row per member in a child table indexed by score.
+// Members with equal scores are ordered by member, byte-wise.
+//
+// Sorted sets live in the key space of the cache like hashes, see HashCache: a
+// set created by ZAdd never expires, and removing its last member deletes the key.
+// Scores must be finite; ZAdd returns ErrNotNumber for NaN and infinities.
+type ZSetCache interface {
+	// ZAdd sets the scores of members, creating the set if needed, and returns how many members were added.
+	ZAdd(ctx context.Context, k interface{}, members map[string]float64) (int, error)
+
+	// ZRem removes members and returns how many existed.
+	ZRem(ctx context.Context, k interface{}, members ...string) (int, error)
+
+	// ZScore returns the score of member. It returns ErrNoKey if the key or the member does not exist.
+	ZScore(ctx context.Context, k interface{}, member string) (float64, error)
+
+	// ZRank returns the 0-based position of member in ascending order.
+	// It returns ErrNoKey if the key or the member does not exist.
+	ZRank(ctx context.Context, k interface{}, member string) (int, error)
+
+	// ZRangeByScore returns the members with min <= score <= max in ascending
+	// order, at most limit of them if limit > 0. Bounds may be infinite.
+	// It returns ErrNoKey if the key does not exist.
+	ZRangeByScore(ctx context.Context, k interface{}, min, max float64, limit int) ([]ZMember, error)
+
+	// ZPopMin removes and returns up to count members with the lowest scores.
+	// It returns ErrNoKey if the key does not exist.
+	ZPopMin(ctx context.Context, k interface{}, count int) ([]ZMember, error)
+
+	// ZCard returns the number of members, 0 if the key does not exist.
+	ZCard(ctx context.Context, k interface{}) (int, error)
+}
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// validScore reports whether score can be stored in a sorted set.
+func validScore(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+const zsetMaxLevel = 32
+
+// ZSet is the value of a sorted set key in Memory, as returned by Get: a skiplist
+// ordered by (score, member) with the width of each link, for O(log n) updates
+// and ranks, and a map of member scores. Like Hash and unlike List, a ZSet is
+// updated in place; its methods are safe to call concurrently with updates.
+//
+// A ZSet is saved in snapshots as JSON.
+type ZSet struct {
+	mu     sync.RWMutex
+	scores map[string]float64
+	head   *zsetNode
+	level  int    // Levels in use, at least 1
+	rnd    uint64 // xorshift state for node levels
+	size   int64  // Member bytes plus 8 per score, see Memory.valueSize
+}
+
+type zsetNode struct {
+	member string
+	score  float64
+	level  []zsetLink
+}
+
+type zsetLink struct {
+	next *zsetNode
+	span int // Number of nodes skipped by next, itself included
+}
+
+func newZSet() *ZSet {
+	return &ZSet{
+		scores: make(map[string]float64),
+		head:   &zsetNode{level: make([]zsetLink, zsetMaxLevel)},
+		level:  1,
+		rnd:    0x9e3779b97f4a7c15,
+	}
+}
+
+func init() {
+	RegisterSnapshotCodec((*ZSet)(nil), SnapshotCodec{
+		Name: "cache.ZSet",
+		Encode: func(v interface{}) ([]byte, error) {
+			return json.Marshal(v.(*ZSet).Members())
+		},
+		Decode: func(data []byte) (interface{}, error) {
+			var members []ZMember
+			if err := json.Unmarshal(data, &members); err != nil {
+				return nil, err
+			}
+			zs := newZSet()
+			for _, zm := range members {
+				zs.set(zm.Member, zm.Score)
+			}
+			return zs, nil
+		},
+	})
+}
+
+// Len returns the number of members.
+func (zs *ZSet) Len() int {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	return len(zs.scores)
+}
+
+// Score returns the score of member.
+func (zs *ZSet) Score(member string) (float64, bool) {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	score, ok := zs.scores[member]
+	return score, ok
+}
+
+// Rank returns the 0-based position of member in ascending order.
+func (zs *ZSet) Rank(member string) (int, bool) {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	score, ok := zs.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := 0
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for next := x.level[i].next; next != nil && !zsetAfter(next, score, member); next = x.level[i].next {
+			rank += x.level[i].span
+			x = next
+		}
+	}
+	return rank - 1, true
+}
+
+// RangeByScore returns the members with min <= score <= max in ascending order,
+// at most limit of them if limit > 0.
+func (zs *ZSet) RangeByScore(min, max float64, limit int) []ZMember {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	members := []ZMember{}
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for next := x.level[i].next; next != nil && next.score < min; next = x.level[i].next {
+			x = next
+		}
+	}
+	for x = x.level[0].next; x != nil && x.score <= max; x = x.level[0].next {
+		if limit > 0 && len(members) == limit {
+			break
+		}
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+	}
+	return members
+}
+
+// clone returns a copy of zs. Callers hold mu.
+func (zs *ZSet) clone() *ZSet {
+	c := newZSet()
+	for x := zs.head.level[0].next; x != nil; x = x.level[0].next {
+		c.set(x.member, x.score)
+	}
+	return c
+}
+
+// Members returns all members in ascending order.
+func (zs *ZSet) Members() []ZMember {
+	return zs.RangeByScore(math.Inf(-1), math.Inf(1), 0)
+}
+
+// zsetAfter reports whether node x sorts after (score, member).
+func zsetAfter(x *zsetNode, score float64, member string) bool {
+	return x.score > score || (x.score == score && x.member > member)
+}
+
+// randomLevel returns the level of a new node: 1, then +1 with probability 1/4.
+func (zs *ZSet) randomLevel() int {
+	level := 1
+	for level < zsetMaxLevel {
+		zs.rnd ^= zs.rnd << 13
+		zs.rnd ^= zs.rnd >> 7
+		zs.rnd ^= zs.rnd << 17
+		if zs.rnd&3 != 0 {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// set sets the score of member and reports whether it was added.
+// Callers hold mu, or own zs alone.
+func (zs *ZSet) set(member string, score float64) bool {
+	old, ok := zs.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		zs.unlink(member, old)
+	} else {
+		zs.size += int64(len(member)) + 8
+	}
+	zs.scores[member] = score
+
+	var update [zsetMaxLevel]*zsetNode
+	var rank [zsetMaxLevel]int
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		if i < zs.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := x.level[i].next; next != nil && !zsetAfter(next, score, member); next = x.level[i].next {
+			rank[i] += x.level[i].span
+			x = next
+		}
+		update[i] = x
+	}
+	level := zs.randomLevel()
+	for i := zs.level; i < level; i++ {
+		update[i] = zs.head
+		zs.head.level[i].span = len(zs.scores) - 1 // Nodes before the insert
+	}
+	if level > zs.level {
+		zs.level = level
+	}
+	n := &zsetNode{member: member, score: score, level: make([]zsetLink, level)}
+	for i := 0; i < level; i++ {
+		n.level[i].next = update[i].level[i].next
+		update[i].level[i].next = n
+		n.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zs.level; i++ {
+		update[i].level[i].span++
+	}
+	return !ok
+}
+
+// remove deletes member and reports whether it existed. Callers hold mu.
+func (zs *ZSet) remove(member string) bool {
+	score, ok := zs.scores[member]
+	if !ok {
+		return false
+	}
+	zs.unlink(member, score)
+	delete(zs.scores, member)
+	zs.size -= int64(len(member)) + 8
+	return true
+}
+
+// unlink removes the node of (score, member) from the skiplist.
+func (zs *ZSet) unlink(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+	x := zs.head
+	for i := zs.level - 1; i >= 0; i-- {
+		for next := x.level[i].next; next != nil && (next.score < score || (next.score == score && next.member < member)); next = x.level[i].next {
+			x = next
+		}
+		update[i] = x
+	}
+	n := x.level[0].next
+	for i := 0; i < zs.level; i++ {
+		if update[i].level[i].next == n {
+			update[i].level[i].span += n.level[i].span - 1
+			update[i].level[i].next = n.level[i].next
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	for zs.level > 1 && zs.head.level[zs.level-1].next == nil {
+		zs.level--
+	}
+}
+
+var _ ZSetCache = (*Memory)(nil)
+
+func (m *Memory) ZAdd(ctx context.Context, k interface{}, members map[string]float64) (int, error) {
+	for _, score := range members {
+		if !validScore(score) {
+			return 0, ErrNotNumber
+		}
+	}
+	added := 0
+	err := m.updateZSet(k, len(members) > 0, 0, func(zs *ZSet) {
+		for member, score := range members {
+			if zs.set(member, score) {
+				added++
+			}
+		}
+	})
+	return added, err
+}
+
+func (m *Memory) ZRem(ctx context.Context, k interface{}, members ...string) (int, error) {
+	removed := 0
+	err := m.updateZSet(k, false, len(members), func(zs *ZSet) {
+		for _, member := range members {
+			if zs.remove(member) {
+				removed++
+			}
+		}
+	})
+	return removed, err
+}
+
+func (m *Memory) ZScore(ctx context.Context, k interface{}, member string) (float64, error) {
+	zs, err := m.zset(k)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := zs.Score(member)
+	if !ok {
+		return 0, ErrNoKey
+	}
+	return score, nil
+}
+
+func (m *Memory) ZRank(ctx context.Context, k interface{}, member string) (int, error) {
+	zs, err := m.zset(k)
+	if err != nil {
+		return 0, err
+	}
+	rank, ok := zs.Rank(member)
+	if !ok {
+		return 0, ErrNoKey
+	}
+	return rank, nil
+}
+
+func (m *Memory) ZRangeByScore(ctx context.Context, k interface{}, min, max float64, limit int) ([]ZMember, error) {
+	zs, err := m.zset(k)
+	if err != nil {
+		return nil, err
+	}
+	return zs.RangeByScore(min, max, limit), nil
+}
+
+func (m *Memory) ZPopMin(ctx context.Context, k interface{}, count int) ([]ZMember, error) {
+	popped := []ZMember{}
+	err := ErrNoKey
+	uerr := m.updateZSet(k, false, count, func(zs *ZSet) {
+		err = nil
+		for ; count > 0; count-- {
+			first := zs.head.level[0].next
+			if first == nil {
+				break
+			}
+			popped = append(popped, ZMember{Member: first.member, Score: first.score})
+			zs.remove(first.member)
+		}
+	})
+	if uerr != nil {
+		return nil, uerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return popped, nil
+}
+
+func (m *Memory) ZCard(ctx context.Context, k interface{}) (int, error) {
+	zs, err := m.zset(k)
+	if err == ErrNoKey {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return zs.Len(), nil
+}
+
+// zset returns the live ZSet stored under k.
+func (m *Memory) zset(k interface{}) (*ZSet, error) {
+	if !m.initialized() {
+		return nil, ErrNoKey
+	}
+	keyStr, b := m.bucketFor(k)
+	e, ok := b.load(keyStr)
+	if !ok || e == nil || e.Expired() {
+		return nil, ErrNoKey
+	}
+	zs, ok := e.Value.(*ZSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zs, nil
+}
+
+// updateZSet runs fn on the ZSet stored under k, holding the bucket lock and
+// the ZSet lock, like updateHash: a missing or expired key is created if create
+// is set, and a set left empty is deleted, reported with the members it had if
+// fn removes up to removing members.
+func (m *Memory) updateZSet(k interface{}, create bool, removing int, fn func(zs *ZSet)) error {
+	if err := m.ensureStarted(); err != nil {
+		return err
+	}
+	if m.storage == StorageArena {
+		return ErrArenaValue
+	}
+
+	keyStr, b := m.bucketFor(k)
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	e, ok := b.get(keyStr)
+	if !ok || e == nil || e.Expired() {
+		if !create {
+			return nil
+		}
+		zs := newZSet()
+		fn(zs)
+		nowTime := m.now()
+		b.set(keyStr, &Entry{CreatedAt: nowTime, ExpiredAt: ExpiredAt(nowTime, NoExpiration), Value: zs, clock: m.clock})
+		return nil
+	}
+	zs, ok := e.Value.(*ZSet)
+	if !ok {
+		return ErrWrongType
+	}
+
+	zs.mu.Lock()
+	var prev *ZSet
+	if removing >= len(zs.scores) && m.hasHandler(ReasonDeleted) {
+		prev = zs.clone()
+	}
+	fn(zs)
+	empty := len(zs.scores) == 0
+	zs.mu.Unlock()
+
+	if empty {
+		b.remove(e)
+		gone := *e
+		gone.Value = prev
+		b.emit(k, &gone, ReasonDeleted)
+		return nil
+	}
+	if b.bounded() {
+		b.policy.access(e)
+	}
+	e = b.writable(e)
+	e.Version = m.nextVersion()
+	b.resize(e)
+	return nil
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryZSet(t *testing.T) {
+	for name, opts := range map[string][]interface{}{
+		"heap":     nil,
+		"bounded":  {`{"maxEntries": 1000}`},
+		"lockfree": {WithLockFreeReads()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clk := NewFakeClock(time.Unix(1000, 0))
+			c := NewMemory(append(opts, WithClock(clk))...).(*Memory)
+			defer c.Close(ctx)
+
+			if n, err := c.ZAdd(ctx, "z", map[string]float64{"a": 3, "b": 1, "c": 2, "d": 2}); err != nil || n != 4 {
+				t.Fatalf("expected 4 members added, got %d, %v", n, err)
+			}
+			if n, _ := c.ZAdd(ctx, "z", map[string]float64{"a": 0, "e": 5}); n != 1 {
+				t.Fatalf("expected 1 member added, got %d", n)
+			}
+			if s, err := c.ZScore(ctx, "z", "a"); err != nil || s != 0 {
+				t.Fatalf("expected 0, got %v, %v", s, err)
+			}
+			if r, _ := c.ZRank(ctx, "z", "d"); r != 3 {
+				t.Fatalf("expected d after a, b and c, got rank %d", r)
+			}
+			if _, err := c.ZRank(ctx, "z", "missing"); err != ErrNoKey {
+				t.Fatalf("expected ErrNoKey, got %v", err)
+			}
+			got, _ := c.ZRangeByScore(ctx, "z", 1, 2, 0)
+			if want := []ZMember{{"b", 1}, {"c", 2}, {"d", 2}}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+			got, _ = c.ZRangeByScore(ctx, "z", math.Inf(-1), math.Inf(1), 2)
+			if want := []ZMember{{"a", 0}, {"b", 1}}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+
+			if n, _ := c.ZRem(ctx, "z", "c", "missing"); n != 1 {
+				t.Fatalf("expected 1 member removed, got %d", n)
+			}
+			got, _ = c.ZPopMin(ctx, "z", 2)
+			if want := []ZMember{{"a", 0}, {"b", 1}}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+			if n, _ := c.ZCard(ctx, "z"); n != 2 {
+				t.Fatalf("expected 2 members, got %d", n)
+			}
+			c.ZPopMin(ctx, "z", 10)
+			if _, err := c.Get(ctx, "z"); err != ErrNoKey {
+				t.Fatalf("expected popping the last member to delete the key, got %v", err)
+			}
+			if _, err := c.ZPopMin(ctx, "z", 1); err != ErrNoKey {
+				t.Fatalf("expected ErrNoKey, got %v", err)
+			}
+
+			c.ZAdd(ctx, "e", map[string]float64{"m": 1})
+			c.Expire(ctx, "e", 1)
+			clk.Advance(2 * time.Second)
+			if n, _ := c.ZCard(ctx, "e"); n != 0 {
+				t.Fatalf("expected an expired set to be empty, got %d", n)
+			}
+
+			if _, err := c.ZAdd(ctx, "z", map[string]float64{"x": math.NaN()}); err != ErrNotNumber {
+				t.Fatalf("expected ErrNotNumber, got %v", err)
+			}
+			c.Put(ctx, "s", "value")
+			if _, err := c.ZAdd(ctx, "s", map[string]float64{"x": 1}); err != ErrWrongType {
+				t.Fatalf("expected ErrWrongType, got %v", err)
+			}
+		})
+	}
+}
+
+func TestZSetRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	zs := newZSet()
+	ref := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(r.Intn(500))
+		if r.Intn(4) == 0 {
+			if zs.remove(member) != (ref[member] != 0) {
+				t.Fatalf("remove(%s) disagrees with the reference", member)
+			}
+			delete(ref, member)
+			continue
+		}
+		score := float64(r.Intn(100) + 1) // Never 0, see above
+		zs.set(member, score)
+		ref[member] = score
+	}
+
+	want := make([]ZMember, 0, len(ref))
+	for member, score := range ref {
+		want = append(want, ZMember{member, score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Score < want[j].Score || (want[i].Score == want[j].Score && want[i].Member < want[j].Member)
+	})
+	if got := zs.Members(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("members out of order")
+	}
+	for i, zm := range want {
+		if rank, ok := zs.Rank(zm.Member); !ok || rank != i {
+			t.Fatalf("expected rank %d for %s, got %d", i, zm.Member, rank)
+		}
+	}
+	if got := zs.RangeByScore(10, 20, 0); len(got) > 0 && (got[0].Score < 10 || got[len(got)-1].Score > 20) {
+		t.Fatalf("range out of bounds: %v", got)
+	}
+}
+
+func TestMemoryZSetSnapshot(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+	c.ZAdd(ctx, "z", map[string]float64{"a": 1.5, "b": -2})
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := NewMemory().(*Memory)
+	defer c2.Close(ctx)
+	if err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c2.ZRangeByScore(ctx, "z", math.Inf(-1), math.Inf(1), 0)
+	if want := []ZMember{{"b", -2}, {"a", 1.5}}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the set to be restored, got %v, %v", got, err)
+	}
+}
+
+func TestMemoryZSetDeleteEvent(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().(*Memory)
+	defer c.Close(ctx)
+	events := make(chan ExpireEvent, 2)
+	c.ExpireEventHandler(func(ev ExpireEvent) { events <- ev })
+
+	c.ZAdd(ctx, "z", map[string]float64{"a": 1, "b": 2})
+	c.ZRem(ctx, "z", "a", "b")
+	c.ZAdd(ctx, "q", map[string]float64{"a": 1})
+	c.ZPopMin(ctx, "q", 5)
+	for _, want := range [][]ZMember{{{"a", 1}, {"b", 2}}, {{"a", 1}}} {
+		ev := <-events
+		zs, ok := ev.Value.(*ZSet)
+		if ev.Reason != ReasonDeleted || !ok || !reflect.DeepEqual(zs.Members(), want) {
+			t.Fatalf("expected the deleted set with %v, got %+v", want, ev)
+		}
+	}
+}
+
+func TestMemoryZSetArena(t *testing.T) {
+	c := NewMemory(WithArena(1 << 20)).(*Memory)
+	defer c.Close(context.Background())
+	if _, err := c.ZAdd(context.Background(), "z", map[string]float64{"m": 1}); err != ErrArenaValue {
+		t.Fatalf("expected ErrArenaValue, got %v", err)
+	}
+}